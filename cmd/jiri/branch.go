@@ -5,9 +5,13 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 
 	"fuchsia.googlesource.com/jiri"
@@ -20,17 +24,44 @@ var branchFlags struct {
 	deleteFlag      bool
 	forceDeleteFlag bool
 	listFlag        bool
+	createFlag      bool
+	checkoutFlag    bool
+	pruneFlag       bool
+	forceFlag       bool
+	jsonFlag        bool
+	porcelainFlag   bool
+	selectorFlag    string
 }
 
 var cmdBranch = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runBranch),
 	Name:   "branch",
-	Short:  "Show or delete branches",
+	Short:  "Show, create, checkout or delete branches",
 	Long: `
 Show all the projects having branch <branch> .If -d or -D is passed, <branch>
-is deleted. if <branch> is not passed, show all projects which have branches other than "master"`,
-	ArgsName: "<branch>",
-	ArgsLong: "<branch> is the name branch",
+is deleted. if <branch> is not passed, show all projects which have branches other than "master"
+
+If -create is passed, <branch> is created at the manifest revision in the given
+projects, or in the current project if none are given, set up to track the
+manifest remote branch and checked out.
+
+If -checkout is passed, <branch> is checked out in every project having it, and
+all other projects are checked out at their manifest revision.
+
+If -prune is passed, local branches whose commits are all contained in their
+tracking branch (or the manifest revision for untracked branches), or whose
+Change-Ids are all merged in Gerrit, are deleted after confirmation.  The
+current branch and branches with unpushed commits are never deleted.
+
+With -json or -porcelain, every project (or every project having <branch>) is
+printed in a machine-readable format along with its local branches.
+` + porcelainHelp,
+	ArgsName: "<branch> [<project>...]",
+	ArgsLong: `
+<branch> is the name branch
+<project> is a project name or a regular expression matching project names,
+only used with -create.  With -select, only the projects matching the selector
+are used, and -create creates <branch> in all of them if no <project> is given.`,
 }
 
 func init() {
@@ -38,6 +69,13 @@ func init() {
 	flags.BoolVar(&branchFlags.deleteFlag, "d", false, "Delete branch from project. Similar to running 'git branch -d <branch-name>'")
 	flags.BoolVar(&branchFlags.forceDeleteFlag, "D", false, "Force delete branch from project. Similar to running 'git branch -D <branch-name>'")
 	flags.BoolVar(&branchFlags.listFlag, "list", false, "Show only projects with current branch <branch>")
+	flags.BoolVar(&branchFlags.createFlag, "create", false, "Create branch <branch> at the manifest revision in the given projects and check it out.")
+	flags.BoolVar(&branchFlags.checkoutFlag, "checkout", false, "Checkout branch <branch> in all projects having it, and the manifest revision elsewhere.")
+	flags.BoolVar(&branchFlags.pruneFlag, "prune", false, "Delete local branches which are merged upstream.")
+	flags.BoolVar(&branchFlags.forceFlag, "f", false, "Do not ask for confirmation when used with -prune.")
+	flags.StringVar(&branchFlags.selectorFlag, "select", "", selectorFlagHelp)
+	flags.BoolVar(&branchFlags.jsonFlag, "json", false, "Print projects and their branches as JSON.")
+	flags.BoolVar(&branchFlags.porcelainFlag, "porcelain", false, "Print projects and their branches in a stable, easy to parse format.")
 }
 
 func displayProjects(jirix *jiri.X, branch string) error {
@@ -46,7 +84,7 @@ func displayProjects(jirix *jiri.X, branch string) error {
 		return err
 	}
 	jirix.TimerPush("Get states")
-	states, err := project.GetProjectStates(jirix, localProjects, false)
+	states, err := selectStates(jirix, localProjects, branchFlags.selectorFlag, false)
 	if err != nil {
 		return err
 	}
@@ -100,14 +138,70 @@ func displayProjects(jirix *jiri.X, branch string) error {
 	return nil
 }
 
+func displayProjectsMachineReadable(jirix *jiri.X, branch string) error {
+	selector, err := parseSelector(jirix, branchFlags.selectorFlag)
+	if err != nil {
+		return err
+	}
+	statuses, err := projectStatuses(jirix, true, func(state *project.ProjectState) bool {
+		if !selector.Match(state.Project, state) {
+			return false
+		}
+		if branch == "" {
+			return true
+		}
+		if branchFlags.listFlag {
+			return state.CurrentBranch.Name == branch
+		}
+		for _, b := range state.Branches {
+			if b.Name == branch {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if branchFlags.jsonFlag {
+		return writeStatusJSON(jirix.Stdout(), statuses)
+	}
+	writeStatusPorcelain(jirix.Stdout(), statuses, true)
+	return nil
+}
+
 func runBranch(jirix *jiri.X, args []string) error {
+	if branchFlags.pruneFlag {
+		if len(args) > 0 {
+			return jirix.UsageErrorf("-prune does not take arguments")
+		}
+		return pruneBranches(jirix)
+	}
+	if branchFlags.createFlag {
+		if len(args) == 0 {
+			return jirix.UsageErrorf("Please provide branch to create")
+		}
+		return createBranches(jirix, args[0], args[1:])
+	}
 	branch := ""
 	if len(args) > 1 {
 		return jirix.UsageErrorf("Please provide only one branch")
 	} else if len(args) == 1 {
 		branch = args[0]
 	}
+	if branchFlags.checkoutFlag {
+		if branch == "" {
+			return jirix.UsageErrorf("Please provide branch to checkout")
+		}
+		return checkoutBranches(jirix, branch)
+	}
 	if !branchFlags.deleteFlag && !branchFlags.forceDeleteFlag {
+		if branchFlags.jsonFlag && branchFlags.porcelainFlag {
+			return jirix.UsageErrorf("-json and -porcelain cannot be used together")
+		}
+		if branchFlags.jsonFlag || branchFlags.porcelainFlag {
+			return displayProjectsMachineReadable(jirix, branch)
+		}
 		return displayProjects(jirix, branch)
 	}
 	if branch == "" {
@@ -116,6 +210,319 @@ func runBranch(jirix *jiri.X, args []string) error {
 	return deleteBranches(jirix, branch)
 }
 
+func createBranches(jirix *jiri.X, branchToCreate string, patterns []string) error {
+	var projects []project.Project
+	if len(patterns) == 0 && branchFlags.selectorFlag == "" {
+		p, err := currentProject(jirix)
+		if err != nil {
+			return err
+		}
+		projects = append(projects, p)
+	} else {
+		filter, err := project.NewProjectFilter(patterns)
+		if err != nil {
+			return err
+		}
+		selector, err := parseSelector(jirix, branchFlags.selectorFlag)
+		if err != nil {
+			return err
+		}
+		localProjects, err := project.LocalProjects(jirix, project.FastScan)
+		if err != nil {
+			return err
+		}
+		if localProjects, err = selector.Select(jirix, localProjects); err != nil {
+			return err
+		}
+		for _, p := range localProjects {
+			if filter.MatchName(p.Name) {
+				projects = append(projects, p)
+			}
+		}
+		if len(projects) == 0 {
+			return fmt.Errorf("no project matches %q", strings.TrimSpace(strings.Join(patterns, " ")+" "+branchFlags.selectorFlag))
+		}
+	}
+	sort.Sort(project.ProjectsByPath(projects))
+	cDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	errors := false
+	for _, p := range projects {
+		relativePath, err := filepath.Rel(cDir, p.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Project %s(%s): ", p.Name, relativePath)
+		if gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).BranchExists(branchToCreate) {
+			errors = true
+			fmt.Print(jirix.Color.Red("Branch %q already exists\n", branchToCreate))
+			continue
+		}
+		if err := project.StartBranch(jirix, p, branchToCreate); err != nil {
+			errors = true
+			fmt.Print(jirix.Color.Red("Error while creating branch: %s\n", err))
+			continue
+		}
+		fmt.Printf("%s\n", jirix.Color.Green("Created branch %s", branchToCreate))
+	}
+	if errors {
+		fmt.Println(jirix.Color.Yellow("Please check errors above"))
+	}
+	return nil
+}
+
+func checkoutBranches(jirix *jiri.X, branchToCheckout string) error {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	cDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	jirix.TimerPush("Get states")
+	states, err := selectStates(jirix, localProjects, branchFlags.selectorFlag, false)
+	if err != nil {
+		return err
+	}
+	jirix.TimerPop()
+
+	jirix.TimerPush("Process")
+	defer jirix.TimerPop()
+	keys := project.ProjectKeys{}
+	hasBranch := make(map[project.ProjectKey]bool)
+	for key, state := range states {
+		keys = append(keys, key)
+		for _, b := range state.Branches {
+			if b.Name == branchToCheckout {
+				hasBranch[key] = true
+				break
+			}
+		}
+	}
+	if len(hasBranch) == 0 {
+		fmt.Printf("Cannot find any project with branch %q\n", branchToCheckout)
+		return nil
+	}
+	sort.Sort(keys)
+	errors := false
+	onBranch, detached := 0, 0
+	for _, key := range keys {
+		state := states[key]
+		localProject := state.Project
+		if localProject.LocalConfig.Ignore {
+			continue
+		}
+		revision := branchToCheckout
+		git := gitutil.New(jirix, gitutil.RootDirOpt(localProject.Path))
+		if hasBranch[key] {
+			if state.CurrentBranch.Name == branchToCheckout {
+				onBranch++
+				continue
+			}
+			err = git.CheckoutBranch(branchToCheckout)
+		} else {
+			if revision, err = project.GetHeadRevision(jirix, localProject); err == nil {
+				err = git.CheckoutBranch(revision, gitutil.DetachOpt(true))
+			}
+		}
+		if err != nil {
+			errors = true
+			relativePath, err2 := filepath.Rel(cDir, localProject.Path)
+			if err2 != nil {
+				return err2
+			}
+			fmt.Printf("Project %s(%s): %s", localProject.Name, relativePath, jirix.Color.Red("Error while checking out %s: %s\n", revision, err))
+			continue
+		}
+		if hasBranch[key] {
+			onBranch++
+		} else {
+			detached++
+		}
+	}
+	fmt.Printf("%d project(s) on branch %s, %d project(s) at their manifest revision\n", onBranch, jirix.Color.Green("%s", branchToCheckout), detached)
+	if errors {
+		fmt.Println(jirix.Color.Yellow("Please check errors above"))
+	}
+	return nil
+}
+
+// prunableBranch is a local branch which is merged upstream.
+type prunableBranch struct {
+	project  project.Project
+	branch   string
+	revision string
+	reason   string
+}
+
+var changeIDRE = regexp.MustCompile(`^Change-Id: (I[0-9a-fA-F]+)$`)
+
+// changeID returns the last Change-Id footer of a commit message given as
+// lines, or "" if it has none.
+func changeID(message []string) string {
+	id := ""
+	for _, line := range message {
+		if m := changeIDRE.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			id = m[1]
+		}
+	}
+	return id
+}
+
+// branchBase returns the revision a local branch of p is based on: its
+// tracking branch, or the revision of p in the manifest if it has none.
+func branchBase(jirix *jiri.X, p project.Project, branch project.BranchState) (string, error) {
+	if branch.Tracking != nil {
+		return branch.Tracking.Name, nil
+	}
+	return project.GetHeadRevision(jirix, p)
+}
+
+// mergedReason returns why branch of project p can be pruned, or "" if it
+// has commits which are not merged upstream.
+func mergedReason(jirix *jiri.X, p project.Project, branch project.BranchState) (string, error) {
+	base, err := branchBase(jirix, p, branch)
+	if err != nil {
+		return "", err
+	}
+	// Each commit is logged as its revision followed by its message.
+	commits, err := gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).Log(branch.Name, base, "%H%n%B")
+	if err != nil {
+		return "", err
+	}
+	if len(commits) == 0 {
+		return fmt.Sprintf("merged into %s", base), nil
+	}
+	if p.GerritHost == "" {
+		return "", nil
+	}
+	var ids []string
+	revisions := make(map[string]string)
+	for _, lines := range commits {
+		id := changeID(lines[1:])
+		if id == "" {
+			// Commits without Change-Id were never uploaded.
+			return "", nil
+		}
+		ids = append(ids, id)
+		revisions[id] = lines[0]
+	}
+	hostURL, err := url.Parse(p.GerritHost)
+	if err != nil {
+		return "", fmt.Errorf("invalid Gerrit host %q: %v", p.GerritHost, err)
+	}
+	changes, err := jirix.Gerrit(hostURL).Query(fmt.Sprintf("status:merged branch:%s (change:%s)", p.RemoteBranch, strings.Join(ids, " OR change:")))
+	if err != nil {
+		return "", err
+	}
+	merged := make(map[string]bool)
+	for _, c := range changes {
+		// Cherry-picks to other projects or branches keep the Change-Id.
+		if c.Branch != p.RemoteBranch || !strings.HasSuffix(p.Remote, "/"+c.Project) {
+			continue
+		}
+		// A local commit amended after the change was merged has the same
+		// Change-Id but was never uploaded.
+		rev := revisions[c.Change_id]
+		if _, ok := c.Revisions[rev]; ok || c.Current_revision == rev {
+			merged[c.Change_id] = true
+		}
+	}
+	for _, id := range ids {
+		if !merged[id] {
+			return "", nil
+		}
+	}
+	return "changes merged in Gerrit", nil
+}
+
+func pruneBranches(jirix *jiri.X) error {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	cDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	jirix.TimerPush("Get states")
+	states, err := selectStates(jirix, localProjects, branchFlags.selectorFlag, false)
+	if err != nil {
+		return err
+	}
+	jirix.TimerPop()
+
+	jirix.TimerPush("Find merged branches")
+	keys := project.ProjectKeys{}
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	var branches []prunableBranch
+	for _, key := range keys {
+		state := states[key]
+		for _, branch := range state.Branches {
+			if branch.Name == state.CurrentBranch.Name {
+				continue
+			}
+			reason, err := mergedReason(jirix, state.Project, branch)
+			if err != nil {
+				fmt.Print(jirix.Color.Red("Project %s: not able to check branch %q: %s\n", state.Project.Name, branch.Name, err))
+				continue
+			}
+			if reason != "" {
+				branches = append(branches, prunableBranch{state.Project, branch.Name, branch.Revision, reason})
+			}
+		}
+	}
+	jirix.TimerPop()
+
+	if len(branches) == 0 {
+		fmt.Println("No branches to prune")
+		return nil
+	}
+	relativePaths := make([]string, len(branches))
+	for i, b := range branches {
+		if relativePaths[i], err = filepath.Rel(cDir, b.project.Path); err != nil {
+			return err
+		}
+		fmt.Printf("Project %s(%s): %s (%s)\n", b.project.Name, relativePaths[i], b.branch, b.reason)
+	}
+	if !branchFlags.forceFlag {
+		fmt.Printf("Delete these %d branch(es)? [y/N] ", len(branches))
+		answer, _ := bufio.NewReader(jirix.Stdin()).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Not deleting any branch")
+			return nil
+		}
+	}
+
+	errors := false
+	for i, b := range branches {
+		fmt.Printf("Project %s(%s): ", b.project.Name, relativePaths[i])
+		git := gitutil.New(jirix, gitutil.RootDirOpt(b.project.Path))
+		// The commits may have been cherry-picked upstream, so git cannot tell
+		// that the branch is merged.
+		if err := git.DeleteBranch(b.branch, gitutil.ForceOpt(true)); err != nil {
+			errors = true
+			fmt.Print(jirix.Color.Red("Error while deleting branch: %s\n", err))
+			continue
+		}
+		shortHash, err := git.GetShortHash(b.revision)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (was %s)\n", jirix.Color.Green("Deleted Branch %s", b.branch), jirix.Color.Yellow("%s", shortHash))
+	}
+	if errors {
+		fmt.Println(jirix.Color.Yellow("Please check errors above"))
+	}
+	return nil
+}
+
 func deleteBranches(jirix *jiri.X, branchToDelete string) error {
 	localProjects, err := project.LocalProjects(jirix, project.FastScan)
 	if err != nil {
@@ -126,7 +533,7 @@ func deleteBranches(jirix *jiri.X, branchToDelete string) error {
 		return err
 	}
 	jirix.TimerPush("Get states")
-	states, err := project.GetProjectStates(jirix, localProjects, false)
+	states, err := selectStates(jirix, localProjects, branchFlags.selectorFlag, false)
 	if err != nil {
 		return err
 	}
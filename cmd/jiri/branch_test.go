@@ -13,6 +13,7 @@ import (
 	"strings"
 	"testing"
 
+	"fuchsia.googlesource.com/jiri/git"
 	"fuchsia.googlesource.com/jiri/gitutil"
 	"fuchsia.googlesource.com/jiri/jiritest"
 	"fuchsia.googlesource.com/jiri/project"
@@ -22,6 +23,12 @@ func setDefaultBranchFlags() {
 	branchFlags.forceDeleteFlag = false
 	branchFlags.deleteFlag = false
 	branchFlags.listFlag = false
+	branchFlags.createFlag = false
+	branchFlags.checkoutFlag = false
+	branchFlags.pruneFlag = false
+	branchFlags.forceFlag = false
+	branchFlags.jsonFlag = false
+	branchFlags.porcelainFlag = false
 }
 
 func createBranchCommits(t *testing.T, fake *jiritest.FakeJiriRoot, localProjects []project.Project) {
@@ -286,6 +293,167 @@ func TestDeleteBranch(t *testing.T) {
 	}
 }
 
+func TestCreateAndCheckoutBranch(t *testing.T) {
+	setDefaultBranchFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	numProjects := 3
+	localProjects := createBranchProjects(t, fake, numProjects)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	projects := make(project.Projects)
+	for _, localProject := range localProjects {
+		projects[localProject.Key()] = localProject
+	}
+	testBranch := "testBranch"
+
+	// Create the branch in the first two projects only.
+	branchFlags.createFlag = true
+	executeBranch(t, fake, testBranch, localProjects[0].Name, "project-1")
+	states, err := project.GetProjectStates(fake.X, projects, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, localProject := range localProjects {
+		state := states[localProject.Key()]
+		if i == 2 {
+			if state.CurrentBranch.Name != "" || len(state.Branches) != 1 {
+				t.Errorf("project %q should not have branch %q", localProject.Name, testBranch)
+			}
+			continue
+		}
+		if state.CurrentBranch.Name != testBranch {
+			t.Errorf("project %q is on branch %q, want %q", localProject.Name, state.CurrentBranch.Name, testBranch)
+		}
+		if state.CurrentBranch.Tracking == nil || state.CurrentBranch.Tracking.Name != "origin/master" {
+			t.Errorf("branch %q of project %q should track origin/master, got %+v", testBranch, localProject.Name, state.CurrentBranch.Tracking)
+		}
+	}
+
+	// Move project-1 off the branch and check it out everywhere again.
+	gitLocal := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[1].Path))
+	if err := gitLocal.CheckoutBranch("master"); err != nil {
+		t.Fatal(err)
+	}
+	setDefaultBranchFlags()
+	branchFlags.checkoutFlag = true
+	executeBranch(t, fake, testBranch)
+	states, err = project.GetProjectStates(fake.X, projects, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, localProject := range localProjects {
+		want := testBranch
+		if i == 2 {
+			want = ""
+		}
+		if got := states[localProject.Key()].CurrentBranch.Name; got != want {
+			t.Errorf("project %q is on branch %q, want %q", localProject.Name, got, want)
+		}
+	}
+}
+
+func TestPruneBranches(t *testing.T) {
+	setDefaultBranchFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	numProjects := 3
+	localProjects := createBranchProjects(t, fake, numProjects)
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	gitLocals := make([]*gitutil.Git, numProjects)
+	for i, localProject := range localProjects {
+		gitLocals[i] = gitutil.New(fake.X, gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"), gitutil.RootDirOpt(localProject.Path))
+	}
+	testBranch := "testBranch"
+
+	// Merged branch.
+	gitLocals[0].CreateBranch(testBranch)
+
+	// Branch with an unpushed commit.
+	gitLocals[1].CreateBranch(testBranch)
+	gitLocals[1].CheckoutBranch(testBranch)
+	writeFile(t, fake.X, localProjects[1].Path, "extrafile", "extrafile")
+	gitLocals[1].CheckoutBranch("master")
+
+	// Merged, but current branch.
+	gitLocals[2].CreateBranch(testBranch)
+	gitLocals[2].CheckoutBranch(testBranch)
+
+	branchFlags.pruneFlag = true
+	branchFlags.forceFlag = true
+	executeBranch(t, fake)
+
+	for i, want := range []bool{false, true, true} {
+		if got := gitLocals[i].BranchExists(testBranch); got != want {
+			t.Errorf("project %q: branch %q exists: %v, want %v", localProjects[i].Name, testBranch, got, want)
+		}
+	}
+}
+
+// TestPruneBranchesGerrit checks that branches whose changes are merged in
+// Gerrit are pruned, and that changes merged to another project or branch
+// with the same Change-Id do not count.
+func TestPruneBranchesGerrit(t *testing.T) {
+	setDefaultBranchFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var queries []string
+	changes := "[]"
+	server := newFakeGerrit(&changes, &queries)
+	defer server.Close()
+	projects := makeGerritProjects(t, fake, server.URL)
+
+	p := *projects[0]
+	revisions := make(map[string]string)
+	for _, c := range []struct{ branch, id string }{
+		{"merged", "I1111111111111111111111111111111111111111"},
+		{"other-project", "I2222222222222222222222222222222222222222"},
+		{"other-branch", "I3333333333333333333333333333333333333333"},
+		{"amended", "I4444444444444444444444444444444444444444"},
+	} {
+		if err := project.StartBranch(fake.X, p, c.branch); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, fake.X, p.Path, c.branch, c.branch+"\n\nChange-Id: "+c.id+"\n")
+		rev, err := git.NewGit(p.Path).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		revisions[c.branch] = rev
+	}
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).CheckoutBranch("master"); err != nil {
+		t.Fatal(err)
+	}
+	// The change merged for "amended" is not the commit on the local branch.
+	changes = fmt.Sprintf(`[
+		{"change_id": "I1111111111111111111111111111111111111111", "_number": 1, "project": "r.a", "branch": "master", "status": "MERGED", "current_revision": %q},
+		{"change_id": "I2222222222222222222222222222222222222222", "_number": 2, "project": "r.b", "branch": "master", "status": "MERGED", "current_revision": %q},
+		{"change_id": "I3333333333333333333333333333333333333333", "_number": 3, "project": "r.a", "branch": "release", "status": "MERGED", "current_revision": %q},
+		{"change_id": "I4444444444444444444444444444444444444444", "_number": 4, "project": "r.a", "branch": "master", "status": "MERGED", "current_revision": %q}
+	]`, revisions["merged"], revisions["other-project"], revisions["other-branch"], revisions["merged"])
+
+	branchFlags.pruneFlag = true
+	branchFlags.forceFlag = true
+	executeBranch(t, fake)
+
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	for branch, want := range map[string]bool{"merged": false, "other-project": true, "other-branch": true, "amended": true} {
+		if got := scm.BranchExists(branch); got != want {
+			t.Errorf("branch %q exists: %v, want %v", branch, got, want)
+		}
+	}
+	for _, q := range queries {
+		if !strings.Contains(q, "branch:master") {
+			t.Errorf("query %q is not restricted to branch master", q)
+		}
+	}
+}
+
 func equalBranchOut(first, second string) bool {
 	second = strings.TrimSpace(second)
 	firstStrings := strings.Split(first, "\n")
@@ -0,0 +1,546 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/cmdline"
+	"fuchsia.googlesource.com/jiri/gerrit"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+var clListFlags struct {
+	json bool
+}
+
+var clStatusFlags struct {
+	json bool
+}
+
+func init() {
+	cmdCLList.Flags.BoolVar(&clListFlags.json, "json", false, "Print the changes as a JSON list.")
+	cmdCLStatus.Flags.BoolVar(&clStatusFlags.json, "json", false, "Print the branches as a JSON list.")
+}
+
+// cmdCL represents the "jiri cl" command.
+var cmdCL = &cmdline.Command{
+	Name:  "cl",
+	Short: "Manage changelists across projects",
+	Long: `
+Manage the changelists of the local projects on the Gerrit hosts referenced by
+the manifest.
+`,
+	Children: []*cmdline.Command{cmdCLList, cmdCLStatus},
+}
+
+var cmdCLList = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runCLList),
+	Name:   "list",
+	Short:  "List your open changes across projects",
+	Long: `
+Lists the open changes owned by you on every Gerrit host referenced by the
+manifest.  Each change is shown with its status, its Code-Review and Verified
+votes, its topic and its URL, along with the local project and branch holding
+a commit with its Change-Id, if any.
+`,
+}
+
+var cmdCLStatus = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runCLStatus),
+	Name:   "status",
+	Short:  "Show the review status of local branches",
+	Long: `
+Shows, for each local branch of the projects with a Gerrit host, the commits
+which are not merged upstream and the state of their change in Gerrit, found
+with their Change-Id:
+  not-uploaded  there is no change with the Change-Id of the commit
+  up-to-date    the current patchset of the change is the commit
+  outdated      the current patchset of the change is another commit
+  merged        the change is merged
+  abandoned     the change is abandoned
+
+A branch needs to be uploaded if one of its commits is not uploaded or
+outdated, and can be pruned if all its changes are merged or abandoned.
+Branches whose commits are all merged upstream are not shown.
+`,
+}
+
+// clChange is a Gerrit change in the output of "jiri cl".
+type clChange struct {
+	// Project and Path describe the local project of the change.  They
+	// are empty if there is no local project for the change.
+	Project string `json:"project,omitempty"`
+	Path    string `json:"path,omitempty"`
+	// Branch is the local branch with a commit having the Change-Id of
+	// the change, if any.
+	Branch        string `json:"branch,omitempty"`
+	GerritProject string `json:"gerrit_project"`
+	Number        int    `json:"number"`
+	ChangeID      string `json:"change_id"`
+	Status        string `json:"status"`
+	Subject       string `json:"subject"`
+	Topic         string `json:"topic,omitempty"`
+	CodeReview    int    `json:"code_review"`
+	Verified      int    `json:"verified"`
+	URL           string `json:"url"`
+}
+
+type clChangesByProject []clChange
+
+func (c clChangesByProject) Len() int      { return len(c) }
+func (c clChangesByProject) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c clChangesByProject) Less(i, j int) bool {
+	if c[i].Project != c[j].Project {
+		return c[i].Project < c[j].Project
+	}
+	if c[i].Branch != c[j].Branch {
+		return c[i].Branch < c[j].Branch
+	}
+	return c[i].Number < c[j].Number
+}
+
+// newCLChange returns the clChange describing change on Gerrit g.
+func newCLChange(g *gerrit.Gerrit, change gerrit.Change) clChange {
+	return clChange{
+		GerritProject: change.Project,
+		Number:        change.Number,
+		ChangeID:      change.Change_id,
+		Status:        change.Status,
+		Subject:       change.Subject,
+		Topic:         change.Topic,
+		CodeReview:    change.Vote("Code-Review"),
+		Verified:      change.Vote("Verified"),
+		URL:           g.GetChangeURL(change.Number),
+	}
+}
+
+// clProjects returns the local projects which have a Gerrit host in the
+// manifest, keyed by their Gerrit host.
+func clProjects(jirix *jiri.X) (map[string]project.Projects, error) {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	remoteProjects, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, false /*localManifest*/)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]project.Projects)
+	for key, remote := range remoteProjects {
+		local, ok := localProjects[key]
+		if !ok || remote.GerritHost == "" {
+			continue
+		}
+		if hosts[remote.GerritHost] == nil {
+			hosts[remote.GerritHost] = make(project.Projects)
+		}
+		local.GerritHost = remote.GerritHost
+		hosts[remote.GerritHost][key] = local
+	}
+	return hosts, nil
+}
+
+// branchChangeIDs returns the local branches of p which have commits not
+// merged upstream, keyed by the Change-Ids of these commits.
+func branchChangeIDs(jirix *jiri.X, p project.Project, state *project.ProjectState) (map[string]string, error) {
+	branches := make(map[string]string)
+	scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	for _, b := range state.Branches {
+		base, err := branchBase(jirix, p, b)
+		if err != nil {
+			return nil, err
+		}
+		messages, err := scm.Log(b.Name, base, "%B")
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			if id := changeID(message); id != "" {
+				if _, ok := branches[id]; !ok {
+					branches[id] = b.Name
+				}
+			}
+		}
+	}
+	return branches, nil
+}
+
+// gerritProjectOf returns the project among projects whose remote is the
+// Gerrit project name, if any.
+func gerritProjectOf(projects project.Projects, name string) (project.Project, bool) {
+	var keys project.ProjectKeys
+	for key := range projects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	for _, key := range keys {
+		if p := projects[key]; strings.HasSuffix(p.Remote, "/"+name) {
+			return p, true
+		}
+	}
+	return project.Project{}, false
+}
+
+// listChanges returns the open changes of the user on every Gerrit host of
+// the manifest.
+func listChanges(jirix *jiri.X) ([]clChange, error) {
+	hosts, err := clProjects(jirix)
+	if err != nil {
+		return nil, err
+	}
+	var hostNames []string
+	for host := range hosts {
+		hostNames = append(hostNames, host)
+	}
+	sort.Strings(hostNames)
+
+	// Branches are only looked up in the projects which have changes.
+	branchIDs := make(map[project.ProjectKey]map[string]string)
+	changes := []clChange{}
+	for _, host := range hostNames {
+		hostURL, err := url.Parse(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Gerrit host %q: %v", host, err)
+		}
+		g := jirix.Gerrit(hostURL)
+		results, err := g.Query("is:open owner:self")
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %v", host, err)
+		}
+		for _, change := range results {
+			c := newCLChange(g, change)
+			if p, ok := gerritProjectOf(hosts[host], change.Project); ok {
+				c.Project, c.Path = p.Name, p.Path
+				ids, ok := branchIDs[p.Key()]
+				if !ok {
+					states, err := project.GetProjectStates(jirix, project.Projects{p.Key(): p}, false)
+					if err != nil {
+						return nil, err
+					}
+					if ids, err = branchChangeIDs(jirix, p, states[p.Key()]); err != nil {
+						return nil, fmt.Errorf("cannot read the branches of %q: %v", p.Path, err)
+					}
+					branchIDs[p.Key()] = ids
+				}
+				c.Branch = ids[change.Change_id]
+			}
+			changes = append(changes, c)
+		}
+	}
+	sort.Sort(clChangesByProject(changes))
+	return changes, nil
+}
+
+// formatVote returns a Gerrit vote as it is usually displayed.
+func formatVote(vote int) string {
+	if vote > 0 {
+		return fmt.Sprintf("+%d", vote)
+	}
+	return fmt.Sprintf("%d", vote)
+}
+
+// writeCLChanges writes changes to stdout, as JSON if asJSON is true.
+func writeCLChanges(jirix *jiri.X, changes []clChange, asJSON bool) error {
+	if asJSON {
+		out, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to serialize JSON output: %s", err)
+		}
+		fmt.Fprintln(jirix.Stdout(), string(out))
+		return nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		where := c.GerritProject + " (no local project)"
+		if c.Project != "" {
+			relPath, err := filepath.Rel(cwd, c.Path)
+			if err != nil {
+				return err
+			}
+			branch := "no local branch"
+			if c.Branch != "" {
+				branch = "branch " + c.Branch
+			}
+			where = fmt.Sprintf("%s(%s) %s", c.Project, relPath, branch)
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s %s %s\n", jirix.Color.Yellow("%d", c.Number), c.Status, where)
+		fmt.Fprintf(jirix.Stdout(), "  %s\n", c.Subject)
+		details := fmt.Sprintf("Code-Review: %s, Verified: %s", formatVote(c.CodeReview), formatVote(c.Verified))
+		if c.Topic != "" {
+			details += ", Topic: " + c.Topic
+		}
+		fmt.Fprintf(jirix.Stdout(), "  %s\n  %s\n", details, c.URL)
+	}
+	return nil
+}
+
+func runCLList(jirix *jiri.X, args []string) error {
+	if len(args) > 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	changes, err := listChanges(jirix)
+	if err != nil {
+		return err
+	}
+	return writeCLChanges(jirix, changes, clListFlags.json)
+}
+
+// States of a local commit in Gerrit.
+const (
+	clCommitNotUploaded = "not-uploaded"
+	clCommitUpToDate    = "up-to-date"
+	clCommitOutdated    = "outdated"
+	clCommitMerged      = "merged"
+	clCommitAbandoned   = "abandoned"
+)
+
+// clCommit is a local commit in the output of "jiri cl status".
+type clCommit struct {
+	Revision string    `json:"revision"`
+	Subject  string    `json:"subject"`
+	ChangeID string    `json:"change_id,omitempty"`
+	State    string    `json:"state"`
+	Change   *clChange `json:"change,omitempty"`
+}
+
+// clBranch is a local branch in the output of "jiri cl status".
+type clBranch struct {
+	Project string `json:"project"`
+	Path    string `json:"path"`
+	Branch  string `json:"branch"`
+	// Commits are the commits of the branch which are not merged upstream,
+	// from the newest to the oldest.
+	Commits     []clCommit `json:"commits"`
+	NeedsUpload bool       `json:"needs_upload"`
+	Prunable    bool       `json:"prunable"`
+	// remote is the remote of the project, used to tell apart changes
+	// with the same Change-Id in several Gerrit projects.
+	remote string
+}
+
+// clCommitState returns the state of the local commit revision, whose
+// change in Gerrit is change, or nil if it was not found.
+func clCommitState(revision string, change *gerrit.Change) string {
+	switch {
+	case change == nil:
+		return clCommitNotUploaded
+	case change.Status == "MERGED":
+		return clCommitMerged
+	case change.Status == "ABANDONED":
+		return clCommitAbandoned
+	case change.Current_revision == revision:
+		return clCommitUpToDate
+	}
+	return clCommitOutdated
+}
+
+// localBranchCommits returns the commits of branch b of p which are not
+// merged upstream.
+func localBranchCommits(jirix *jiri.X, p project.Project, b project.BranchState) ([]clCommit, error) {
+	base, err := branchBase(jirix, p, b)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).Log(b.Name, base, "%H%n%B")
+	if err != nil {
+		return nil, err
+	}
+	commits := []clCommit{}
+	for _, entry := range entries {
+		if len(entry) == 0 {
+			continue
+		}
+		c := clCommit{Revision: entry[0], ChangeID: changeID(entry[1:])}
+		if len(entry) > 1 {
+			c.Subject = entry[1]
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// queryChanges returns the changes of Gerrit g with the given Change-Ids.
+func queryChanges(g *gerrit.Gerrit, ids []string) (gerrit.CLList, error) {
+	// Keep queries short enough for Gerrit to accept them.
+	const maxIDs = 50
+	var changes gerrit.CLList
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxIDs {
+			n = maxIDs
+		}
+		results, err := g.Query("change:" + strings.Join(ids[:n], " OR change:"))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, results...)
+		ids = ids[n:]
+	}
+	return changes, nil
+}
+
+// branchStatuses returns the review status of the local branches of the
+// projects with a Gerrit host.
+func branchStatuses(jirix *jiri.X) ([]clBranch, error) {
+	hosts, err := clProjects(jirix)
+	if err != nil {
+		return nil, err
+	}
+	var hostNames []string
+	for host := range hosts {
+		hostNames = append(hostNames, host)
+	}
+	sort.Strings(hostNames)
+
+	branches := []clBranch{}
+	for _, host := range hostNames {
+		projects := hosts[host]
+		states, err := project.GetProjectStates(jirix, projects, false)
+		if err != nil {
+			return nil, err
+		}
+		var hostBranches []clBranch
+		var ids []string
+		seen := make(map[string]bool)
+		for key, state := range states {
+			p := projects[key]
+			for _, b := range state.Branches {
+				commits, err := localBranchCommits(jirix, p, b)
+				if err != nil {
+					return nil, fmt.Errorf("cannot read branch %q of %q: %v", b.Name, p.Path, err)
+				}
+				if len(commits) == 0 {
+					continue
+				}
+				for _, c := range commits {
+					if c.ChangeID != "" && !seen[c.ChangeID] {
+						seen[c.ChangeID] = true
+						ids = append(ids, c.ChangeID)
+					}
+				}
+				hostBranches = append(hostBranches, clBranch{
+					Project: p.Name,
+					Path:    p.Path,
+					Branch:  b.Name,
+					Commits: commits,
+					remote:  p.Remote,
+				})
+			}
+		}
+		if len(hostBranches) == 0 {
+			continue
+		}
+
+		hostURL, err := url.Parse(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Gerrit host %q: %v", host, err)
+		}
+		g := jirix.Gerrit(hostURL)
+		sort.Strings(ids)
+		changes, err := queryChanges(g, ids)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %v", host, err)
+		}
+		for _, b := range hostBranches {
+			b.Prunable = true
+			for i := range b.Commits {
+				c := &b.Commits[i]
+				var change *gerrit.Change
+				for j := range changes {
+					if changes[j].Change_id == c.ChangeID && strings.HasSuffix(b.remote, "/"+changes[j].Project) {
+						change = &changes[j]
+						break
+					}
+				}
+				c.State = clCommitState(c.Revision, change)
+				if change != nil {
+					cl := newCLChange(g, *change)
+					c.Change = &cl
+				}
+				switch c.State {
+				case clCommitNotUploaded, clCommitOutdated:
+					b.NeedsUpload = true
+					b.Prunable = false
+				case clCommitUpToDate:
+					b.Prunable = false
+				}
+			}
+			branches = append(branches, b)
+		}
+	}
+	sort.Sort(clBranchesByProject(branches))
+	return branches, nil
+}
+
+type clBranchesByProject []clBranch
+
+func (c clBranchesByProject) Len() int      { return len(c) }
+func (c clBranchesByProject) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c clBranchesByProject) Less(i, j int) bool {
+	if c[i].Path != c[j].Path {
+		return c[i].Path < c[j].Path
+	}
+	return c[i].Branch < c[j].Branch
+}
+
+// writeCLBranches writes branches to stdout, as JSON if asJSON is true.
+func writeCLBranches(jirix *jiri.X, branches []clBranch, asJSON bool) error {
+	if asJSON {
+		out, err := json.MarshalIndent(branches, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to serialize JSON output: %s", err)
+		}
+		fmt.Fprintln(jirix.Stdout(), string(out))
+		return nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for _, b := range branches {
+		relPath, err := filepath.Rel(cwd, b.Path)
+		if err != nil {
+			return err
+		}
+		summary := "uploaded"
+		if b.NeedsUpload {
+			summary = jirix.Color.Red("needs upload")
+		} else if b.Prunable {
+			summary = jirix.Color.Yellow("can be pruned")
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s(%s) %s: %s\n", b.Project, relPath, jirix.Color.Green("%s", b.Branch), summary)
+		for _, c := range b.Commits {
+			line := fmt.Sprintf("  %s %-12s %s", c.Revision[:12], c.State, c.Subject)
+			if c.Change != nil {
+				line += fmt.Sprintf(" (%s, Code-Review: %s, Verified: %s)", c.Change.URL, formatVote(c.Change.CodeReview), formatVote(c.Change.Verified))
+			}
+			fmt.Fprintln(jirix.Stdout(), line)
+		}
+	}
+	return nil
+}
+
+func runCLStatus(jirix *jiri.X, args []string) error {
+	if len(args) > 0 {
+		return jirix.UsageErrorf("unexpected arguments")
+	}
+	branches, err := branchStatuses(jirix)
+	if err != nil {
+		return err
+	}
+	return writeCLBranches(jirix, branches, clStatusFlags.json)
+}
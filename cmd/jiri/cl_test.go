@@ -0,0 +1,181 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fuchsia.googlesource.com/jiri/git"
+	"fuchsia.googlesource.com/jiri/jiritest"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+// newFakeGerrit returns a Gerrit server answering every query with *changes,
+// a JSON list of changes, and recording the queries in queries.  It serves
+// a commit-msg hook which does nothing.
+func newFakeGerrit(changes *string, queries *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tools/hooks/commit-msg" {
+			fmt.Fprint(w, "#!/bin/sh\nexit 0\n")
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/changes/") {
+			http.NotFound(w, r)
+			return
+		}
+		*queries = append(*queries, r.URL.Query().Get("q"))
+		fmt.Fprintf(w, ")]}'\n%s", *changes)
+	}))
+}
+
+// makeGerritProjects creates projects r.a and r.b with host as their Gerrit
+// host.
+func makeGerritProjects(t *testing.T, fake *jiritest.FakeJiriRoot, host string) []*project.Project {
+	projects := []*project.Project{}
+	for _, name := range []string{"r.a", "r.b"} {
+		if err := fake.CreateRemoteProject(name); err != nil {
+			t.Fatal(err)
+		}
+		p := project.Project{
+			Name:         name,
+			Path:         filepath.Join(fake.X.Root, name),
+			Remote:       fake.Projects[name],
+			RemoteBranch: "master",
+			GerritHost:   host,
+		}
+		if err := fake.AddProject(p); err != nil {
+			t.Fatal(err)
+		}
+		projects = append(projects, &p)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	return projects
+}
+
+func TestCLList(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var queries []string
+	changes := `[
+		{
+			"change_id": "I1111111111111111111111111111111111111111",
+			"_number": 12,
+			"project": "r.a",
+			"status": "NEW",
+			"subject": "Fix a",
+			"topic": "fixes",
+			"labels": {
+				"Code-Review": {"all": [{"value": 2}]},
+				"Verified": {"all": [{"value": 1}, {"value": -1}]}
+			}
+		},
+		{
+			"change_id": "I2222222222222222222222222222222222222222",
+			"_number": 13,
+			"project": "elsewhere",
+			"status": "NEW",
+			"subject": "Fix elsewhere"
+		}
+	]`
+	server := newFakeGerrit(&changes, &queries)
+	defer server.Close()
+	projects := makeGerritProjects(t, fake, server.URL)
+
+	if err := project.StartBranch(fake.X, *projects[0], "fix"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, projects[0].Path, "file", "Fix a\n\nChange-Id: I1111111111111111111111111111111111111111\n")
+
+	list, err := listChanges(fake.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || !strings.Contains(queries[0], "owner:self") || !strings.Contains(queries[0], "is:open") {
+		t.Errorf("unexpected queries: %q", queries)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", list)
+	}
+	// Changes without a local project are listed first.
+	if c := list[0]; c.Number != 13 || c.Project != "" || c.Branch != "" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	c := list[1]
+	if c.Number != 12 || c.Project != "r.a" || c.Branch != "fix" || c.Topic != "fixes" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	if c.CodeReview != 2 || c.Verified != -1 {
+		t.Errorf("unexpected votes: %+v", c)
+	}
+	if want := server.URL + "/c/12"; c.URL != want {
+		t.Errorf("got URL %q, want %q", c.URL, want)
+	}
+}
+
+func TestCLStatus(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var queries []string
+	changes := "[]"
+	server := newFakeGerrit(&changes, &queries)
+	defer server.Close()
+	projects := makeGerritProjects(t, fake, server.URL)
+
+	p := *projects[0]
+	commit := func(branch, id string) string {
+		if err := project.StartBranch(fake.X, p, branch); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, fake.X, p.Path, branch+"-"+id, branch+"\n\nChange-Id: "+id+"\n")
+		rev, err := git.NewGit(p.Path).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rev
+	}
+	uploaded := commit("uploaded", "I1111111111111111111111111111111111111111")
+	commit("merged", "I2222222222222222222222222222222222222222")
+	commit("new", "I3333333333333333333333333333333333333333")
+	if err := project.StartBranch(fake.X, *projects[1], "empty"); err != nil {
+		t.Fatal(err)
+	}
+	changes = fmt.Sprintf(`[
+		{"change_id": "I1111111111111111111111111111111111111111", "_number": 1, "project": "r.a", "status": "NEW", "current_revision": %q},
+		{"change_id": "I2222222222222222222222222222222222222222", "_number": 2, "project": "r.a", "status": "MERGED"},
+		{"change_id": "I3333333333333333333333333333333333333333", "_number": 3, "project": "r.b", "status": "NEW"}
+	]`, uploaded)
+
+	branches, err := branchStatuses(fake.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 {
+		t.Errorf("expected a single query, got %q", queries)
+	}
+	got := map[string]clBranch{}
+	for _, b := range branches {
+		got[b.Project+":"+b.Branch] = b
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 branches, got %+v", branches)
+	}
+	if b := got["r.a:uploaded"]; b.NeedsUpload || b.Prunable || len(b.Commits) != 1 || b.Commits[0].State != clCommitUpToDate || b.Commits[0].Change.Number != 1 {
+		t.Errorf("unexpected status of branch uploaded: %+v", b)
+	}
+	if b := got["r.a:merged"]; b.NeedsUpload || !b.Prunable || len(b.Commits) != 1 || b.Commits[0].State != clCommitMerged {
+		t.Errorf("unexpected status of branch merged: %+v", b)
+	}
+	// The change with the Change-Id of the commit is in another project.
+	if b := got["r.a:new"]; !b.NeedsUpload || b.Prunable || len(b.Commits) != 1 || b.Commits[0].State != clCommitNotUploaded {
+		t.Errorf("unexpected status of branch new: %+v", b)
+	}
+}
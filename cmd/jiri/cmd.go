@@ -13,6 +13,8 @@ import (
 	"syscall"
 
 	"fuchsia.googlesource.com/jiri/cmdline"
+	"fuchsia.googlesource.com/jiri/credentials"
+	"fuchsia.googlesource.com/jiri/project"
 )
 
 func init() {
@@ -54,10 +56,16 @@ Command jiri is a multi-purpose tool for multi-repo development.
 `,
 		LookPath: true,
 		Children: []*cmdline.Command{
+			cmdAm,
 			cmdBranch,
+			cmdCL,
+			cmdDiffTree,
+			cmdFetch,
+			cmdFormatPatch,
 			cmdGrep,
 			cmdImport,
 			cmdInit,
+			cmdLog,
 			cmdPatch,
 			cmdProject,
 			cmdProjectConfig,
@@ -69,8 +77,10 @@ Command jiri is a multi-purpose tool for multi-repo development.
 			cmdVersion,
 		},
 		Topics: []cmdline.Topic{
+			topicCredentials,
 			topicFileSystem,
 			topicManifest,
+			topicSelector,
 		},
 	}
 }
@@ -87,6 +97,7 @@ looks like this:
  [root]/.jiri_root                   # root metadata directory
  [root]/.jiri_root/bin               # contains jiri tool binary
  [root]/.jiri_root/update_history    # contains history of update snapshots
+ [root]/.jiri_root/update_journal    # journal of an update in progress
  [root]/.manifest                    # contains jiri manifests
  [root]/[project1]                   # project directory (name picked by user)
  [root]/[project1]/.jiri             # project metadata directory
@@ -121,6 +132,18 @@ The jiri binary is located at [root]/.jiri_root/bin/jiri
 `,
 }
 
+var topicCredentials = cmdline.Topic{
+	Name:  "credentials",
+	Short: "Description of how jiri finds credentials",
+	Long:  credentials.Help,
+}
+
+var topicSelector = cmdline.Topic{
+	Name:  "selector",
+	Short: "Description of project selectors",
+	Long:  project.SelectorHelp,
+}
+
 var topicManifest = cmdline.Topic{
 	Name:  "manifest",
 	Short: "Description of manifest files",
@@ -155,6 +178,7 @@ Manifests have the following XML schema:
              remotebranch="my-branch"
              gerrithost="https://myorg-review.googlesource.com"
              githooks="path/to/githooks-dir"
+             groups="core,tools"
     />
     ...
   </projects>
@@ -214,10 +238,22 @@ attribute is ignored.
 * gerrithost (optional) - The url of the Gerrit host for the project.  If
 specified, then running "jiri cl upload" will upload a CL to this Gerrit host.
 
+* reviewtype (optional) - The code review system changes to the project are
+sent to by "jiri upload": "gerrit", the default, or "pullrequest" for a forge
+taking pull requests through a GitHub-style REST API.
+
+* reviewhost (optional) - The url of the code review system.  For pull requests
+it is the base url of the REST API of the forge, and the repository is the path
+of the project remote.  Gerrit projects use "gerrithost" when it is not set.
+
 * githooks (optional) - The path (relative to [root]) of a directory containing
 git hooks that will be installed in the projects .git/hooks directory during
 each update.
 
+* groups (optional) - A comma-separated list of groups the project belongs to.
+Commands which take a project selector can select the projects of a group with
+"group:<name>".
+
 The <hook> tag describes the hooks that must be executed after every 'jiri update'
 They are configured via the following attributes:
 
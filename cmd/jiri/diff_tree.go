@@ -0,0 +1,105 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/cmdline"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+var cmdDiffTree = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runDiffTree),
+	Name:   "diff-tree",
+	Short:  "Show a combined diff across projects",
+	Long: `
+Shows the changes of all projects as a single diff.  Paths in the diff are
+relative to the jiri root, so that it can be applied from there with
+"patch -p1".
+
+Without arguments, shows the uncommitted changes of each project's working
+tree.  With a branch, shows the changes made on that branch since it forked
+from its upstream, in the projects which have it.
+`,
+	ArgsName: "[<branch>]",
+	ArgsLong: "<branch> is the local branch to show the changes of.",
+}
+
+// diffTree returns the combined diff of all projects.  If branch is empty,
+// the diff is between the working trees and HEAD, otherwise it is between
+// the base of branch and branch.
+func diffTree(jirix *jiri.X, branch string) (string, error) {
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return "", err
+	}
+	var states map[project.ProjectKey]*project.ProjectState
+	if branch != "" {
+		if states, err = project.GetProjectStates(jirix, projects, false); err != nil {
+			return "", err
+		}
+	}
+	var keys project.ProjectKeys
+	for key := range projects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+
+	var out bytes.Buffer
+	for _, key := range keys {
+		p := projects[key]
+		relPath, err := filepath.Rel(jirix.Root, p.Path)
+		if err != nil {
+			return "", err
+		}
+		revs := []string{"HEAD"}
+		if branch != "" {
+			found := false
+			for _, b := range states[key].Branches {
+				if b.Name != branch {
+					continue
+				}
+				base, err := branchBase(jirix, p, b)
+				if err != nil {
+					return "", err
+				}
+				revs = []string{base + "..." + branch}
+				found = true
+				break
+			}
+			if !found {
+				continue
+			}
+		}
+		diff, err := gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).Diff(filepath.ToSlash(relPath)+"/", revs...)
+		if err != nil {
+			return "", fmt.Errorf("git diff failed in %q: %v", p.Path, err)
+		}
+		out.WriteString(diff)
+	}
+	return out.String(), nil
+}
+
+func runDiffTree(jirix *jiri.X, args []string) error {
+	if len(args) > 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	branch := ""
+	if len(args) == 1 {
+		branch = args[0]
+	}
+	diff, err := diffTree(jirix, branch)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(jirix.Stdout(), diff)
+	return nil
+}
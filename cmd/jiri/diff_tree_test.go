@@ -0,0 +1,62 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fuchsia.googlesource.com/jiri/jiritest"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+func TestDiffTree(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	projects := makeProjects(t, fake)
+
+	// Uncommitted changes in r.a and sub/r.t1.
+	for _, p := range []*project.Project{projects[0], projects[3]} {
+		writeFile(t, fake.X, p.Path, "file", "old\n")
+		if err := ioutil.WriteFile(filepath.Join(p.Path, "file"), []byte("new\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A commit on a branch of r.b.
+	if err := project.StartBranch(fake.X, *projects[1], "feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, projects[1].Path, "feature-file", "feature\n")
+
+	diff, err := diffTree(fake.X, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"--- a/r.a/file\n+++ b/r.a/file\n",
+		"--- a/sub/r.t1/file\n+++ b/sub/r.t1/file\n",
+		"-old\n+new\n",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("diff does not contain %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "feature-file") {
+		t.Errorf("diff contains committed changes:\n%s", diff)
+	}
+
+	diff, err = diffTree(fake.X, "feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "+++ b/r.b/feature-file\n"; !strings.Contains(diff, want) {
+		t.Errorf("diff does not contain %q:\n%s", want, diff)
+	}
+	if strings.Contains(diff, "r.a/file") {
+		t.Errorf("diff contains changes of projects without the branch:\n%s", diff)
+	}
+}
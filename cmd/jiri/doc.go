@@ -12,18 +12,25 @@ Usage:
    jiri [flags] <command>
 
 The jiri commands are:
-   cl          Manage changelists for multiple projects
-   import      Adds imports to .jiri_manifest file
-   project     Manage the jiri projects
-   snapshot    Manage project snapshots
-   update      Update all jiri projects
-   which       Show path to the jiri tool
-   runp        Run a command in parallel across jiri projects
-   help        Display help for commands or topics
+   am             Apply a patch bundle across projects
+   cl             Manage changelists across projects
+   diff-tree      Show a combined diff across projects
+   fetch          Fetch all jiri projects without updating them
+   format-patch   Export the commits of a branch across projects
+   import         Adds imports to .jiri_manifest file
+   log            Show the merged commit history of projects
+   project        Manage the jiri projects
+   snapshot       Manage project snapshots
+   update         Update all jiri projects
+   which          Show path to the jiri tool
+   runp           Run a command in parallel across jiri projects
+   help           Display help for commands or topics
 
 The jiri additional help topics are:
+   credentials Description of how jiri finds credentials
    filesystem  Description of jiri file system layout
    manifest    Description of manifest files
+   selector    Description of project selectors
 
 The jiri flags are:
  -color=true
@@ -37,142 +44,87 @@ The global flags are:
  -time=false
    Dump timing information to stderr before exiting the program.
 
-Jiri cl - Manage changelists for multiple projects
+Jiri am - Apply a patch bundle across projects
 
-Manage changelists for multiple projects.
+Applies a patch bundle created by "jiri format-patch".  For each project in the
+bundle, a new branch is created at the manifest revision of the project and the
+patches are applied to it with "git am --3way".  If the patches of a project do
+not apply, the "git am" is aborted, the branch is left at the manifest revision
+and the remaining projects are still processed.
 
 Usage:
-   jiri cl [flags] <command>
-
-The jiri cl commands are:
-   cleanup     Clean up changelists that have been merged
-   upload      Upload a changelist for review
-   new         Create a new local branch for a changelist
-   sync        Bring a changelist up to date
-
-The jiri cl flags are:
- -color=true
-   Use color to format output.
- -v=false
-   Print verbose output.
-
-Jiri cl cleanup - Clean up changelists that have been merged
-
-Command "cleanup" checks that the given branches have been merged into the
-corresponding remote branch. If a branch differs from the corresponding remote
-branch, the command reports the difference and stops. Otherwise, it deletes the
-given branches.
+   jiri am [flags] <dir>
 
-Usage:
-   jiri cl cleanup [flags] <branches>
-
-<branches> is a list of branches to cleanup.
+<dir> is the directory of the patch bundle.
 
-The jiri cl cleanup flags are:
- -f=false
-   Ignore unmerged changes.
- -remote-branch=master
-   Name of the remote branch the CL pertains to, without the leading "origin/".
+The jiri am flags are:
+ -branch=
+   Name of the branch to create in each project. Defaults to the branch the
+   bundle was created from.
 
  -color=true
    Use color to format output.
  -v=false
    Print verbose output.
 
-Jiri cl upload - Upload a changelist for review
+Jiri cl - Manage changelists across projects
 
-Command "upload" squashes all commits of a local branch into a single "changelist"
-and uploads this changelist to Gerrit as a single commit. First time the command
-is invoked, it generates a Change-Id for the changelist, which is appended to
-the commit message. Consecutive invocations of the command use the same
-Change-Id by default, informing Gerrit that the incomming commit is an update of
-an existing changelist.
+Manage the changelists of the local projects on the Gerrit hosts referenced by
+the manifest.
 
 Usage:
-   jiri cl upload [flags]
-
-The jiri cl upload flags are:
- -autosubmit=false
-   Automatically submit the changelist when feasible.
- -cc=
-   Comma-seperated list of emails or LDAPs to cc.
- -check-uncommitted=true
-   Check that no uncommitted changes exist.
- -clean-multipart-metadata=false
-   Cleanup the metadata associated with multipart CLs pertaining the MultiPart:
-   x/y message without uploading any CLs.
- -commit-message-body-file=
-   file containing the body of the CL description, that is, text without a
-   ChangeID, MultiPart etc.
- -current-project-only=false
-   Run upload in the current project only.
- -d=false
-   Send a draft changelist.
- -edit=true
-   Open an editor to edit the CL description.
- -host=
-   Gerrit host to use.  Defaults to gerrit host specified in manifest.
- -m=
-   CL description.
- -presubmit=all
-   The type of presubmit tests to run. Valid values: none,all.
- -r=
-   Comma-seperated list of emails or LDAPs to request review.
- -remote-branch=master
-   Name of the remote branch the CL pertains to, without the leading "origin/".
- -set-topic=true
-   Set Gerrit CL topic.
- -topic=
-   CL topic, defaults to <username>-<branchname>.
- -verify=true
-   Run pre-push git hooks.
+   jiri cl [flags] <command>
+
+The jiri cl commands are:
+   list        List your open changes across projects
+   status      Show the review status of local branches
 
+The jiri cl flags are:
  -color=true
    Use color to format output.
  -v=false
    Print verbose output.
 
-Jiri cl new - Create a new local branch for a changelist
+Jiri cl list - List your open changes across projects
 
-Command "new" creates a new local branch for a changelist. In particular, it
-forks a new branch with the given name from the current branch and records the
-relationship between the current branch and the new branch in the .jiri metadata
-directory. The information recorded in the .jiri metadata directory tracks
-dependencies between CLs and is used by the "jiri cl sync" and "jiri cl upload"
-commands.
+Lists the open changes owned by you on every Gerrit host referenced by the
+manifest.  Each change is shown with its status, its Code-Review and Verified
+votes, its topic and its URL, along with the local project and branch holding a
+commit with its Change-Id, if any.
 
 Usage:
-   jiri cl new [flags] <name>
+   jiri cl list [flags]
 
-<name> is the changelist name.
+The jiri cl list flags are:
+ -json=false
+   Print the changes as a JSON list.
 
-The jiri cl new flags are:
  -color=true
    Use color to format output.
  -v=false
    Print verbose output.
 
-Jiri cl sync - Bring a changelist up to date
+Jiri cl status - Show the review status of local branches
 
-Command "sync" brings the CL identified by the current branch up to date with
-the branch tracking the remote branch this CL pertains to. To do that, the
-command uses the information recorded in the .jiri metadata directory to
-identify the sequence of dependent CLs leading to the current branch. The
-command then iterates over this sequence bringing each of the CLs up to date
-with its ancestor. The end result of this process is that all CLs in the
-sequence are up to date with the branch that tracks the remote branch this CL
-pertains to.
+Shows, for each local branch of the projects with a Gerrit host, the commits
+which are not merged upstream and the state of their change in Gerrit, found
+with their Change-Id:
+  not-uploaded  there is no change with the Change-Id of the commit
+  up-to-date    the current patchset of the change is the commit
+  outdated      the current patchset of the change is another commit
+  merged        the change is merged
+  abandoned     the change is abandoned
 
-NOTE: It is possible that the command cannot automatically merge changes in an
-ancestor into its dependent. When that occurs, the command is aborted and prints
-instructions that need to be followed before the command can be retried.
+A branch needs to be uploaded if one of its commits is not uploaded or outdated,
+and can be pruned if all its changes are merged or abandoned. Branches whose
+commits are all merged upstream are not shown.
 
 Usage:
-   jiri cl sync [flags]
+   jiri cl status [flags]
 
-The jiri cl sync flags are:
- -remote-branch=master
-   Name of the remote branch the CL pertains to, without the leading "origin/".
+The jiri cl status flags are:
+ -json=false
+   Print the branches as a JSON list.
 
  -color=true
    Use color to format output.
@@ -224,6 +176,109 @@ The jiri import flags are:
  -v=false
    Print verbose output.
 
+Jiri diff-tree - Show a combined diff across projects
+
+Shows the changes of all projects as a single diff.  Paths in the diff are
+relative to the jiri root, so that it can be applied from there with "patch
+-p1".
+
+Without arguments, shows the uncommitted changes of each project's working tree.
+With a branch, shows the changes made on that branch since it forked from its
+upstream, in the projects which have it.
+
+Usage:
+   jiri diff-tree [flags] [<branch>]
+
+<branch> is the local branch to show the changes of.
+
+ -color=true
+   Use color to format output.
+ -v=false
+   Print verbose output.
+
+Jiri fetch - Fetch all jiri projects without updating them
+
+Fetches the cache and all projects from their remotes, without checking out,
+rebasing or running hooks.  Working trees and branches are left untouched, so
+that a later "jiri update" has little left to download.
+
+With -daemon, fetches every -interval until killed.  Failed fetches are
+reported but do not stop the daemon.
+
+Usage:
+   jiri fetch [flags]
+
+The jiri fetch flags are:
+ -daemon=false
+   Keep running and fetch every -interval.
+ -interval=1h0m0s
+   Time between two fetches in -daemon mode.
+ -local-manifest=false
+   Use local manifest
+ -manifest=
+   Name of the project manifest.
+
+ -color=true
+   Use color to format output.
+ -v=false
+   Print verbose output.
+
+Jiri format-patch - Export the commits of a branch across projects
+
+Exports the commits made on a branch in every project which has it, as a patch
+bundle which can be applied to another jiri root with "jiri am".  The bundle is
+a directory holding the output of "git format-patch" for each project, and a
+patches.json file describing which patches apply to which project.
+
+The commits exported are the ones made on the branch since it forked from its
+upstream, or from the manifest revision if it does not track any branch.
+
+Usage:
+   jiri format-patch [flags] <branch>
+
+<branch> is the local branch to export.
+
+The jiri format-patch flags are:
+ -o=jiri-patches
+   Directory to write the patch bundle to.
+
+ -color=true
+   Use color to format output.
+ -v=false
+   Print verbose output.
+
+Jiri log - Show the merged commit history of projects
+
+Shows the commits of the current branch of each selected project, merged into
+a single stream sorted from newest to oldest.  Each commit is prefixed with the
+name of its project.
+
+With -snapshot, only the commits made on top of the revisions recorded in the
+snapshot are shown.  Projects which are not in the snapshot have their whole
+history shown.
+
+Usage:
+   jiri log [flags] <project>...
+
+<project> is a regular expression matching the keys of the projects to show.
+If no projects are given, all projects are shown.
+
+The jiri log flags are:
+ -author=
+   Only show commits whose author matches this pattern. Same as 'git log
+   --author'.
+ -json=false
+   Print the commits as a JSON list.
+ -since=
+   Only show commits more recent than this date. Same as 'git log --since'.
+ -snapshot=
+   Only show commits made since the revisions recorded in this snapshot file.
+
+ -color=true
+   Use color to format output.
+ -v=false
+   Print verbose output.
+
 Jiri patch - Patch in the existing change
 
 Command "patch" applies the existing changelist to the current project. The
@@ -236,20 +291,66 @@ branch is "change/<changeset>/<patchset>", but this can be overriden using the
 flag will delete the branch if already exists. Use the -force flag to force
 deleting the branch even if it contains unmerged changes).
 
+if -topic flag is true jiri will fetch whole topic and will try to apply to
+indivisual projects. Patch will assume topic is of form {USER}-{BRANCH} and will
+try to create branch name out of it. If this fails default branch name would be
+same as topic. Currently patch does not support the scenario when change "B" is
+created on top of "A" and both have same topic.
+
+If -chain flag is true jiri will also patch the ancestors of the change in its
+Gerrit relation chain which are not merged yet. When an ancestor has a newer
+patchset than the one the change is based on, the latest patchset of every
+ancestor is used and the change is cherry-picked on top of them.
+
+If -query flag is set jiri will patch every change matching the given Gerrit
+query, e.g. "status:open owner:self", into the local project of the change. No
+argument is expected in this case. A summary of the applied changes is printed
+after patching a topic or a query.
+
+If -cherry-pick flag is true the change is cherry-picked onto the current branch
+of the project, or the current revision if no branch is checked out, instead of
+being checked out on a new branch. With -chain, the ancestors of the change are
+cherry-picked as well.
+
+jiri remembers the branches it creates. If -cleanup flag is true jiri deletes
+the branches it created whose commits are all merged, and the branches not
+changed since they were patched whose changes are merged or abandoned; with
+-force the branches are deleted in any case. When such a branch is checked out,
+the revision of the project in the manifest is checked out first. No argument
+is expected in this case.
+
+For projects whose "reviewtype" is "pullrequest", the change is a pull request
+number or reference "refs/pull/<number>/head", and it is patched on branch
+"pull/<number>" by default.  -chain, -topic and -query only apply to Gerrit.
+
 Usage:
-   jiri patch <change>
+   jiri patch [flags] <change or topic>
 
-<change> is a change ID or a full reference.
+<change or topic> is a change ID, full reference or topic when -topic is true.
+It must be omitted when -query or -cleanup is set.
 
-The jiri project info flags are:
+The jiri patch flags are:
  -branch=
    Name of the branch the patch will be applied to
+ -chain=false
+   Patch the change together with its ancestors in the relation chain.
+ -cherry-pick=false
+   Cherry-pick the change onto the current branch instead of creating a new
+   branch.
+ -cleanup=false
+   Delete the branches created by patch which are merged or abandoned.
  -delete=false
    Delete the existing branch if already exists
  -force=false
    Use force when deleting the existing branch
  -host=
-   Gerrit host to use.  Defaults to gerrit host specified in manifest.
+   Gerrit host to use. Defaults to gerrit host specified in manifest.
+ -query=
+   Patch all changes matching the given Gerrit query.
+ -rebase=false
+   Rebase the change after downloading
+ -topic=false
+   Patch whole topic.
 
  -color=true
    Use color to format output.
@@ -390,16 +491,24 @@ Updates all projects. The sequence in which the individual updates happen
 guarantees that we end up with a consistent workspace. The set of projects
 to update is described in the manifest.
 
+If projects are given, only those projects and their hooks are updated, and
+all other projects are left untouched.
+
 Run "jiri help manifest" for details on manifests.
 
 Usage:
-   jiri update [flags] <snapshot>
+   jiri update [flags] <file or url> | <project>...
 
-<snapshot> is the snapshot manifest file.
+<file or url> points to snapshot to checkout.
+<project> is a project name or a regular expression matching project names.
 
 The jiri update flags are:
  -attempts=1
-   Number of attempts before failing.
+   Number of attempts of the whole update before failing. Fetching a single
+   project is retried on transient errors regardless.
+ -autostash=false
+   Stash uncommitted changes before updating a project and restore them
+   afterwards.
  -gc=false
    Garbage collect obsolete repositories.
  -manifest=
@@ -413,8 +522,29 @@ The jiri update flags are:
 Jiri runp - Run a command in parallel across jiri projects
 
 Run a command in parallel across one or more jiri projects. Commands are run
-using the shell specified by the users $SHELL environment variable, or "sh"
-if that's not set. Thus commands are run as $SHELL -c "args..."
+using the shell specified by the users $SHELL environment variable, or "sh" if
+that's not set. Thus commands are run as $SHELL -c "args..."
+
+Each command is run with the following environment variables describing the
+project it is run in:
+  JIRI_ROOT                   the jiri root directory
+  JIRI_PROJECT_NAME           the name of the project
+  JIRI_PROJECT_KEY            the key of the project
+  JIRI_PROJECT_PATH           the absolute path of the project
+  JIRI_PROJECT_REMOTE         the remote of the project
+  JIRI_PROJECT_REMOTE_BRANCH  the remote branch of the project
+  JIRI_PROJECT_REVISION       the revision of the project in the manifest
+
+The command line may also contain Go template placeholders, which are expanded
+for each project before the command is run, with the same fields as "jiri
+project -template": {{.Name}}, {{.Path}}, {{.Remote}}, {{.Revision}},
+{{.CurrentBranch}} and {{.Branches}}.  Expanded values are not quoted for the
+shell.
+
+Once all commands are done, the projects where they failed, timed out or
+were cancelled are listed.  With -log-dir, the output of each command is also kept in
+<log-dir>/<project path>.stdout and .stderr, and with -json-output, the exit
+code, duration and log files of each command are written to a file.
 
 Usage:
    jiri runp [flags] <command line>
@@ -425,36 +555,38 @@ is run must be quoted to avoid expansion before being passed to runp by the
 shell.
 
 The jiri runp flags are:
+ -branch=
+   A regular expression specifying branch names to use in matching projects. A
+   project will match if the specified branch exists, even if it is not checked
+   out.
  -collate-stdout=true
    Collate all stdout output from each parallel invocation and display it as if
    had been generated sequentially. This flag cannot be used with
    -show-name-prefix, -show-key-prefix or -interactive.
- -env=
-   specify an environment variable in the form: <var>=[<val>],...
  -exit-on-error=false
    If set, all commands will killed as soon as one reports an error, otherwise,
    each will run to completion.
- -has-branch=
-   A regular expression specifying branch names to use in matching projects. A
-   project will match if the specified branch exists, even if it is not checked
-   out.
- -has-gerrit-message=false
-   If specified, match branches that have, or have no, gerrit message
- -has-uncommitted=false
-   If specified, match projects that have, or have no, uncommitted changes
- -has-untracked=false
-   If specified, match projects that have, or have no, untracked files
- -interactive=true
+ -interactive=false
    If set, the command to be run is interactive and should not have its
    stdout/stderr manipulated. This flag cannot be used with -show-name-prefix,
    -show-key-prefix or -collate-stdout.
- -merge-policies=+CCFLAGS,+CGO_CFLAGS,+CGO_CXXFLAGS,+CGO_LDFLAGS,+CXXFLAGS,GOARCH,GOOS,GOPATH:,^GOROOT*,+LDFLAGS,:PATH,VDLPATH:
-   specify policies for merging environment variables
+ -json-output=
+   Path to write the result of the command in each project to, as JSON.
+ -log-dir=
+   Directory to keep the stdout and stderr of the command in each project in.
+   This flag cannot be used with -interactive.
+ -no-uncommitted=false
+   Match projects that have no uncommitted changes
+ -no-untracked=false
+   Match projects that have no untracked files
  -projects=
    A Regular expression specifying project keys to run commands in. By default,
    runp will use projects that have the same branch checked as the current
    project unless it is run from outside of a project in which case it will
    default to using all projects.
+ -select=
+   Only use the projects matching this project selector. See 'jiri help
+   selector' for its syntax.
  -show-key-prefix=false
    If set, each line of output from each project will begin with the key of the
    project followed by a colon. This is intended for use with long running
@@ -467,6 +599,13 @@ The jiri runp flags are:
    commands where the output needs to be streamed. Stdout and stderr are spliced
    apart. This flag cannot be used with -interactive, -show-key-prefix or
    -collate-stdout.
+ -timeout=0s
+   If set, commands still running after this duration are killed and reported as
+   failed.
+ -uncommitted=false
+   Match projects that have uncommitted changes
+ -untracked=false
+   Match projects that have untracked files
  -v=false
    Print verbose logging information
 
@@ -499,6 +638,30 @@ The jiri help flags are:
    Defaults to the terminal width if available.  Override the default by setting
    the CMDLINE_WIDTH environment variable.
 
+Jiri credentials - Description of how jiri finds credentials
+
+jiri authenticates its HTTP requests to Gerrit, gitiles and other hosts with the
+first credentials found for the host in:
+  $JIRI_AUTH_TOKEN       a bearer token
+  $JIRI_AUTH_TOKEN_FILE  a file containing a bearer token
+  ~/.netrc               lines of the form
+                         "machine <host> login <username> password <password>"
+  git cookie file        the file set by git's http.cookiefile, or
+                         ~/.gitcookies; cookies for the host are sent, and
+                         googlesource cookies are also used as username and
+                         password
+  git credential helper  credentials returned by "git credential fill", so
+                         that any helper configured with credential.helper
+                         works; git is not allowed to prompt for them
+
+The bearer token is sent to the hosts listed in $JIRI_AUTH_TOKEN_HOSTS,
+separated by commas, or to the Gerrit, review and gitiles hosts of the manifest
+if it is not set.  The git credential helper is only asked for the credentials
+of Gerrit, review and gitiles hosts, not for those of snapshot URLs or of the
+hosts jiri updates itself from.
+
+Credentials are only sent over https, or to the local host.
+
 Jiri filesystem - Description of jiri file system layout
 
 All data managed by the jiri tool is located in the file system under a root
@@ -509,6 +672,7 @@ looks like this:
  [root]/.jiri_root                   # root metadata directory
  [root]/.jiri_root/bin               # contains tool binaries (jiri, etc.)
  [root]/.jiri_root/update_history    # contains history of update snapshots
+ [root]/.jiri_root/update_journal    # journal of an update in progress
  [root]/.manifest                    # contains jiri manifests
  [root]/[project1]                   # project directory (name picked by user)
  [root]/[project1]/.jiri             # project metadata directory
@@ -573,6 +737,7 @@ Manifests have the following XML schema:
              remotebranch="my-branch"
              gerrithost="https://myorg-review.googlesource.com"
              githooks="path/to/githooks-dir"
+             groups="core,tools"
     />
     ...
   </projects>
@@ -632,10 +797,22 @@ attribute is ignored.
 * gerrithost (optional) - The url of the Gerrit host for the project.  If
 specified, then running "jiri cl upload" will upload a CL to this Gerrit host.
 
+* reviewtype (optional) - The code review system changes to the project are sent
+to by "jiri upload": "gerrit", the default, or "pullrequest" for a forge taking
+pull requests through a GitHub-style REST API.
+
+* reviewhost (optional) - The url of the code review system.  For pull requests
+it is the base url of the REST API of the forge, and the repository is the path
+of the project remote.  Gerrit projects use "gerrithost" when it is not set.
+
 * githooks (optional) - The path (relative to [root]) of a directory containing
 git hooks that will be installed in the projects .git/hooks directory during
 each update.
 
+* groups (optional) - A comma-separated list of groups the project belongs to.
+Commands which take a project selector can select the projects of a group with
+"group:<name>".
+
 The <hook> tag describes the hooks that must be executed after every 'jiri update'
 They are configured via the following attributes:
 
@@ -645,5 +822,26 @@ They are configured via the following attributes:
 
 * action (required) - Action to be performed inside the project.
 It is mostly identified by a script
+
+Jiri selector - Description of project selectors
+
+A project selector is an expression made of the following terms:
+  <glob>           projects whose name or path relative to the jiri root
+                   matches the glob, e.g. "third_party/*"
+  name:<glob>      projects whose name matches the glob
+  path:<glob>      projects whose path relative to the jiri root matches the
+                   glob
+  re:<regexp>      projects whose key matches the regular expression
+  under:<dir>      projects in <dir> or below it, <dir> being relative to the
+                   current directory
+  group:<glob>     projects with a group matching the glob
+  branch:<glob>    projects with a local branch matching the glob
+  on:<glob>        projects whose current branch matches the glob
+  dirty            projects with uncommitted changes
+  untracked        projects with untracked files
+Globs use the syntax of Go's path.Match.  Terms can be combined with "and" (or
+"&&"), "or" (or "||"), "not" (or "!") and parentheses.  Terms which follow each
+other without an operator are combined with "and", e.g.
+  jiri status -select 'under:third_party dirty or branch:fix-*'
 */
 package main
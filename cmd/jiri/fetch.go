@@ -0,0 +1,62 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/cmdline"
+	"fuchsia.googlesource.com/jiri/project"
+	"fuchsia.googlesource.com/jiri/tool"
+)
+
+var fetchFlags struct {
+	localManifest bool
+	daemon        bool
+	interval      time.Duration
+}
+
+func init() {
+	tool.InitializeProjectFlags(&cmdFetch.Flags)
+
+	cmdFetch.Flags.BoolVar(&fetchFlags.localManifest, "local-manifest", false, "Use local manifest")
+	cmdFetch.Flags.BoolVar(&fetchFlags.daemon, "daemon", false, "Keep running and fetch every -interval.")
+	cmdFetch.Flags.DurationVar(&fetchFlags.interval, "interval", time.Hour, "Time between two fetches in -daemon mode.")
+}
+
+// cmdFetch represents the "jiri fetch" command.
+var cmdFetch = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runFetch),
+	Name:   "fetch",
+	Short:  "Fetch all jiri projects without updating them",
+	Long: `
+Fetches the cache and all projects from their remotes, without checking out,
+rebasing or running hooks.  Working trees and branches are left untouched, so
+that a later "jiri update" has little left to download.
+
+With -daemon, fetches every -interval until killed.  Failed fetches are
+reported but do not stop the daemon.
+`,
+}
+
+func runFetch(jirix *jiri.X, args []string) error {
+	if len(args) > 0 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	if !fetchFlags.daemon {
+		return project.FetchUniverse(jirix, fetchFlags.localManifest)
+	}
+	if fetchFlags.interval <= 0 {
+		return jirix.UsageErrorf("-interval must be positive")
+	}
+	for {
+		if err := project.FetchUniverse(jirix, fetchFlags.localManifest); err != nil {
+			jirix.Logger.Errorf("%v\n\n", err)
+		}
+		jirix.Logger.Infof("Next fetch in %v", fetchFlags.interval)
+		time.Sleep(fetchFlags.interval)
+	}
+}
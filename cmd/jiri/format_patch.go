@@ -0,0 +1,267 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/cmdline"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+// patchBundleFile is the name of the file describing a patch bundle.
+const patchBundleFile = "patches.json"
+
+var formatPatchFlags struct {
+	outDir string
+}
+
+var amFlags struct {
+	branch string
+}
+
+func init() {
+	cmdFormatPatch.Flags.StringVar(&formatPatchFlags.outDir, "o", "jiri-patches", "Directory to write the patch bundle to.")
+	cmdAm.Flags.StringVar(&amFlags.branch, "branch", "", "Name of the branch to create in each project. Defaults to the branch the bundle was created from.")
+}
+
+var cmdFormatPatch = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runFormatPatch),
+	Name:   "format-patch",
+	Short:  "Export the commits of a branch across projects",
+	Long: `
+Exports the commits made on a branch in every project which has it, as a patch
+bundle which can be applied to another jiri root with "jiri am".  The bundle is
+a directory holding the output of "git format-patch" for each project, and a
+patches.json file describing which patches apply to which project.
+
+The commits exported are the ones made on the branch since it forked from its
+upstream, or from the manifest revision if it does not track any branch.
+`,
+	ArgsName: "<branch>",
+	ArgsLong: "<branch> is the local branch to export.",
+}
+
+var cmdAm = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runAm),
+	Name:   "am",
+	Short:  "Apply a patch bundle across projects",
+	Long: `
+Applies a patch bundle created by "jiri format-patch".  For each project in the
+bundle, a new branch is created at the manifest revision of the project and the
+patches are applied to it with "git am --3way".  If the patches of a project do
+not apply, the "git am" is aborted, the branch is left at the manifest revision
+and the remaining projects are still processed.
+`,
+	ArgsName: "<dir>",
+	ArgsLong: "<dir> is the directory of the patch bundle.",
+}
+
+// patchBundle describes a patch bundle.
+type patchBundle struct {
+	Branch   string               `json:"branch"`
+	Projects []patchBundleProject `json:"projects"`
+}
+
+// patchBundleProject describes the patches of a project in a patch bundle.
+type patchBundleProject struct {
+	Name   string `json:"name"`
+	Remote string `json:"remote"`
+	// Path is the path of the project relative to the jiri root.
+	Path string `json:"path"`
+	// Patches are the paths of the patch files relative to the bundle
+	// directory, in the order they must be applied.
+	Patches []string `json:"patches"`
+}
+
+// formatPatch writes the patch bundle of branch to outDir.
+func formatPatch(jirix *jiri.X, branch, outDir string) (*patchBundle, error) {
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	states, err := project.GetProjectStates(jirix, projects, false)
+	if err != nil {
+		return nil, err
+	}
+	var keys project.ProjectKeys
+	for key := range projects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+
+	bundle := &patchBundle{Branch: branch, Projects: []patchBundleProject{}}
+	for _, key := range keys {
+		p := projects[key]
+		for _, b := range states[key].Branches {
+			if b.Name != branch {
+				continue
+			}
+			base, err := branchBase(jirix, p, b)
+			if err != nil {
+				return nil, err
+			}
+			relPath, err := filepath.Rel(jirix.Root, p.Path)
+			if err != nil {
+				return nil, err
+			}
+			dir := filepath.Join(outDir, relPath)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+			files, err := gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).FormatPatch(branch, base, dir)
+			if err != nil {
+				return nil, fmt.Errorf("git format-patch failed in %q: %v", p.Path, err)
+			}
+			if len(files) == 0 {
+				break
+			}
+			bp := patchBundleProject{
+				Name:   p.Name,
+				Remote: p.Remote,
+				Path:   filepath.ToSlash(relPath),
+			}
+			for _, file := range files {
+				rel, err := filepath.Rel(outDir, file)
+				if err != nil {
+					return nil, err
+				}
+				bp.Patches = append(bp.Patches, filepath.ToSlash(rel))
+			}
+			bundle.Projects = append(bundle.Projects, bp)
+			break
+		}
+	}
+	out, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize patch bundle: %v", err)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, patchBundleFile), out, 0644); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func runFormatPatch(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	outDir, err := filepath.Abs(formatPatchFlags.outDir)
+	if err != nil {
+		return err
+	}
+	bundle, err := formatPatch(jirix, args[0], outDir)
+	if err != nil {
+		return err
+	}
+	if len(bundle.Projects) == 0 {
+		fmt.Fprintf(jirix.Stdout(), "No commits found on branch %q\n", args[0])
+		return nil
+	}
+	for _, bp := range bundle.Projects {
+		fmt.Fprintf(jirix.Stdout(), "%s: %d patch(es)\n", bp.Name, len(bp.Patches))
+	}
+	fmt.Fprintf(jirix.Stdout(), "Wrote patch bundle to %s\n", outDir)
+	return nil
+}
+
+// applyBundle applies the patch bundle in dir to the local projects, on a
+// new branch named branch, or the branch of the bundle if branch is empty.
+func applyBundle(jirix *jiri.X, dir, branch string) error {
+	data, err := ioutil.ReadFile(filepath.Join(dir, patchBundleFile))
+	if err != nil {
+		return err
+	}
+	var bundle patchBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return fmt.Errorf("invalid patch bundle %q: %v", dir, err)
+	}
+	if branch == "" {
+		branch = bundle.Branch
+	}
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, bp := range bundle.Projects {
+		p, err := projects.FindUnique(bp.Name)
+		if err != nil {
+			// Several projects share the name, the remote tells them apart.
+			p, err = findProjectByNameAndRemote(projects, bp.Name, bp.Remote)
+		}
+		if err != nil {
+			jirix.Logger.Errorf("Cannot apply patches of project %q: %v\n\n", bp.Name, err)
+			jirix.IncrementFailures()
+			failed++
+			continue
+		}
+		if err := applyProjectPatches(jirix, p, dir, branch, bp.Patches); err != nil {
+			jirix.Logger.Errorf("Cannot apply patches of project %q: %v\n\n", bp.Name, err)
+			jirix.IncrementFailures()
+			failed++
+			continue
+		}
+		fmt.Fprintf(jirix.Stdout(), "%s: applied %d patch(es) on branch %s\n", p.Name, len(bp.Patches), branch)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to apply the patches of %d project(s)", failed)
+	}
+	return nil
+}
+
+func findProjectByNameAndRemote(projects project.Projects, name, remote string) (project.Project, error) {
+	for _, p := range projects {
+		if p.Name == name && p.Remote == remote {
+			return p, nil
+		}
+	}
+	return project.Project{}, fmt.Errorf("no project with name %q and remote %q", name, remote)
+}
+
+// applyProjectPatches creates branch in p and applies patches, which are
+// relative to dir, on top of it.
+func applyProjectPatches(jirix *jiri.X, p project.Project, dir, branch string, patches []string) error {
+	git := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	if git.BranchExists(branch) {
+		return fmt.Errorf("branch %q already exists", branch)
+	}
+	if err := project.StartBranch(jirix, p, branch); err != nil {
+		return err
+	}
+	var files []string
+	for _, patch := range patches {
+		files = append(files, filepath.Join(dir, filepath.FromSlash(patch)))
+	}
+	if err := git.Am(files...); err != nil {
+		if err2 := git.AmAbort(); err2 != nil {
+			return fmt.Errorf("%v\nand git am --abort failed: %v", err, err2)
+		}
+		return err
+	}
+	return nil
+}
+
+func runAm(jirix *jiri.X, args []string) error {
+	if len(args) != 1 {
+		return jirix.UsageErrorf("unexpected number of arguments")
+	}
+	dir, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	return applyBundle(jirix, dir, amFlags.branch)
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/jiritest"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+func TestFormatPatchAndAm(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	projects := makeProjects(t, fake)
+
+	for _, p := range []*project.Project{projects[0], projects[3]} {
+		if err := project.StartBranch(fake.X, *p, "feature"); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, fake.X, p.Path, "file1", "one\n")
+		writeFile(t, fake.X, p.Path, "file2", "two\n")
+	}
+
+	outDir := filepath.Join(fake.X.Root, ".patches")
+	bundle, err := formatPatch(fake.X, "feature", outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(bundle.Projects), 2; got != want {
+		t.Fatalf("expected patches for %d projects, got %d: %+v", want, got, bundle)
+	}
+	for i, p := range []*project.Project{projects[0], projects[3]} {
+		bp := bundle.Projects[i]
+		if bp.Name != p.Name || len(bp.Patches) != 2 {
+			t.Errorf("expected 2 patches for %s, got %+v", p.Name, bp)
+		}
+	}
+
+	// A branch whose commits do not apply is reported, but does not prevent
+	// the other projects from being patched.
+	for _, p := range projects {
+		setDummyUser(t, fake.X, p.Path)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, filepath.FromSlash(bundle.Projects[1].Patches[0])), []byte("garbage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyBundle(fake.X, outDir, "imported"); err == nil {
+		t.Fatalf("expected an error applying a corrupt patch")
+	}
+
+	head, err := project.GetHeadRevision(fake.X, *projects[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := gitutil.New(fake.X, gitutil.RootDirOpt(projects[0].Path)).Log("imported", head, "%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0][0] != "two" || commits[1][0] != "one" {
+		t.Errorf("expected commits two and one on imported branch, got %v", commits)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(projects[0].Path, "file2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "two\n" {
+		t.Errorf("unexpected content of file2: %q", data)
+	}
+	if gitutil.New(fake.X, gitutil.RootDirOpt(projects[1].Path)).BranchExists("imported") {
+		t.Errorf("branch imported created in a project without patches")
+	}
+}
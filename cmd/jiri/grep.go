@@ -6,7 +6,11 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"fuchsia.googlesource.com/jiri"
 	"fuchsia.googlesource.com/jiri/cmdline"
@@ -14,57 +18,190 @@ import (
 	"fuchsia.googlesource.com/jiri/project"
 )
 
+var grepFlags struct {
+	ignoreCase     bool
+	filesWithMatch bool
+	wordRegexp     bool
+	lineNumber     bool
+	extendedRegexp bool
+	all            bool
+	selector       string
+}
+
 var cmdGrep = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runGrep),
 	Name:   "grep",
 	Short:  "Search across projects.",
 	Long: `
-Run git grep across all projects.
+Run git grep across all projects under the current directory, or across all
+projects with -all.  If the current directory is inside a project, only its
+part of that project is searched.  With -select, only the projects matching
+the project selector are searched.
+`,
+	ArgsName: "<query> [--] [<pathspec>...]",
+	ArgsLong: `
+<query> is the pattern to search for.
+<pathspec> restricts the search to matching files, see 'git help grep'.
 `,
-	ArgsName: "<query>",
 }
 
-func doGrep(jirix *jiri.X, args []string) ([]string, error) {
-	if len(args) != 1 {
-		return nil, jirix.UsageErrorf("grep requires one argument")
+func init() {
+	flags := &cmdGrep.Flags
+	flags.BoolVar(&grepFlags.ignoreCase, "i", false, "Ignore case differences. Same as 'git grep -i'.")
+	flags.BoolVar(&grepFlags.filesWithMatch, "l", false, "Only show the names of matching files. Same as 'git grep -l'.")
+	flags.BoolVar(&grepFlags.wordRegexp, "w", false, "Only match whole words. Same as 'git grep -w'.")
+	flags.BoolVar(&grepFlags.lineNumber, "n", false, "Prefix the line number to matching lines. Same as 'git grep -n'.")
+	flags.BoolVar(&grepFlags.extendedRegexp, "E", false, "Use POSIX extended regular expressions. Same as 'git grep -E'.")
+	flags.BoolVar(&grepFlags.all, "all", false, "Search all projects, not only the ones under the current directory.")
+	flags.StringVar(&grepFlags.selector, "select", "", selectorFlagHelp)
+}
+
+// grepFlagArgs returns the arguments to pass to git grep.
+func grepFlagArgs() []string {
+	var args []string
+	for _, f := range []struct {
+		set  bool
+		flag string
+	}{
+		{grepFlags.ignoreCase, "-i"},
+		{grepFlags.filesWithMatch, "-l"},
+		{grepFlags.wordRegexp, "-w"},
+		{grepFlags.lineNumber, "-n"},
+		{grepFlags.extendedRegexp, "-E"},
+	} {
+		if f.set {
+			args = append(args, f.flag)
+		}
 	}
+	return args
+}
+
+// grepTarget is a directory of a project in which git grep is run, and the
+// prefix to add to its results.
+type grepTarget struct {
+	name   string
+	dir    string
+	prefix string
+}
+
+// grepTargets returns the directories to search among the projects matching
+// selector.  Unless all is true, these are the projects under cwd, and cwd
+// itself if it is inside a project.
+func grepTargets(jirix *jiri.X, cwd string, all bool, selector *project.Selector) ([]grepTarget, error) {
 	projects, err := project.LocalProjects(jirix, project.FastScan)
 	if err != nil {
 		return nil, err
 	}
-
-	// TODO(ianloic): run in parallel rather than serially.
-	// TODO(ianloic): only run grep on projects under the cwd.
-	var results []string
-	for _, project := range projects {
-		relpath, err := filepath.Rel(jirix.Root, project.Path)
+	if projects, err = selector.Select(jirix, projects); err != nil {
+		return nil, err
+	}
+	var targets []grepTarget
+	// Only the innermost project containing cwd is searched from cwd, as git
+	// would search the innermost one from there anyway.
+	var containing project.Project
+	for _, p := range projects {
+		if !all && strings.HasPrefix(cwd, p.Path+string(filepath.Separator)) {
+			if len(p.Path) > len(containing.Path) {
+				containing = p
+			}
+			continue
+		}
+		relpath, err := filepath.Rel(cwd, p.Path)
 		if err != nil {
 			return nil, err
 		}
-		git := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
-		// TODO(ianloic): allow args to be passed to `git grep`.
-		lines, err := git.Grep(args[0])
-		if err != nil {
+		if !all && (relpath == ".." || strings.HasPrefix(relpath, ".."+string(filepath.Separator))) {
 			continue
 		}
-		for _, line := range lines {
-			// TODO(ianloic): higlight the project path part like `repo grep`.
-			results = append(results, relpath+"/"+line)
+		prefix := ""
+		if relpath != "." {
+			prefix = relpath + "/"
 		}
+		targets = append(targets, grepTarget{p.Name, p.Path, prefix})
 	}
-
-	// TODO(ianloic): fail if all of the sub-greps fail
-	return results, nil
+	if containing.Path != "" {
+		targets = append(targets, grepTarget{containing.Name, cwd, ""})
+	}
+	sort.Sort(grepTargetsByDir(targets))
+	return targets, nil
 }
 
-func runGrep(jirix *jiri.X, args []string) error {
-	lines, err := doGrep(jirix, args)
+type grepTargetsByDir []grepTarget
+
+func (g grepTargetsByDir) Len() int           { return len(g) }
+func (g grepTargetsByDir) Less(i, j int) bool { return g[i].dir < g[j].dir }
+func (g grepTargetsByDir) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+
+// doGrep returns the matches of git grep in each target.  If git grep fails
+// in some of them, the matches in the others are returned along with an error.
+func doGrep(jirix *jiri.X, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, jirix.UsageErrorf("grep requires a query")
+	}
+	query, pathSpecs := args[0], args[1:]
+	if len(pathSpecs) > 0 && pathSpecs[0] == "--" {
+		pathSpecs = pathSpecs[1:]
+	}
+	cwd, err := os.Getwd()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	selector, err := parseSelector(jirix, grepFlags.selector)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := grepTargets(jirix, cwd, grepFlags.all, selector)
+	if err != nil {
+		return nil, err
 	}
+	flags := grepFlagArgs()
 
+	results := make([][]string, len(targets))
+	errs := make([]error, len(targets))
+	sem := make(chan struct{}, jirix.Jobs)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target grepTarget) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			git := gitutil.New(jirix, gitutil.RootDirOpt(target.dir))
+			lines, err := git.Grep(query, pathSpecs, flags...)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			for _, line := range lines {
+				if target.prefix != "" {
+					line = jirix.Color.Magenta("%s", target.prefix) + line
+				}
+				results[i] = append(results[i], line)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	var lines []string
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			jirix.Logger.Warningf("git grep failed in project %s(%s): %v", targets[i].name, targets[i].dir, err)
+			continue
+		}
+		lines = append(lines, results[i]...)
+	}
+	if failed > 0 {
+		return lines, fmt.Errorf("git grep failed in %d of %d projects", failed, len(targets))
+	}
+	return lines, nil
+}
+
+func runGrep(jirix *jiri.X, args []string) error {
+	lines, err := doGrep(jirix, args)
 	for _, line := range lines {
 		fmt.Println(line)
 	}
-	return nil
+	return err
 }
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	"fuchsia.googlesource.com/jiri/gitutil"
@@ -105,4 +106,71 @@ func TestGrep(t *testing.T) {
 	})
 
 	expectGrep(t, fake, []string{"supercalifragilisticexpialidocious"}, []string{})
+
+	grepFlags.ignoreCase = true
+	expectGrep(t, fake, []string{"thou", "--", "*.txt"}, []string{
+		"r.b/file.txt:Thou art more lovely and more temperate:",
+	})
+	grepFlags.ignoreCase = false
+
+	// Only projects under the current directory are searched by default.
+	os.Chdir(filepath.Join(fake.X.Root, "sub"))
+	expectGrep(t, fake, []string{"too"}, []string{
+		"r.t1/file.txt:And summer's lease hath all too short a date:",
+		"sub2/r.t2/file.txt:Sometime too hot the eye of heaven shines,",
+	})
+	grepFlags.all = true
+	expectGrep(t, fake, []string{"summer"}, []string{
+		"../r.a/file.txt:Shall I compare thee to a summer's day?",
+		"r.t1/file.txt:And summer's lease hath all too short a date:",
+	})
+	grepFlags.all = false
+
+	os.Chdir(filepath.Join(fake.X.Root, "sub", "r.t1"))
+	expectGrep(t, fake, []string{"-leading dash"}, []string{})
+	grepFlags.lineNumber = true
+	expectGrep(t, fake, []string{"lease"}, []string{
+		"file.txt:1:And summer's lease hath all too short a date:",
+	})
+	grepFlags.lineNumber = false
+}
+
+// TestGrepFailure checks that a project in which git grep fails makes grep
+// fail without hiding the matches in the other projects.
+func TestGrepFailure(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	os.Chdir(fake.X.Root)
+
+	projects := makeProjects(t, fake)
+	for _, project := range projects {
+		path := project.Path + "/file.txt"
+		if err := ioutil.WriteFile(path, []byte("summer"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		gitutil.New(fake.X, gitutil.RootDirOpt(project.Path)).Add(path)
+	}
+	// git grep cannot read a corrupted index.
+	if err := ioutil.WriteFile(filepath.Join(projects[1].Path, ".git", "index"), []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := doGrep(fake.X, []string{"summer"})
+	if err == nil {
+		t.Fatal("expected grep to fail")
+	}
+	if got, want := len(results), len(projects)-1; got != want {
+		t.Errorf("got %d matches, want %d: %v", got, want, results)
+	}
+	for _, result := range results {
+		if strings.HasPrefix(result, "r.b/") {
+			t.Errorf("unexpected match %q in the failing project", result)
+		}
+	}
 }
@@ -0,0 +1,192 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/cmdline"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+var logFlags struct {
+	since    string
+	author   string
+	snapshot string
+	json     bool
+}
+
+func init() {
+	flags := &cmdLog.Flags
+	flags.StringVar(&logFlags.since, "since", "", "Only show commits more recent than this date. Same as 'git log --since'.")
+	flags.StringVar(&logFlags.author, "author", "", "Only show commits whose author matches this pattern. Same as 'git log --author'.")
+	flags.StringVar(&logFlags.snapshot, "snapshot", "", "Only show commits made since the revisions recorded in this snapshot file.")
+	flags.BoolVar(&logFlags.json, "json", false, "Print the commits as a JSON list.")
+}
+
+var cmdLog = &cmdline.Command{
+	Runner: jiri.RunnerFunc(runLog),
+	Name:   "log",
+	Short:  "Show the merged commit history of projects",
+	Long: `
+Shows the commits of the current branch of each selected project, merged into
+a single stream sorted from newest to oldest.  Each commit is prefixed with the
+name of its project.
+
+With -snapshot, only the commits made on top of the revisions recorded in the
+snapshot are shown.  Projects which are not in the snapshot have their whole
+history shown.
+`,
+	ArgsName: "<project>...",
+	ArgsLong: `
+<project> is a regular expression matching the keys of the projects to show.
+If no projects are given, all projects are shown.
+`,
+}
+
+// logCommit is a commit in the output of "jiri log".
+type logCommit struct {
+	Project string    `json:"project"`
+	Path    string    `json:"path"`
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Subject string    `json:"subject"`
+}
+
+type logCommitsByTime []logCommit
+
+func (l logCommitsByTime) Len() int      { return len(l) }
+func (l logCommitsByTime) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l logCommitsByTime) Less(i, j int) bool {
+	if !l[i].Time.Equal(l[j].Time) {
+		return l[i].Time.After(l[j].Time)
+	}
+	return l[i].Project < l[j].Project
+}
+
+// logFormat is the git log format used to fill in logCommit, one field per
+// line.
+const logFormat = "%H%n%ct%n%an%n%ae%n%s"
+
+// projectLog returns the commits of p that are not reachable from base, or
+// all of its commits if base is empty.
+func projectLog(jirix *jiri.X, p project.Project, base string) ([]logCommit, error) {
+	var opts []gitutil.LogOpt
+	if logFlags.since != "" {
+		opts = append(opts, gitutil.SinceOpt(logFlags.since))
+	}
+	if logFlags.author != "" {
+		opts = append(opts, gitutil.AuthorOpt(logFlags.author))
+	}
+	git := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+	entries, err := git.Log("HEAD", base, logFormat, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []logCommit
+	for _, entry := range entries {
+		if len(entry) < 4 {
+			return nil, fmt.Errorf("unexpected git log output in %q: %q", p.Path, entry)
+		}
+		secs, err := strconv.ParseInt(entry[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad commit time %q in %q: %v", entry[1], p.Path, err)
+		}
+		c := logCommit{
+			Project: p.Name,
+			Path:    p.Path,
+			Hash:    entry[0],
+			Time:    time.Unix(secs, 0),
+			Author:  entry[2],
+			Email:   entry[3],
+		}
+		// Commits with an empty subject have no line for it.
+		if len(entry) > 4 {
+			c.Subject = entry[4]
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// doLog returns the merged history of the projects matching args.
+func doLog(jirix *jiri.X, args []string) ([]logCommit, error) {
+	projects, err := selectProjectsByKey(jirix, args)
+	if err != nil {
+		return nil, err
+	}
+	bases := map[project.ProjectKey]string{}
+	if logFlags.snapshot != "" {
+		snapshotProjects, _, err := project.LoadSnapshotFile(jirix, logFlags.snapshot)
+		if err != nil {
+			return nil, err
+		}
+		for key, p := range snapshotProjects {
+			bases[key] = p.Revision
+		}
+	}
+
+	var keys project.ProjectKeys
+	for key := range projects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+
+	results := make([][]logCommit, len(keys))
+	errs := make([]error, len(keys))
+	sem := make(chan struct{}, jirix.Jobs)
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, p project.Project) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			results[i], errs[i] = projectLog(jirix, p, bases[p.Key()])
+		}(i, projects[key])
+	}
+	wg.Wait()
+
+	var commits []logCommit
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("git log failed in %q: %v", projects[keys[i]].Path, err)
+		}
+		commits = append(commits, results[i]...)
+	}
+	sort.Sort(logCommitsByTime(commits))
+	return commits, nil
+}
+
+func runLog(jirix *jiri.X, args []string) error {
+	commits, err := doLog(jirix, args)
+	if err != nil {
+		return err
+	}
+	if logFlags.json {
+		if commits == nil {
+			commits = []logCommit{}
+		}
+		out, err := json.MarshalIndent(commits, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to serialize JSON output: %s", err)
+		}
+		fmt.Fprintln(jirix.Stdout(), string(out))
+		return nil
+	}
+	for _, c := range commits {
+		fmt.Fprintf(jirix.Stdout(), "%s %s %s %s %s\n", jirix.Color.Magenta("%s", c.Project), jirix.Color.Yellow("%s", c.Hash[:12]), c.Time.Format("2006-01-02 15:04"), c.Author, c.Subject)
+	}
+	return nil
+}
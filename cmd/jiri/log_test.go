@@ -0,0 +1,96 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/jiritest"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+func TestLog(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	defer func() {
+		logFlags.since = ""
+		logFlags.author = ""
+		logFlags.snapshot = ""
+	}()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	os.Chdir(fake.X.Root)
+
+	projects := makeProjects(t, fake)
+	snapshot := filepath.Join(fake.X.Root, "snapshot")
+	if err := project.CreateSnapshot(fake.X, snapshot, false); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, fake.X, projects[0].Path, "file1", "first change")
+	path := filepath.Join(projects[2].Path, "file2")
+	if err := ioutil.WriteFile(path, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(projects[2].Path),
+		gitutil.UserNameOpt("Jane Roe"),
+		gitutil.UserEmailOpt("jane.roe@example.com")).CommitFile(path, "second change"); err != nil {
+		t.Fatal(err)
+	}
+
+	// All history of a single project.
+	commits, err := doLog(fake.X, []string{projects[0].Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) < 2 {
+		t.Fatalf("expected at least 2 commits in %s, got %d", projects[0].Name, len(commits))
+	}
+	for _, c := range commits {
+		if c.Project != projects[0].Name {
+			t.Fatalf("unexpected commit from project %q", c.Project)
+		}
+	}
+
+	// Only the commits made since the snapshot, newest first.
+	logFlags.snapshot = snapshot
+	commits, err = doLog(fake.X, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("expected 2 commits since the snapshot, got %d: %v", len(commits), commits)
+	}
+	if commits[0].Time.Before(commits[1].Time) {
+		t.Errorf("commits are not sorted by time: %v", commits)
+	}
+	subjects := map[string]string{}
+	for _, c := range commits {
+		subjects[c.Project] = c.Subject
+	}
+	if got := subjects[projects[0].Name]; got != "first change" {
+		t.Errorf("expected subject %q for %s, got %q", "first change", projects[0].Name, got)
+	}
+	if got := subjects[projects[2].Name]; got != "second change" {
+		t.Errorf("expected subject %q for %s, got %q", "second change", projects[2].Name, got)
+	}
+
+	logFlags.author = "Jane"
+	commits, err = doLog(fake.X, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Project != projects[2].Name || commits[0].Email != "jane.roe@example.com" {
+		t.Fatalf("expected a single commit by Jane Roe in %s, got %v", projects[2].Name, commits)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -17,6 +19,7 @@ import (
 	"fuchsia.googlesource.com/jiri/git"
 	"fuchsia.googlesource.com/jiri/gitutil"
 	"fuchsia.googlesource.com/jiri/project"
+	"fuchsia.googlesource.com/jiri/review"
 )
 
 var (
@@ -26,6 +29,11 @@ var (
 	patchDeleteFlag bool
 	patchHostFlag   string
 	patchForceFlag  bool
+	patchChainFlag  bool
+	patchQueryFlag  string
+
+	patchCherryPickFlag bool
+	patchCleanupFlag    bool
 )
 
 func init() {
@@ -35,6 +43,10 @@ func init() {
 	cmdPatch.Flags.BoolVar(&patchRebaseFlag, "rebase", false, "Rebase the change after downloading")
 	cmdPatch.Flags.StringVar(&patchHostFlag, "host", "", `Gerrit host to use. Defaults to gerrit host specified in manifest.`)
 	cmdPatch.Flags.BoolVar(&patchTopicFlag, "topic", false, `Patch whole topic.`)
+	cmdPatch.Flags.BoolVar(&patchChainFlag, "chain", false, `Patch the change together with its ancestors in the relation chain.`)
+	cmdPatch.Flags.StringVar(&patchQueryFlag, "query", "", `Patch all changes matching the given Gerrit query.`)
+	cmdPatch.Flags.BoolVar(&patchCherryPickFlag, "cherry-pick", false, `Cherry-pick the change onto the current branch instead of creating a new branch.`)
+	cmdPatch.Flags.BoolVar(&patchCleanupFlag, "cleanup", false, `Delete the branches created by patch which are merged or abandoned.`)
 }
 
 // cmdPatch represents the "jiri patch" command.
@@ -58,19 +70,58 @@ indivisual projects. Patch will assume topic is of form {USER}-{BRANCH} and
 will try to create branch name out of it. If this fails default branch name
 would be same as topic. Currently patch does not support the scenario when
 change "B" is created on top of "A" and both have same topic.
+
+If -chain flag is true jiri will also patch the ancestors of the change in its
+Gerrit relation chain which are not merged yet. When an ancestor has a newer
+patchset than the one the change is based on, the latest patchset of every
+ancestor is used and the change is cherry-picked on top of them.
+
+If -query flag is set jiri will patch every change matching the given Gerrit
+query, e.g. "status:open owner:self", into the local project of the change.
+No argument is expected in this case. A summary of the applied changes is
+printed after patching a topic or a query.
+
+If -cherry-pick flag is true the change is cherry-picked onto the current
+branch of the project, or the current revision if no branch is checked out,
+instead of being checked out on a new branch. With -chain, the ancestors of
+the change are cherry-picked as well.
+
+jiri remembers the branches it creates. If -cleanup flag is true jiri deletes
+the branches it created whose commits are all merged, and the branches not
+changed since they were patched whose changes are merged or abandoned; with
+-force the branches are deleted in any case. When such a branch is checked
+out, the revision of the project in the manifest is checked out first. No
+argument is expected in this case.
+
+For projects whose "reviewtype" is "pullrequest", the change is a pull request
+number or reference "refs/pull/<number>/head", and it is patched on branch
+"pull/<number>" by default.  -chain, -topic and -query only apply to Gerrit.
 `,
 	ArgsName: "<change or topic>",
-	ArgsLong: "<change or topic> is a change ID, full reference or topic when -topic is true.",
+	ArgsLong: "<change or topic> is a change ID, full reference or topic when -topic is true. It must be omitted when -query or -cleanup is set.",
+}
+
+// patchBranch returns the name of the branch the change with the given ref
+// is patched to, which is branch unless it is empty.
+func patchBranch(ref, branch string) (string, error) {
+	if branch != "" {
+		return branch, nil
+	}
+	if n, ok := review.ParsePullRequestRef(ref); ok {
+		return fmt.Sprintf("pull/%v", n), nil
+	}
+	cl, ps, err := gerrit.ParseRefString(ref)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("change/%v/%v", cl, ps), nil
 }
 
 // patchProject checks out the given change.
 func patchProject(jirix *jiri.X, project project.Project, ref, branch, remote string) (bool, error) {
-	if branch == "" {
-		cl, ps, err := gerrit.ParseRefString(ref)
-		if err != nil {
-			return false, err
-		}
-		branch = fmt.Sprintf("change/%v/%v", cl, ps)
+	branch, err := patchBranch(ref, branch)
+	if err != nil {
+		return false, err
 	}
 	jirix.Logger.Infof("Patching project %s(%s) on branch %q\n", project.Name, project.Path, branch)
 	scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
@@ -106,10 +157,228 @@ func patchProject(jirix *jiri.X, project project.Project, ref, branch, remote st
 	if err := scm.CheckoutBranch(branch); err != nil {
 		return false, err
 	}
+	if err := recordPatchedBranch(jirix, project, branch, ref); err != nil {
+		return false, err
+	}
 	jirix.Logger.Infof("Project patched\n")
 	return true, nil
 }
 
+// recordPatchedBranch remembers that branch was created to patch the change
+// with the given ref, and its current revision, so that "jiri patch -cleanup"
+// can delete it unless it was changed since.
+func recordPatchedBranch(jirix *jiri.X, p project.Project, branch, ref string) error {
+	patched, err := project.ReadPatchedBranches(jirix, p)
+	if err != nil {
+		return err
+	}
+	revision, err := git.NewGit(p.Path).CurrentRevisionForRef(branch)
+	if err != nil {
+		return err
+	}
+	patched.Add(project.PatchedBranch{Name: branch, Ref: ref, Revision: revision})
+	return project.WritePatchedBranches(jirix, p, patched)
+}
+
+// updatePatchedRevision records the new revision of the current branch of
+// project p, if it was created by patch.
+func updatePatchedRevision(jirix *jiri.X, p project.Project) error {
+	branch, err := gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).CurrentBranchName()
+	if err != nil {
+		return err
+	}
+	patched, err := project.ReadPatchedBranches(jirix, p)
+	if err != nil {
+		return err
+	}
+	for _, pb := range patched.Branches {
+		if pb.Name == branch {
+			return recordPatchedBranch(jirix, p, branch, pb.Ref)
+		}
+	}
+	return nil
+}
+
+// cherryPickChanges cherry-picks the changes with the given refs, in order,
+// onto the current revision of project.
+func cherryPickChanges(jirix *jiri.X, project project.Project, refs []string) (bool, error) {
+	scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
+	for _, ref := range refs {
+		jirix.Logger.Infof("Cherry-picking %s in project %s(%s)\n", ref, project.Name, project.Path)
+		if err := scm.FetchRefspec("origin", ref); err != nil {
+			return false, err
+		}
+		if err := scm.CherryPick("FETCH_HEAD"); err != nil {
+			if err := scm.CherryPickAbort(); err != nil {
+				return false, err
+			}
+			jirix.Logger.Errorf("Cannot cherry-pick %s in project %q: %s", ref, project.Name, err)
+			jirix.IncrementFailures()
+			return false, nil
+		}
+	}
+	jirix.Logger.Infof("Project patched\n")
+	return true, nil
+}
+
+// applyChange patches the change with the given ref into project, as
+// requested by the flags.
+func applyChange(jirix *jiri.X, g *gerrit.Gerrit, project project.Project, ref, branch, remote string) (bool, error) {
+	if patchChainFlag {
+		return patchChain(jirix, g, project, ref, branch, remote)
+	}
+	if patchCherryPickFlag {
+		return cherryPickChanges(jirix, project, []string{ref})
+	}
+	return patchProject(jirix, project, ref, branch, remote)
+}
+
+// chainAncestors returns the references of the latest patchsets of the
+// unmerged ancestors of the change cl in related, from the oldest to the
+// newest one.  It also returns whether the change is based on an outdated
+// patchset of one of them.
+func chainAncestors(related []gerrit.RelatedChange, cl int) ([]string, bool) {
+	// Related changes are sorted from the newest descendant to the oldest
+	// ancestor.
+	i := 0
+	for i < len(related) && related[i].Number != cl {
+		i++
+	}
+	var refs []string
+	outdated := false
+	for j := len(related) - 1; j > i; j-- {
+		c := related[j]
+		if c.Status == "MERGED" || c.Status == "ABANDONED" {
+			continue
+		}
+		refs = append(refs, c.Reference())
+		if c.RevisionNumber != c.CurrentRevisionNumber {
+			outdated = true
+		}
+	}
+	return refs, outdated
+}
+
+// patchChain checks out the given change together with its unmerged
+// ancestors in the relation chain.
+func patchChain(jirix *jiri.X, g *gerrit.Gerrit, project project.Project, ref, branch, remote string) (bool, error) {
+	cl, ps, err := gerrit.ParseRefString(ref)
+	if err != nil {
+		return false, err
+	}
+	if branch, err = patchBranch(ref, branch); err != nil {
+		return false, err
+	}
+	related, err := g.GetRelatedChanges(cl, strconv.Itoa(ps))
+	if err != nil {
+		return false, err
+	}
+	ancestors, outdated := chainAncestors(related, cl)
+	if patchCherryPickFlag {
+		return cherryPickChanges(jirix, project, append(ancestors, ref))
+	}
+	if !outdated {
+		// The change already contains its ancestors.
+		return patchProject(jirix, project, ref, branch, remote)
+	}
+	jirix.Logger.Infof("Patching %d ancestors of change %d in project %s(%s)\n", len(ancestors), cl, project.Name, project.Path)
+	if ok, err := patchProject(jirix, project, ancestors[0], branch, remote); err != nil || !ok {
+		return ok, err
+	}
+	if ok, err := cherryPickChanges(jirix, project, append(ancestors[1:], ref)); err != nil || !ok {
+		return ok, err
+	}
+	return true, recordPatchedBranch(jirix, project, branch, ref)
+}
+
+// patchResult is the outcome of patching a change found by topic or query.
+type patchResult struct {
+	change  gerrit.Change
+	project string
+	branch  string
+	err     string
+}
+
+// patchChanges patches each of the given changes into the local project of
+// the change.  The latest patchset of each change is used unless ref is not
+// empty.
+func patchChanges(jirix *jiri.X, g *gerrit.Gerrit, changes gerrit.CLList, ref, branch string) ([]patchResult, error) {
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	var results []patchResult
+	for _, change := range changes {
+		ref := ref
+		if ref == "" {
+			ref = change.Reference()
+		}
+		projFound := false
+		for _, p := range projects {
+			if strings.HasSuffix(p.Remote, "/"+change.Project) {
+				projFound = true
+				result := patchResult{change: change, project: p.Name}
+				if patchCherryPickFlag {
+					result.branch, err = gitutil.New(jirix, gitutil.RootDirOpt(p.Path)).CurrentBranchName()
+				} else {
+					result.branch, err = patchBranch(ref, branch)
+				}
+				if err != nil {
+					return nil, err
+				}
+				ok, err := applyChange(jirix, g, p, ref, branch, change.Branch)
+				if err != nil {
+					return nil, err
+				} else if ok {
+					if patchRebaseFlag {
+						if err := rebaseProject(jirix, p, change); err != nil {
+							return nil, err
+						}
+					}
+				} else {
+					result.err = "failed to patch"
+				}
+				results = append(results, result)
+				fmt.Println()
+			}
+		}
+		if !projFound {
+			cl, _, err := gerrit.ParseRefString(ref)
+			if err != nil {
+				return nil, err
+			}
+			jirix.Logger.Errorf("Cannot find project to patch CL %s\n", g.GetChangeURL(cl))
+			jirix.IncrementFailures()
+			results = append(results, patchResult{change: change, err: fmt.Sprintf("no local project for %q", change.Project)})
+			fmt.Println()
+		}
+	}
+	return results, nil
+}
+
+// printPatchSummary prints which of the changes were applied.
+func printPatchSummary(jirix *jiri.X, g *gerrit.Gerrit, results []patchResult) {
+	var applied, failed []patchResult
+	for _, r := range results {
+		if r.err == "" {
+			applied = append(applied, r)
+		} else {
+			failed = append(failed, r)
+		}
+	}
+	w := jirix.Stdout()
+	fmt.Fprintf(w, "Applied %d of %d changes\n", len(applied), len(results))
+	for _, r := range applied {
+		fmt.Fprintf(w, "  %s %s: project %s, branch %q\n", g.GetChangeURL(r.change.Number), r.change.Subject, r.project, r.branch)
+	}
+	if len(failed) != 0 {
+		fmt.Fprintf(w, "Not applied:\n")
+		for _, r := range failed {
+			fmt.Fprintf(w, "  %s %s: %s\n", g.GetChangeURL(r.change.Number), r.change.Subject, r.err)
+		}
+	}
+}
+
 // rebaseProject rebases the current branch on top of a given branch.
 func rebaseProject(jirix *jiri.X, project project.Project, change gerrit.Change) error {
 	jirix.Logger.Infof("Rebasing project %s(%s)\n", project.Name, project.Path)
@@ -128,21 +397,210 @@ func rebaseProject(jirix *jiri.X, project project.Project, change gerrit.Change)
 		return nil
 	}
 	jirix.Logger.Infof("Project rebased\n")
+	return updatePatchedRevision(jirix, project)
+}
+
+// cleanupReason returns why branch of project p, created by patch as
+// recorded in pb, can be deleted, or "" if it is still needed.  Unless all its
+// commits are merged, a branch changed since it was patched is kept.
+func cleanupReason(jirix *jiri.X, p project.Project, branch project.BranchState, pb project.PatchedBranch) (string, error) {
+	if patchForceFlag {
+		return "forced", nil
+	}
+	reason, err := mergedReason(jirix, p, branch)
+	if err != nil || reason != "" {
+		return reason, err
+	}
+	if pb.Revision == "" || branch.Revision != pb.Revision {
+		return "", nil
+	}
+	ref := pb.Ref
+	if n, ok := review.ParsePullRequestRef(ref); ok {
+		backend, err := review.New(jirix, p, patchHostFlag)
+		if err == review.ErrNoHost {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		change, err := backend.GetChange(n)
+		if err != nil {
+			return "", err
+		}
+		switch change.Status {
+		case "MERGED":
+			return "pull request merged", nil
+		case "ABANDONED":
+			return "pull request closed", nil
+		}
+		return "", nil
+	}
+	host := patchHostFlag
+	if host == "" {
+		host = p.GerritHost
+	}
+	if host == "" || ref == "" {
+		return "", nil
+	}
+	cl, _, err := gerrit.ParseRefString(ref)
+	if err != nil {
+		return "", err
+	}
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid Gerrit host %q: %v", host, err)
+	}
+	change, err := jirix.Gerrit(hostURL).GetChange(cl)
+	if err != nil {
+		return "", err
+	}
+	switch change.Status {
+	case "MERGED":
+		return "change merged in Gerrit", nil
+	case "ABANDONED":
+		return "change abandoned", nil
+	}
+	return "", nil
+}
+
+// cleanupPatchedBranches deletes the branches created by patch which are no
+// longer needed.
+func cleanupPatchedBranches(jirix *jiri.X) error {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return err
+	}
+	remoteProjects, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, false /*localManifest*/)
+	if err != nil {
+		return err
+	}
+	cDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	keys := project.ProjectKeys{}
+	for key := range localProjects {
+		keys = append(keys, key)
+	}
+	sort.Sort(keys)
+	deleted := 0
+	for _, key := range keys {
+		p := localProjects[key]
+		patched, err := project.ReadPatchedBranches(jirix, p)
+		if err != nil {
+			return err
+		}
+		if len(patched.Branches) == 0 {
+			continue
+		}
+		states, err := project.GetProjectStates(jirix, project.Projects{key: p}, false)
+		if err != nil {
+			return err
+		}
+		state := states[key]
+		branches := make(map[string]project.BranchState)
+		for _, b := range state.Branches {
+			branches[b.Name] = b
+		}
+		relativePath, err := filepath.Rel(cDir, p.Path)
+		if err != nil {
+			return err
+		}
+		scm := gitutil.New(jirix, gitutil.RootDirOpt(p.Path))
+		var kept []project.PatchedBranch
+		for _, pb := range patched.Branches {
+			branch, ok := branches[pb.Name]
+			if !ok {
+				// The branch was deleted by other means.
+				continue
+			}
+			reason, err := cleanupReason(jirix, p, branch, pb)
+			if err != nil {
+				fmt.Print(jirix.Color.Red("Project %s: not able to check branch %q: %s\n", p.Name, pb.Name, err))
+				jirix.IncrementFailures()
+				kept = append(kept, pb)
+				continue
+			}
+			if reason == "" {
+				kept = append(kept, pb)
+				continue
+			}
+			if pb.Name == state.CurrentBranch.Name {
+				// Restore the revision of the project in the manifest.
+				manifestProject, ok := remoteProjects[key]
+				if !ok {
+					manifestProject = p
+				}
+				revision, err := project.GetHeadRevision(jirix, manifestProject)
+				if err != nil {
+					return err
+				}
+				if err := scm.CheckoutBranch(revision, gitutil.DetachOpt(true)); err != nil {
+					fmt.Print(jirix.Color.Red("Project %s: not able to check out %s: %s\n", p.Name, revision, err))
+					jirix.IncrementFailures()
+					kept = append(kept, pb)
+					continue
+				}
+			}
+			// The commits may have been cherry-picked upstream, so git cannot
+			// tell that the branch is merged, and the branch was not changed
+			// since it was patched unless -force is set.
+			if err := scm.DeleteBranch(pb.Name, gitutil.ForceOpt(true)); err != nil {
+				fmt.Print(jirix.Color.Red("Project %s: not able to delete branch %q: %s\n", p.Name, pb.Name, err))
+				jirix.IncrementFailures()
+				kept = append(kept, pb)
+				continue
+			}
+			fmt.Printf("Project %s(%s): %s (%s)\n", p.Name, relativePath, jirix.Color.Green("Deleted branch %s", pb.Name), reason)
+			deleted++
+		}
+		patched.Branches = kept
+		if err := project.WritePatchedBranches(jirix, p, patched); err != nil {
+			return err
+		}
+	}
+	if deleted == 0 {
+		fmt.Println("No branches to clean up")
+	}
+	if jirix.Failures() != 0 {
+		return fmt.Errorf("Patch cleanup failed")
+	}
 	return nil
 }
 
 func runPatch(jirix *jiri.X, args []string) error {
-	if expected, got := 1, len(args); expected != got {
+	if patchCleanupFlag {
+		if len(args) != 0 {
+			return jirix.UsageErrorf("-cleanup does not take arguments")
+		}
+		return cleanupPatchedBranches(jirix)
+	}
+	if patchCherryPickFlag && (patchRebaseFlag || patchBranchFlag != "") {
+		return jirix.UsageErrorf("-cherry-pick cannot be used with -rebase or -branch")
+	}
+	expected := 1
+	if patchQueryFlag != "" {
+		if patchTopicFlag {
+			return jirix.UsageErrorf("-topic and -query cannot be used together")
+		}
+		expected = 0
+	}
+	if got := len(args); expected != got {
 		return jirix.UsageErrorf("unexpected number of arguments: expected %v, got %v", expected, got)
 	}
-	arg := args[0]
+	arg := ""
+	if len(args) != 0 {
+		arg = args[0]
+	}
+	multiple := patchTopicFlag || patchQueryFlag != ""
 
 	var cl int
 	var ps int
 	var err error
-	if !patchTopicFlag {
+	if !multiple {
 		cl, ps, err = gerrit.ParseRefString(arg)
-		if err != nil {
+		if n, ok := review.ParsePullRequestRef(arg); ok {
+			cl, err = n, nil
+		} else if err != nil {
 			cl, err = strconv.Atoi(arg)
 			if err != nil {
 				return fmt.Errorf("invalid argument: %v", arg)
@@ -151,7 +609,30 @@ func runPatch(jirix *jiri.X, args []string) error {
 	}
 
 	p, perr := currentProject(jirix)
-	if !patchTopicFlag && perr == nil {
+	if !multiple && perr == nil && review.Type(p) != review.TypeGerrit {
+		if patchChainFlag {
+			return fmt.Errorf("-chain is only supported for Gerrit projects")
+		}
+		backend, err := review.New(jirix, p, patchHostFlag)
+		if err == review.ErrNoHost {
+			return fmt.Errorf("no review host; use the '--host' flag, or add a '%s' attribute for project %q", review.HostAttribute(p), p.Name)
+		} else if err != nil {
+			return err
+		}
+		change, err := backend.GetChange(cl)
+		if err != nil {
+			return err
+		}
+		ok, err := applyChange(jirix, nil, p, change.Ref, patchBranchFlag, change.Branch)
+		if err != nil {
+			return err
+		}
+		if ok && patchRebaseFlag {
+			if err := rebaseProject(jirix, p, gerrit.Change{Branch: change.Branch}); err != nil {
+				return err
+			}
+		}
+	} else if !multiple && perr == nil {
 		host := patchHostFlag
 		if host == "" {
 			if p.GerritHost == "" {
@@ -170,15 +651,13 @@ func runPatch(jirix *jiri.X, args []string) error {
 			return err
 		}
 		branch := patchBranchFlag
-		ok := false
-		if ps != -1 {
-			if ok, err = patchProject(jirix, p, arg, branch, change.Branch); err != nil {
-				return err
-			}
-		} else {
-			if ok, err = patchProject(jirix, p, change.Reference(), branch, change.Branch); err != nil {
-				return err
-			}
+		ref := arg
+		if ps == -1 {
+			ref = change.Reference()
+		}
+		ok, err := applyChange(jirix, g, p, ref, branch, change.Branch)
+		if err != nil {
+			return err
 		}
 		if ok && patchRebaseFlag {
 			if err := rebaseProject(jirix, p, *change); err != nil {
@@ -187,7 +666,7 @@ func runPatch(jirix *jiri.X, args []string) error {
 		}
 	} else {
 		host := patchHostFlag
-		if host == "" && patchTopicFlag {
+		if host == "" && multiple {
 			if perr == nil {
 				host = p.GerritHost
 			}
@@ -222,6 +701,15 @@ func runPatch(jirix *jiri.X, args []string) error {
 					branch = arg
 				}
 			}
+		} else if patchQueryFlag != "" {
+			changes, err = g.Query(patchQueryFlag)
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				return fmt.Errorf("No changes found matching query %q", patchQueryFlag)
+			}
+			ps = -1
 		} else {
 			change, err := g.GetChange(cl)
 			if err != nil {
@@ -229,42 +717,16 @@ func runPatch(jirix *jiri.X, args []string) error {
 			}
 			changes = append(changes, *change)
 		}
-		projects, err := project.LocalProjects(jirix, project.FastScan)
+		ref := ""
+		if ps != -1 {
+			ref = arg
+		}
+		results, err := patchChanges(jirix, g, changes, ref, branch)
 		if err != nil {
 			return err
 		}
-		for _, change := range changes {
-			var ref string
-			if ps != -1 {
-				ref = arg
-			} else {
-				ref = change.Reference()
-			}
-			projFound := false
-			for _, p := range projects {
-				if strings.HasSuffix(p.Remote, "/"+change.Project) {
-					projFound = true
-					if ok, err := patchProject(jirix, p, ref, branch, change.Branch); err != nil {
-						return err
-					} else if ok {
-						if patchRebaseFlag {
-							if err := rebaseProject(jirix, p, change); err != nil {
-								return err
-							}
-						}
-					}
-					fmt.Println()
-				}
-			}
-			if !projFound {
-				cl, _, err := gerrit.ParseRefString(ref)
-				if err != nil {
-					return err
-				}
-				jirix.Logger.Errorf("Cannot find project to patch CL %s\n", g.GetChangeURL(cl))
-				jirix.IncrementFailures()
-				fmt.Println()
-			}
+		if multiple {
+			printPatchSummary(jirix, g, results)
 		}
 	}
 	if jirix.Failures() != 0 {
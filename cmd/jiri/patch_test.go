@@ -0,0 +1,467 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fuchsia.googlesource.com/jiri/credentials"
+	"fuchsia.googlesource.com/jiri/gerrit"
+	"fuchsia.googlesource.com/jiri/git"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/jiritest"
+	"fuchsia.googlesource.com/jiri/project"
+	"fuchsia.googlesource.com/jiri/tool"
+)
+
+// createRemoteChange commits file with the given content on top of parent in
+// the remote repository dir, and points ref to the new commit.  It returns
+// the commit and leaves the checked out branch of dir unchanged.
+func createRemoteChange(t *testing.T, fake *jiritest.FakeJiriRoot, dir, parent, ref, file, content string) string {
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(dir))
+	head, err := git.NewGit(dir).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Reset(parent); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, dir, file, content)
+	rev, err := git.NewGit(dir).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("git", "-C", dir, "update-ref", ref, rev).CombinedOutput(); err != nil {
+		t.Fatalf("update-ref failed: %v\n%s", err, out)
+	}
+	if err := scm.Reset(head); err != nil {
+		t.Fatal(err)
+	}
+	return rev
+}
+
+// checkPatchedFile checks that file has the given content on the given
+// branch of the project at dir.
+func checkPatchedFile(t *testing.T, fake *jiritest.FakeJiriRoot, dir, branch, file, want string) {
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(dir)).CheckoutBranch(branch); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("branch %q: got %q in %s, want %q", branch, got, file, want)
+	}
+}
+
+func TestPatchChain(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	related := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/tools/hooks/commit-msg":
+			fmt.Fprint(w, "#!/bin/sh\nexit 0\n")
+		case r.URL.Path == "/changes/2/revisions/1/related":
+			fmt.Fprintf(w, ")]}'\n%s", related)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	projects := makeGerritProjects(t, fake, server.URL)
+	p := *projects[0]
+	setDummyUser(t, fake.X, p.Path)
+
+	// Change 2 is based on the first patchset of change 1, which has a
+	// second patchset.
+	remote := fake.Projects[p.Name]
+	base, err := git.NewGit(remote).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := createRemoteChange(t, fake, remote, base, "refs/changes/01/1/1", "a", "A1")
+	createRemoteChange(t, fake, remote, a1, "refs/changes/02/2/1", "b", "B")
+	createRemoteChange(t, fake, remote, base, "refs/changes/01/1/2", "a", "A2")
+	hostUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := fake.X.Gerrit(hostUrl)
+
+	// When the chain is up to date the change is patched as is.
+	related = `{"changes": [
+		{"project": "r.a", "_change_number": 2, "_revision_number": 1, "_current_revision_number": 1, "status": "NEW"},
+		{"project": "r.a", "_change_number": 1, "_revision_number": 1, "_current_revision_number": 1, "status": "NEW"}
+	]}`
+	if ok, err := patchChain(fake.X, g, p, "refs/changes/02/2/1", "current", "master"); err != nil || !ok {
+		t.Fatalf("patchChain failed: %v, %v", ok, err)
+	}
+	checkPatchedFile(t, fake, p.Path, "current", "a", "A1")
+	checkPatchedFile(t, fake, p.Path, "current", "b", "B")
+
+	// Otherwise the change is cherry-picked on top of the latest patchsets.
+	related = `{"changes": [
+		{"project": "r.a", "_change_number": 2, "_revision_number": 1, "_current_revision_number": 1, "status": "NEW"},
+		{"project": "r.a", "_change_number": 1, "_revision_number": 1, "_current_revision_number": 2, "status": "NEW"}
+	]}`
+	if ok, err := patchChain(fake.X, g, p, "refs/changes/02/2/1", "", "master"); err != nil || !ok {
+		t.Fatalf("patchChain failed: %v, %v", ok, err)
+	}
+	checkPatchedFile(t, fake, p.Path, "change/2/1", "a", "A2")
+	checkPatchedFile(t, fake, p.Path, "change/2/1", "b", "B")
+}
+
+func TestPatchChanges(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var queries []string
+	changes := "[]"
+	server := newFakeGerrit(&changes, &queries)
+	defer server.Close()
+	projects := makeGerritProjects(t, fake, server.URL)
+
+	for i, p := range projects {
+		remote := fake.Projects[p.Name]
+		base, err := git.NewGit(remote).CurrentRevision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref := gerrit.ChangeReference(i+1, 1)
+		createRemoteChange(t, fake, remote, base, ref, "file", p.Name)
+	}
+	changes = `[
+		{"_number": 1, "project": "r.a", "branch": "master", "subject": "Change a", "current_revision": "1", "revisions": {"1": {"fetch": {"http": {"ref": "refs/changes/01/1/1"}}}}},
+		{"_number": 2, "project": "r.b", "branch": "master", "subject": "Change b", "current_revision": "2", "revisions": {"2": {"fetch": {"http": {"ref": "refs/changes/02/2/1"}}}}},
+		{"_number": 3, "project": "elsewhere", "branch": "master", "subject": "Change c", "current_revision": "3", "revisions": {"3": {"fetch": {"http": {"ref": "refs/changes/03/3/1"}}}}}
+	]`
+	hostUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := fake.X.Gerrit(hostUrl)
+	list, err := g.Query("status:open owner:self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := patchChanges(fake.X, g, list, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	for i, p := range projects {
+		r := results[i]
+		branch := fmt.Sprintf("change/%d/1", i+1)
+		if r.err != "" || r.project != p.Name || r.branch != branch {
+			t.Errorf("unexpected result: %+v", r)
+		}
+		checkPatchedFile(t, fake, p.Path, branch, "file", p.Name)
+	}
+	if r := results[2]; r.err == "" || r.change.Number != 3 {
+		t.Errorf("unexpected result: %+v", r)
+	}
+
+	if fake.X.Failures() != 1 {
+		t.Errorf("expected 1 failure, got %d", fake.X.Failures())
+	}
+
+	var stdout bytes.Buffer
+	fake.X.Context = tool.NewContext(tool.ContextOpts{Stdout: &stdout, Env: fake.X.Context.Env()})
+	printPatchSummary(fake.X, g, results)
+	for _, want := range []string{"Applied 2 of 3 changes", `project r.b, branch "change/2/1"`, server.URL + `/c/3 Change c: no local project for "elsewhere"`} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("summary %q does not contain %q", stdout.String(), want)
+		}
+	}
+}
+
+func TestPatchCherryPick(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	var queries []string
+	changes := "[]"
+	server := newFakeGerrit(&changes, &queries)
+	defer server.Close()
+	projects := makeGerritProjects(t, fake, server.URL)
+	p := *projects[0]
+	setDummyUser(t, fake.X, p.Path)
+
+	remote := fake.Projects[p.Name]
+	base, err := git.NewGit(remote).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	createRemoteChange(t, fake, remote, base, "refs/changes/01/1/1", "a", "A")
+	if err := project.StartBranch(fake.X, p, "work"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, p.Path, "b", "B")
+
+	patchCherryPickFlag = true
+	defer func() { patchCherryPickFlag = false }()
+	if ok, err := applyChange(fake.X, nil, p, "refs/changes/01/1/1", "", "master"); err != nil || !ok {
+		t.Fatalf("applyChange failed: %v, %v", ok, err)
+	}
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if branch, err := scm.CurrentBranchName(); err != nil || branch != "work" {
+		t.Errorf("got current branch %q, %v, want %q", branch, err, "work")
+	}
+	if scm.BranchExists("change/1/1") {
+		t.Errorf("branch change/1/1 should not exist")
+	}
+	checkPatchedFile(t, fake, p.Path, "work", "a", "A")
+	checkPatchedFile(t, fake, p.Path, "work", "b", "B")
+	patched, err := project.ReadPatchedBranches(fake.X, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patched.Branches) != 0 {
+		t.Errorf("expected no patched branches, got %+v", patched)
+	}
+}
+
+func TestPatchCleanup(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	statuses := map[string]string{"1": "ABANDONED", "3": "NEW", "4": "ABANDONED"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/tools/hooks/commit-msg":
+			fmt.Fprint(w, "#!/bin/sh\nexit 0\n")
+		case strings.HasSuffix(r.URL.Path, "/changes/"):
+			q := r.URL.Query().Get("q")
+			fmt.Fprintf(w, ")]}'\n[{\"_number\": %s, \"status\": %q}]", q, statuses[q])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	projects := makeGerritProjects(t, fake, server.URL)
+	p := *projects[0]
+
+	remote := fake.Projects[p.Name]
+	base, err := git.NewGit(remote).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		createRemoteChange(t, fake, remote, base, gerrit.ChangeReference(i, 1), fmt.Sprintf("file%d", i), "content")
+	}
+	for i := 1; i <= 4; i++ {
+		if ok, err := patchProject(fake.X, p, gerrit.ChangeReference(i, 1), "", "master"); err != nil || !ok {
+			t.Fatalf("patchProject failed: %v, %v", ok, err)
+		}
+	}
+	// Change 4 is abandoned, but work was committed on top of it.
+	writeFile(t, fake.X, p.Path, "local", "local work")
+	if err := project.StartBranch(fake.X, p, "mine"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Change 2 is merged upstream, and change 1 is checked out.
+	merged, err := git.NewGit(remote).CurrentRevisionForRef(gerrit.ChangeReference(2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gitutil.New(fake.X, gitutil.RootDirOpt(remote)).Reset(merged); err != nil {
+		t.Fatal(err)
+	}
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if err := scm.Fetch("origin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CheckoutBranch("change/1/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanupPatchedBranches(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	for branch, exists := range map[string]bool{"change/1/1": false, "change/2/1": false, "change/3/1": true, "change/4/1": true, "mine": true} {
+		if got := scm.BranchExists(branch); got != exists {
+			t.Errorf("branch %q: got exists %v, want %v", branch, got, exists)
+		}
+	}
+	if rev, err := git.NewGit(p.Path).CurrentRevision(); err != nil || rev != merged {
+		t.Errorf("got revision %q, %v, want manifest revision %q", rev, err, merged)
+	}
+	patched, err := project.ReadPatchedBranches(fake.X, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patched.Branches) != 2 || patched.Branches[0].Name != "change/3/1" || patched.Branches[0].Ref != gerrit.ChangeReference(3, 1) {
+		t.Errorf("unexpected patched branches: %+v", patched)
+	}
+	rev, err := git.NewGit(remote).CurrentRevisionForRef(gerrit.ChangeReference(3, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Branches[0].Revision != rev {
+		t.Errorf("got patched revision %q, want %q", patched.Branches[0].Revision, rev)
+	}
+
+	// -force deletes the branch changed since it was patched.
+	patchForceFlag = true
+	defer func() { patchForceFlag = false }()
+	if err := cleanupPatchedBranches(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	if scm.BranchExists("change/4/1") {
+		t.Errorf("branch %q was not deleted with -force", "change/4/1")
+	}
+}
+
+func TestPatchUploadedChange(t *testing.T) {
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	g, stop := jiritest.NewFakeGerrit(t)
+	defer stop()
+	// Submitting changes requires credentials.
+	os.Setenv(credentials.TokenEnv, "token")
+	defer os.Unsetenv(credentials.TokenEnv)
+	projects := makeGerritProjects(t, fake, g.URL)
+	p := *projects[0]
+	setDummyUser(t, fake.X, p.Path)
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(currentDir)
+	if err := os.Chdir(p.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Upload a change; the commit-msg hook of the Gerrit host adds its
+	// Change-Id.
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if err := scm.CreateBranchWithUpstream("feature", "origin/master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, p.Path, "a", "A")
+	if err := runUpload(fake.X, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	changes := g.Changes()
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change, got %+v", changes)
+	}
+	change := changes[0]
+	if message := change.Revisions[change.Current_revision].Commit.Message; !strings.Contains(message, "Change-Id: "+change.Change_id) {
+		t.Errorf("change %+v has no Change-Id in its message %q", change, message)
+	}
+
+	if err := scm.CheckoutBranch("master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPatch(fake.X, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	checkPatchedFile(t, fake, p.Path, "change/1/1", "a", "A")
+
+	// Once the change is submitted, its branch is cleaned up.
+	hostUrl, err := url.Parse(g.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.X.Gerrit(hostUrl).Submit(change.Change_id); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := git.NewGit(fake.Projects[p.Name]).CurrentRevision(); err != nil || rev != change.Current_revision {
+		t.Errorf("got remote revision %q, %v, want %q", rev, err, change.Current_revision)
+	}
+	if err := cleanupPatchedBranches(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	if scm.BranchExists("change/1/1") {
+		t.Errorf("branch change/1/1 was not cleaned up")
+	}
+}
+
+func TestPatchPullRequest(t *testing.T) {
+	defer resetFlags()
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	forge, stop := jiritest.NewFakeForge(t)
+	defer stop()
+	if err := fake.CreateRemoteProject("r.a"); err != nil {
+		t.Fatal(err)
+	}
+	p := project.Project{
+		Name:         "r.a",
+		Path:         filepath.Join(fake.X.Root, "r.a"),
+		Remote:       fake.Projects["r.a"],
+		RemoteBranch: "master",
+		ReviewType:   "pullrequest",
+		ReviewHost:   forge.URL,
+	}
+	if err := fake.AddProject(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	setDummyUser(t, fake.X, p.Path)
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(currentDir)
+	if err := os.Chdir(p.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path))
+	if err := scm.CreateBranchWithUpstream("feature", "origin/master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, p.Path, "a", "A")
+	uploadTopicFlag = "feature"
+	if err := runUpload(fake.X, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	pulls := forge.PullRequests()
+	if len(pulls) != 1 || pulls[0].Head != "jiri/feature" || pulls[0].Base != "master" || pulls[0].Title != "A" {
+		t.Fatalf("unexpected pull requests %+v", pulls)
+	}
+
+	if err := scm.CheckoutBranch("master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runPatch(fake.X, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	checkPatchedFile(t, fake, p.Path, "pull/1", "a", "A")
+
+	// Once the pull request is merged, its branch is cleaned up.
+	if err := forge.Merge(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanupPatchedBranches(fake.X); err != nil {
+		t.Fatal(err)
+	}
+	if scm.BranchExists("pull/1") {
+		t.Errorf("branch pull/1 was not cleaned up")
+	}
+}
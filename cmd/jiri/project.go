@@ -24,6 +24,7 @@ var (
 	cleanupFlag    bool
 	jsonOutputFlag string
 	regexpFlag     bool
+	selectorFlag   string
 	templateFlag   string
 )
 
@@ -32,6 +33,7 @@ func init() {
 	cmdProject.Flags.BoolVar(&cleanupFlag, "clean", false, "Restore jiri projects to their pristine state.")
 	cmdProject.Flags.StringVar(&jsonOutputFlag, "json-output", "", "Path to write operation results to.")
 	cmdProject.Flags.BoolVar(&regexpFlag, "regexp", false, "Use argument as regular expression.")
+	cmdProject.Flags.StringVar(&selectorFlag, "select", "", selectorFlagHelp)
 	cmdProject.Flags.StringVar(&templateFlag, "template", "", "The template for the fields to display.")
 }
 
@@ -46,9 +48,10 @@ var cmdProject = &cmdline.Command{
 	regular expressions that are matched against project names. If no
 	command line arguments are provided the project that the contains the
 	current directory is used, or if run from outside of a given project,
-	all projects will be used. The information to be displayed can be
-	specified using a Go template, supplied via
-the -template flag.`,
+	all projects will be used. With -select, the projects matching the
+	project selector are used, restricted to the given projects if any.
+	The information to be displayed can be specified using a Go template,
+	supplied via the -template flag.`,
 	ArgsName: "<project ...>",
 	ArgsLong: "<project ...> is a list of projects to clean up or give info about.",
 }
@@ -92,6 +95,13 @@ func runProjectClean(jirix *jiri.X, args []string) (e error) {
 	} else {
 		projects = localProjects
 	}
+	selector, err := parseSelector(jirix, selectorFlag)
+	if err != nil {
+		return err
+	}
+	if projects, err = selector.Select(jirix, projects); err != nil {
+		return err
+	}
 	if err := project.CleanupProjects(jirix, projects, cleanAllFlag); err != nil {
 		return err
 	}
@@ -137,7 +147,7 @@ func runProjectInfo(jirix *jiri.X, args []string) error {
 	if err != nil {
 		return err
 	}
-	if len(args) == 0 {
+	if len(args) == 0 && selectorFlag == "" {
 		currentProjectKey, err := project.CurrentProjectKey(jirix)
 		if err != nil {
 			return err
@@ -161,12 +171,14 @@ func runProjectInfo(jirix *jiri.X, args []string) error {
 		}
 	} else {
 		var err error
-		states, err = project.GetProjectStates(jirix, projects, false)
+		states, err = selectStates(jirix, projects, selectorFlag, false)
 		if err != nil {
 			return err
 		}
 		for key, state := range states {
-			if regexpFlag {
+			if len(args) == 0 {
+				keys = append(keys, key)
+			} else if regexpFlag {
 				for _, re := range regexps {
 					if re.MatchString(state.Project.Name) {
 						keys = append(keys, key)
@@ -6,6 +6,8 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -13,10 +15,14 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
 	"fuchsia.googlesource.com/jiri"
 	"fuchsia.googlesource.com/jiri/cmdline"
@@ -40,6 +46,27 @@ func newRunP() *cmdline.Command {
 Run a command in parallel across one or more jiri projects. Commands are run
 using the shell specified by the users $SHELL environment variable, or "sh"
 if that's not set. Thus commands are run as $SHELL -c "args..."
+
+Each command is run with the following environment variables describing the
+project it is run in:
+  JIRI_ROOT                   the jiri root directory
+  JIRI_PROJECT_NAME           the name of the project
+  JIRI_PROJECT_KEY            the key of the project
+  JIRI_PROJECT_PATH           the absolute path of the project
+  JIRI_PROJECT_REMOTE         the remote of the project
+  JIRI_PROJECT_REMOTE_BRANCH  the remote branch of the project
+  JIRI_PROJECT_REVISION       the revision of the project in the manifest
+
+The command line may also contain Go template placeholders, which are
+expanded for each project before the command is run, with the same fields as
+"jiri project -template": {{.Name}}, {{.Path}}, {{.Remote}}, {{.Revision}},
+{{.CurrentBranch}} and {{.Branches}}.  Expanded values are not quoted for the
+shell.
+
+Once all commands are done, the projects where they failed, timed out or
+were cancelled are listed.  With -log-dir, the output of each command is also kept in
+<log-dir>/<project path>.stdout and .stderr, and with -json-output, the exit
+code, duration and log files of each command are written to a file.
  `,
 		ArgsName: "<command line>",
 		ArgsLong: `
@@ -53,6 +80,7 @@ runp by the shell.
 
 type runpFlagValues struct {
 	projectKeys    string
+	selector       string
 	verbose        bool
 	interactive    bool
 	uncommitted    bool
@@ -64,11 +92,15 @@ type runpFlagValues struct {
 	exitOnError    bool
 	collateOutput  bool
 	branch         string
+	timeout        time.Duration
+	jsonOutput     string
+	logDir         string
 }
 
 func registerCommonFlags(flags *flag.FlagSet, values *runpFlagValues) {
 	flags.BoolVar(&values.verbose, "v", false, "Print verbose logging information")
 	flags.StringVar(&values.projectKeys, "projects", "", "A Regular expression specifying project keys to run commands in. By default, runp will use projects that have the same branch checked as the current project unless it is run from outside of a project in which case it will default to using all projects.")
+	flags.StringVar(&values.selector, "select", "", selectorFlagHelp)
 	flags.BoolVar(&values.uncommitted, "uncommitted", false, "Match projects that have uncommitted changes")
 	flags.BoolVar(&values.noUncommitted, "no-uncommitted", false, "Match projects that have no uncommitted changes")
 	flags.BoolVar(&values.untracked, "untracked", false, "Match projects that have untracked files")
@@ -79,6 +111,9 @@ func registerCommonFlags(flags *flag.FlagSet, values *runpFlagValues) {
 	flags.BoolVar(&values.collateOutput, "collate-stdout", true, "Collate all stdout output from each parallel invocation and display it as if had been generated sequentially. This flag cannot be used with -show-name-prefix, -show-key-prefix or -interactive.")
 	flags.BoolVar(&values.exitOnError, "exit-on-error", false, "If set, all commands will killed as soon as one reports an error, otherwise, each will run to completion.")
 	flags.StringVar(&values.branch, "branch", "", "A regular expression specifying branch names to use in matching projects. A project will match if the specified branch exists, even if it is not checked out.")
+	flags.DurationVar(&values.timeout, "timeout", 0, "If set, commands still running after this duration are killed and reported as failed.")
+	flags.StringVar(&values.jsonOutput, "json-output", "", "Path to write the result of the command in each project to, as JSON.")
+	flags.StringVar(&values.logDir, "log-dir", "", "Directory to keep the stdout and stderr of the command in each project in. This flag cannot be used with -interactive.")
 }
 
 func init() {
@@ -95,6 +130,9 @@ type mapInput struct {
 	jirix        *jiri.X
 	index, total int
 	result       error
+	// command is the command line to run in the project, with its
+	// template placeholders expanded.
+	command string
 }
 
 func newmapInput(jirix *jiri.X, project project.Project, key project.ProjectKey, index, total int) *mapInput {
@@ -126,9 +164,63 @@ func projectKeys(mapInputs map[project.ProjectKey]*mapInput) []string {
 }
 
 type runner struct {
-	args                 []string
 	serializedWriterLock sync.Mutex
 	collatedOutputLock   sync.Mutex
+	resultsLock          sync.Mutex
+	results              []runpResult
+	// mapping tracks the commands started, as simplemr does not wait for
+	// them once cancelled.
+	mapping sync.WaitGroup
+}
+
+// runpResult describes how the command ran in a project.
+type runpResult struct {
+	Name      string  `json:"name"`
+	Key       string  `json:"key"`
+	Path      string  `json:"path"`
+	Command   string  `json:"command"`
+	ExitCode  int     `json:"exit_code"`
+	Duration  float64 `json:"duration_seconds"`
+	TimedOut  bool    `json:"timed_out,omitempty"`
+	Cancelled bool    `json:"cancelled,omitempty"`
+	Stdout    string  `json:"stdout,omitempty"`
+	Stderr    string  `json:"stderr,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func (r *runner) addResult(result runpResult) {
+	r.resultsLock.Lock()
+	defer r.resultsLock.Unlock()
+	r.results = append(r.results, result)
+}
+
+type runpResultsByKey []runpResult
+
+func (r runpResultsByKey) Len() int           { return len(r) }
+func (r runpResultsByKey) Less(i, j int) bool { return r[i].Key < r[j].Key }
+func (r runpResultsByKey) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// createLogFiles creates the files keeping the stdout and stderr of the
+// command run in project p.
+func createLogFiles(jirix *jiri.X, p project.Project) (*os.File, *os.File, error) {
+	relPath, err := filepath.Rel(jirix.Root, p.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	base := filepath.Join(runpFlags.logDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return nil, nil, err
+	}
+	stdout, err := os.Create(base + ".stdout")
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr, err := os.Create(base + ".stderr")
+	if err != nil {
+		stdout.Close()
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
 }
 
 func (r *runner) serializedWriter(w io.Writer) io.Writer {
@@ -168,18 +260,31 @@ type mapOutput struct {
 }
 
 func (r *runner) Map(mr *simplemr.MR, key string, val interface{}) error {
+	r.mapping.Add(1)
+	defer r.mapping.Done()
+	if mr.IsCancelled() {
+		return nil
+	}
 	mi := val.(*mapInput)
 	output := &mapOutput{
 		key: key,
 		mi:  mi}
 	jirix := mi.jirix
+	result := runpResult{
+		Name:     mi.Project.Name,
+		Key:      key,
+		Path:     mi.Project.Path,
+		Command:  mi.command,
+		ExitCode: -1,
+	}
+	start := time.Now()
 	path := os.Getenv("SHELL")
 	if path == "" {
 		path = "sh"
 	}
 	var wg sync.WaitGroup
-	cmd := exec.Command(path, "-c", strings.Join(r.args, " "))
-	cmd.Env = envvar.MapToSlice(jirix.Env())
+	cmd := exec.Command(path, "-c", mi.command)
+	cmd.Env = envvar.MapToSlice(envvar.MergeMaps(jirix.Env(), projectEnv(jirix, mi.Project, mi.key)))
 	cmd.Dir = mi.Project.Path
 	cmd.Stdin = mi.jirix.Stdin()
 	var stdoutCloser, stderrCloser io.Closer
@@ -244,6 +349,28 @@ func (r *runner) Map(mr *simplemr.MR, key string, val interface{}) error {
 
 		}
 	}
+	if runpFlags.logDir != "" {
+		stdoutLog, stderrLog, err := createLogFiles(jirix, mi.Project)
+		if err != nil {
+			return err
+		}
+		defer stdoutLog.Close()
+		defer stderrLog.Close()
+		cmd.Stdout = io.MultiWriter(stdoutLog, cmd.Stdout)
+		cmd.Stderr = io.MultiWriter(stderrLog, cmd.Stderr)
+		result.Stdout, result.Stderr = stdoutLog.Name(), stderrLog.Name()
+	}
+	var timeout <-chan time.Time
+	if runpFlags.timeout > 0 {
+		timer := time.NewTimer(runpFlags.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+		if !runpFlags.interactive {
+			// Run the command in its own process group so that the
+			// processes it started are killed along with it.
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		}
+	}
 	if err := cmd.Start(); err != nil {
 		mi.result = err
 	}
@@ -257,8 +384,27 @@ func (r *runner) Map(mr *simplemr.MR, key string, val interface{}) error {
 			mr.Cancel()
 		}
 	case <-mr.CancelCh():
-		output.err = cmd.Process.Kill()
+		killCommand(cmd)
+		<-done
+		result.Cancelled = true
+		output.err = fmt.Errorf("cancelled")
+	case <-timeout:
+		killCommand(cmd)
+		<-done
+		result.TimedOut = true
+		output.err = fmt.Errorf("timed out after %v", runpFlags.timeout)
+		if runpFlags.exitOnError {
+			mr.Cancel()
+		}
+	}
+	result.Duration = time.Since(start).Seconds()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if output.err != nil {
+		result.Error = output.err.Error()
 	}
+	r.addResult(result)
 	for _, closer := range []io.Closer{stdoutCloser, stderrCloser} {
 		if closer != nil {
 			closer.Close()
@@ -269,12 +415,27 @@ func (r *runner) Map(mr *simplemr.MR, key string, val interface{}) error {
 	return nil
 }
 
+// killCommand kills cmd, along with its process group if it has its own.
+func killCommand(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd.Process.Kill()
+}
+
 func (r *runner) Reduce(mr *simplemr.MR, key string, values []interface{}) error {
 	for _, v := range values {
 		mo := v.(*mapOutput)
 		if mo.err != nil {
-			fmt.Fprintf(os.Stdout, "FAILED: %v: %s %v\n", mo.key, strings.Join(r.args, " "), mo.err)
-			return mo.err
+			fmt.Fprintf(os.Stdout, "FAILED: %v: %s %v\n", mo.key, mo.mi.command, mo.err)
+			if runpFlags.collateOutput {
+				os.Remove(mo.outputFilename)
+			}
+			// Failures are summarized once all projects are done.
+			continue
 		} else {
 			if runpFlags.collateOutput {
 				r.collatedOutputLock.Lock()
@@ -292,42 +453,85 @@ func (r *runner) Reduce(mr *simplemr.MR, key string, values []interface{}) error
 	return nil
 }
 
-func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
-	if runpFlags.interactive {
-		runpFlags.collateOutput = false
+// projectEnv returns the environment variables describing project p to the
+// commands run by runp.
+func projectEnv(jirix *jiri.X, p project.Project, key project.ProjectKey) map[string]string {
+	return map[string]string{
+		"JIRI_ROOT":                  jirix.Root,
+		"JIRI_PROJECT_NAME":          p.Name,
+		"JIRI_PROJECT_KEY":           string(key),
+		"JIRI_PROJECT_PATH":          p.Path,
+		"JIRI_PROJECT_REMOTE":        p.Remote,
+		"JIRI_PROJECT_REMOTE_BRANCH": p.RemoteBranch,
+		"JIRI_PROJECT_REVISION":      p.Revision,
+	}
+}
+
+// expandCommand returns the command line to run in each of projects, with
+// the template placeholders in command expanded.
+func expandCommand(jirix *jiri.X, command string, projects project.Projects) (map[project.ProjectKey]string, error) {
+	commands := map[project.ProjectKey]string{}
+	if !strings.Contains(command, "{{") {
+		for key := range projects {
+			commands[key] = command
+		}
+		return commands, nil
 	}
+	tmpl, err := template.New("runp").Parse(command)
+	if err != nil {
+		return nil, jirix.UsageErrorf("failed to parse command template %q: %v", command, err)
+	}
+	states, err := project.GetProjectStates(jirix, projects, false)
+	if err != nil {
+		return nil, err
+	}
+	for key, state := range states {
+		info := infoOutput{
+			Name:          state.Project.Name,
+			Path:          state.Project.Path,
+			Remote:        state.Project.Remote,
+			Revision:      state.Project.Revision,
+			CurrentBranch: state.CurrentBranch.Name,
+		}
+		for _, b := range state.Branches {
+			info.Branches = append(info.Branches, b.Name)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, info); err != nil {
+			return nil, fmt.Errorf("failed to expand command template %q for project %q: %v", command, state.Project.Name, err)
+		}
+		commands[key] = out.String()
+	}
+	return commands, nil
+}
 
+// selectProjects returns the local projects matched by the project
+// selection flags in values.
+func selectProjects(jirix *jiri.X, values *runpFlagValues) (project.Projects, error) {
 	var keysRE, branchRE *regexp.Regexp
+	var selector *project.Selector
 	var err error
 
-	if runpFlags.projectKeys != "" {
-		re := ""
-		for _, pre := range strings.Split(runpFlags.projectKeys, ",") {
-			re += pre + "|"
-		}
-		re = strings.TrimRight(re, "|")
-		keysRE, err = regexp.Compile(re)
-		if err != nil {
-			return fmt.Errorf("failed to compile projects regexp: %q: %v", runpFlags.projectKeys, err)
+	if values.projectKeys != "" {
+		if keysRE, err = projectKeysRegexp(strings.Split(values.projectKeys, ",")); err != nil {
+			return nil, err
 		}
 	}
 
-	if runpFlags.branch != "" {
-		branchRE, err = regexp.Compile(runpFlags.branch)
-		if err != nil {
-			return fmt.Errorf("failed to compile has-branch regexp: %q: %v", runpFlags.branch, err)
-		}
+	if selector, err = parseSelector(jirix, values.selector); err != nil {
+		return nil, err
 	}
 
-	if (runpFlags.showKeyPrefix || runpFlags.showNamePrefix) && runpFlags.interactive {
-		fmt.Fprintf(jirix.Stderr(), "WARNING: interactive mode being disabled because show-key-prefix or show-name-prefix was set\n")
-		runpFlags.interactive = false
-		runpFlags.collateOutput = true
+	if values.branch != "" {
+		branchRE, err = regexp.Compile(values.branch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile has-branch regexp: %q: %v", values.branch, err)
+		}
 	}
 
 	dir, err := os.Getwd()
 	if err != nil {
-		return fmt.Errorf("os.Getwd() failed: %v", err)
+		return nil, fmt.Errorf("os.Getwd() failed: %v", err)
 	}
 	if dir == jirix.Root || err != nil {
 		// jiri was run from outside of a project. Let's assume we'll
@@ -338,22 +542,22 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 	}
 	projects, err := project.LocalProjects(jirix, project.FastScan)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	projectStateRequired := branchRE != nil || runpFlags.untracked || runpFlags.noUntracked || runpFlags.uncommitted || runpFlags.noUncommitted
+	checkDirty := values.untracked || values.noUntracked || values.uncommitted || values.noUncommitted || selector.CheckDirty()
+	projectStateRequired := branchRE != nil || checkDirty || selector.NeedsState()
 	var states map[project.ProjectKey]*project.ProjectState
 	if projectStateRequired {
 		jirix.TimerPush("project states")
 		var err error
-		states, err = project.GetProjectStates(jirix, projects, runpFlags.untracked || runpFlags.noUntracked || runpFlags.uncommitted || runpFlags.noUncommitted)
+		states, err = project.GetProjectStates(jirix, projects, checkDirty)
 		jirix.TimerPop()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	mapInputs := map[project.ProjectKey]*mapInput{}
-	var keys project.ProjectKeys
+	selected := project.Projects{}
 	for _, localProject := range projects {
 		key := localProject.Key()
 		if keysRE != nil {
@@ -362,6 +566,9 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 			}
 		}
 		state := states[key]
+		if !selector.Match(localProject, state) {
+			continue
+		}
 		if branchRE != nil {
 			found := false
 			for _, br := range state.Branches {
@@ -374,16 +581,48 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 				continue
 			}
 		}
-		if (runpFlags.untracked && !state.HasUntracked) || (runpFlags.noUntracked && state.HasUntracked) {
+		if (values.untracked && !state.HasUntracked) || (values.noUntracked && state.HasUntracked) {
 			continue
 		}
-		if (runpFlags.uncommitted && !state.HasUncommitted) || (runpFlags.noUncommitted && state.HasUncommitted) {
+		if (values.uncommitted && !state.HasUncommitted) || (values.noUncommitted && state.HasUncommitted) {
 			continue
 		}
+		selected[key] = localProject
+	}
+	return selected, nil
+}
+
+func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
+	if runpFlags.interactive {
+		runpFlags.collateOutput = false
+	}
+
+	if runpFlags.logDir != "" && runpFlags.interactive {
+		return jirix.UsageErrorf("-log-dir cannot be used with -interactive")
+	}
+
+	if (runpFlags.showKeyPrefix || runpFlags.showNamePrefix) && runpFlags.interactive {
+		fmt.Fprintf(jirix.Stderr(), "WARNING: interactive mode being disabled because show-key-prefix or show-name-prefix was set\n")
+		runpFlags.interactive = false
+		runpFlags.collateOutput = true
+	}
+
+	projects, err := selectProjects(jirix, &runpFlags)
+	if err != nil {
+		return err
+	}
+	commands, err := expandCommand(jirix, strings.Join(args, " "), projects)
+	if err != nil {
+		return err
+	}
+	mapInputs := map[project.ProjectKey]*mapInput{}
+	var keys project.ProjectKeys
+	for key, localProject := range projects {
 		mapInputs[key] = &mapInput{
 			Project: localProject,
 			jirix:   jirix,
 			key:     key,
+			command: commands[key],
 		}
 		keys = append(keys, key)
 	}
@@ -401,9 +640,7 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 		fmt.Fprintf(os.Stdout, "Project Keys: %s\n", strings.Join(projectKeys(mapInputs), " "))
 	}
 
-	runner := &runner{
-		args: args,
-	}
+	runner := &runner{}
 	mr := simplemr.MR{}
 	if runpFlags.interactive {
 		// Run one mapper at a time.
@@ -423,8 +660,58 @@ func runp(jirix *jiri.X, cmd *cmdline.Command, args []string) error {
 	}
 	close(in)
 	<-out
+	runner.mapping.Wait()
 	jirix.TimerPop()
-	return mr.Error()
+	sort.Sort(runpResultsByKey(runner.results))
+	if runpFlags.jsonOutput != "" {
+		if err := writeRunpResults(runpFlags.jsonOutput, runner.results); err != nil {
+			return err
+		}
+	}
+	// The commands cancelled are reported as failed.
+	if err := mr.Error(); err != nil && err != simplemr.ErrMRCancelled {
+		return err
+	}
+	return summarizeRunpResults(runner.results)
+}
+
+func writeRunpResults(path string, results []runpResult) error {
+	if results == nil {
+		results = []runpResult{}
+	}
+	out, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize JSON output: %s", err)
+	}
+	if err := ioutil.WriteFile(path, out, 0600); err != nil {
+		return fmt.Errorf("failed write JSON output to %s: %s", path, err)
+	}
+	return nil
+}
+
+// summarizeRunpResults lists the projects where the command failed, and
+// returns an error if there are any.
+func summarizeRunpResults(results []runpResult) error {
+	var failed []runpResult
+	for _, result := range results {
+		if result.Error != "" {
+			failed = append(failed, result)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "Command failed in %d of %d project(s):\n", len(failed), len(results))
+	for _, result := range failed {
+		reason := fmt.Sprintf("exit code %d", result.ExitCode)
+		if result.TimedOut {
+			reason = "timed out"
+		} else if result.Cancelled {
+			reason = "cancelled"
+		}
+		fmt.Fprintf(os.Stdout, "  %s (%s)\n", result.Name, reason)
+	}
+	return fmt.Errorf("command failed in %d project(s)", len(failed))
 }
 
 func runRunp(jirix *jiri.X, args []string) error {
@@ -5,11 +5,14 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"fuchsia.googlesource.com/jiri/gitutil"
 	"fuchsia.googlesource.com/jiri/jiritest"
@@ -18,6 +21,7 @@ import (
 
 func setDefaultRunpFlags() {
 	runpFlags.projectKeys = ""
+	runpFlags.selector = ""
 	runpFlags.verbose = false
 	runpFlags.interactive = false
 	runpFlags.uncommitted = false
@@ -29,6 +33,9 @@ func setDefaultRunpFlags() {
 	runpFlags.exitOnError = false
 	runpFlags.collateOutput = true
 	runpFlags.branch = ""
+	runpFlags.timeout = 0
+	runpFlags.jsonOutput = ""
+	runpFlags.logDir = ""
 }
 
 func addProjects(t *testing.T, fake *jiritest.FakeJiriRoot) []*project.Project {
@@ -252,3 +259,180 @@ func TestRunP(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRunPEnvAndTemplate(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	projects := addProjects(t, fake)
+
+	setDefaultRunpFlags()
+	runpFlags.projectKeys = string(projects[0].Key()) + "," + string(projects[3].Key())
+	got := executeRunp(t, fake, "echo", "$JIRI_ROOT", "$JIRI_PROJECT_NAME", "$JIRI_PROJECT_PATH", "$JIRI_PROJECT_REMOTE_BRANCH")
+	var want []string
+	for _, p := range []*project.Project{projects[0], projects[3]} {
+		want = append(want, strings.Join([]string{fake.X.Root, p.Name, p.Path, "master"}, " "))
+	}
+	if got != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, strings.Join(want, "\n"))
+	}
+
+	got = executeRunp(t, fake, "echo", "{{.Name}}", "{{.Remote}}", "$JIRI_PROJECT_REMOTE")
+	want = nil
+	for _, p := range []*project.Project{projects[0], projects[3]} {
+		want = append(want, strings.Join([]string{p.Name, p.Remote, p.Remote}, " "))
+	}
+	if got != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, strings.Join(want, "\n"))
+	}
+
+	got = executeRunp(t, fake, "echo", "{{.Name")
+	if !strings.Contains(got, "failed to parse command template") {
+		t.Errorf("expected a template error, got %q", got)
+	}
+}
+
+func TestRunPSelector(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	projects := addProjects(t, fake)
+	defer setDefaultRunpFlags()
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(fake.X.Root); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(projects[1].Path, "untracked"), []byte("untracked"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{"r.a or r.c", "r.a\nr.c"},
+		{"under:sub", "sub/r.t1\nsub/sub2/r.t2"},
+		{"under:sub and not path:sub/*/*", "sub/r.t1"},
+		{"untracked", "r.b"},
+		{"name:r.* !untracked", "r.a\nr.c"},
+	}
+	for _, test := range tests {
+		setDefaultRunpFlags()
+		runpFlags.selector = test.selector
+		if got := executeRunp(t, fake, "echo", "{{.Name}}"); got != test.want {
+			t.Errorf("selector %q: got %q, want %q", test.selector, got, test.want)
+		}
+	}
+
+	setDefaultRunpFlags()
+	runpFlags.selector = "(r.a"
+	if got := executeRunp(t, fake, "echo"); !strings.Contains(got, "invalid project selector") {
+		t.Errorf("expected a selector error, got %q", got)
+	}
+}
+
+func TestRunPResults(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	projects := addProjects(t, fake)
+	defer setDefaultRunpFlags()
+
+	setDefaultRunpFlags()
+	runpFlags.projectKeys = string(projects[0].Key()) + "," + string(projects[3].Key())
+	runpFlags.logDir = filepath.Join(fake.X.Root, ".logs")
+	runpFlags.jsonOutput = filepath.Join(fake.X.Root, ".results.json")
+	got := executeRunp(t, fake, `if [ "$JIRI_PROJECT_NAME" = sub/r.t1 ]; then echo bad >&2; exit 3; fi; echo ok`)
+	if !strings.Contains(got, "Command failed in 1 of 2 project(s):\n  sub/r.t1 (exit code 3)") {
+		t.Errorf("missing failure summary in %q", got)
+	}
+
+	data, err := ioutil.ReadFile(runpFlags.jsonOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []runpResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", results)
+	}
+	byName := map[string]runpResult{}
+	for _, r := range results {
+		byName[r.Name] = r
+	}
+	for _, c := range []struct {
+		name           string
+		exitCode       int
+		stdout, stderr string
+	}{
+		{"r.a", 0, "ok\n", ""},
+		{"sub/r.t1", 3, "", "bad\n"},
+	} {
+		r := byName[c.name]
+		if r.ExitCode != c.exitCode || r.TimedOut {
+			t.Errorf("unexpected result for %s: %+v", c.name, r)
+		}
+		if want := filepath.Join(runpFlags.logDir, c.name+".stdout"); r.Stdout != want {
+			t.Errorf("got stdout log %q, want %q", r.Stdout, want)
+		}
+		for file, want := range map[string]string{r.Stdout: c.stdout, r.Stderr: c.stderr} {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != want {
+				t.Errorf("got %q in %s, want %q", data, file, want)
+			}
+		}
+	}
+
+	setDefaultRunpFlags()
+	runpFlags.projectKeys = string(projects[0].Key())
+	runpFlags.timeout = 100 * time.Millisecond
+	runpFlags.jsonOutput = filepath.Join(fake.X.Root, ".results.json")
+	got = executeRunp(t, fake, "sleep 10")
+	if !strings.Contains(got, "r.a (timed out)") {
+		t.Errorf("missing timeout in %q", got)
+	}
+	data, err = ioutil.ReadFile(runpFlags.jsonOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = nil
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].TimedOut || results[0].Duration >= 10 {
+		t.Errorf("expected a timed out result, got %+v", results)
+	}
+
+	// With -exit-on-error, the commands still running are cancelled and
+	// reported as failed.
+	setDefaultRunpFlags()
+	runpFlags.projectKeys = string(projects[0].Key()) + "," + string(projects[3].Key())
+	runpFlags.exitOnError = true
+	runpFlags.jsonOutput = filepath.Join(fake.X.Root, ".results.json")
+	got = executeRunp(t, fake, `if [ "$JIRI_PROJECT_NAME" = r.a ]; then sleep 1; exit 1; fi; sleep 10`)
+	if !strings.Contains(got, "Command failed in 2 of 2 project(s):") || !strings.Contains(got, "sub/r.t1 (cancelled)") {
+		t.Errorf("missing cancelled project in %q", got)
+	}
+	data, err = ioutil.ReadFile(runpFlags.jsonOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = nil
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	byName = map[string]runpResult{}
+	for _, r := range results {
+		byName[r.Name] = r
+	}
+	if r := byName["sub/r.t1"]; !r.Cancelled || r.Error == "" || r.Duration >= 10 {
+		t.Errorf("expected a cancelled result, got %+v", results)
+	}
+}
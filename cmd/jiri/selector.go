@@ -0,0 +1,80 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+// selectorFlagHelp is the usage of the -select flag of the commands which
+// accept a project selector.
+const selectorFlagHelp = "Only use the projects matching this project selector. See 'jiri help selector' for its syntax."
+
+// parseSelector parses the project selector expression expr, returning a nil
+// selector, which selects all projects, if expr is empty.
+func parseSelector(jirix *jiri.X, expr string) (*project.Selector, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return project.ParseSelector(jirix, expr)
+}
+
+// selectStates returns the states of the projects matching the project
+// selector expression expr.  The states are computed with checkDirty if
+// either checkDirty is true or the selector needs it.
+func selectStates(jirix *jiri.X, projects project.Projects, expr string, checkDirty bool) (map[project.ProjectKey]*project.ProjectState, error) {
+	selector, err := parseSelector(jirix, expr)
+	if err != nil {
+		return nil, err
+	}
+	states, err := project.GetProjectStates(jirix, projects, checkDirty || selector.CheckDirty())
+	if err != nil {
+		return nil, err
+	}
+	for key, state := range states {
+		if !selector.Match(state.Project, state) {
+			delete(states, key)
+		}
+	}
+	return states, nil
+}
+
+// projectKeysRegexp returns a regexp matching the project keys which match
+// any of the regular expressions keys, or nil if keys is empty.
+func projectKeysRegexp(keys []string) (*regexp.Regexp, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	re, err := regexp.Compile(strings.Join(keys, "|"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile projects regexp: %q: %v", strings.Join(keys, ","), err)
+	}
+	return re, nil
+}
+
+// selectProjectsByKey returns the local projects whose key matches any of the
+// regular expressions keys, or all local projects if keys is empty.
+func selectProjectsByKey(jirix *jiri.X, keys []string) (project.Projects, error) {
+	keysRE, err := projectKeysRegexp(keys)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil || keysRE == nil {
+		return projects, err
+	}
+	selected := project.Projects{}
+	for key, p := range projects {
+		if keysRE.MatchString(string(key)) {
+			selected[key] = p
+		}
+	}
+	return selected, nil
+}
@@ -21,7 +21,10 @@ var statusFlags struct {
 	changes   bool
 	checkHead bool
 	branch    string
+	selector  string
 	commits   bool
+	json      bool
+	porcelain bool
 }
 
 var cmdStatus = &cmdline.Command{
@@ -32,7 +35,10 @@ var cmdStatus = &cmdline.Command{
 Prints status for the the projects. It runs git status -s across all the projects
 and prints it if there are some changes. It also shows status if the project is on
 a rev other then the one according to manifest(Named as JIRI_HEAD in git)
-`,
+
+With -json or -porcelain, the status of every project is printed in a
+machine-readable format, ignoring -changes, -check-head and -commits.
+` + porcelainHelp,
 }
 
 func init() {
@@ -41,6 +47,9 @@ func init() {
 	flags.BoolVar(&statusFlags.checkHead, "check-head", true, "Display projects that are not on HEAD/pinned revisions.")
 	flags.BoolVar(&statusFlags.commits, "commits", true, "Display commits not merged with remote. This only works when project is on a local branch.")
 	flags.StringVar(&statusFlags.branch, "branch", "", "Display all projects only on this branch along with thier status.")
+	flags.StringVar(&statusFlags.selector, "select", "", selectorFlagHelp)
+	flags.BoolVar(&statusFlags.json, "json", false, "Print the status of all projects as JSON.")
+	flags.BoolVar(&statusFlags.porcelain, "porcelain", false, "Print the status of all projects in a stable, easy to parse format.")
 }
 
 func colorFormatGitLog(jirix *jiri.X, log string) string {
@@ -56,6 +65,16 @@ func colorFormatGitiStatusLog(jirix *jiri.X, log string) string {
 }
 
 func runStatus(jirix *jiri.X, args []string) error {
+	if statusFlags.json && statusFlags.porcelain {
+		return jirix.UsageErrorf("-json and -porcelain cannot be used together")
+	}
+	selector, err := parseSelector(jirix, statusFlags.selector)
+	if err != nil {
+		return err
+	}
+	if statusFlags.json || statusFlags.porcelain {
+		return runStatusMachineReadable(jirix, selector)
+	}
 	localProjects, err := project.LocalProjects(jirix, project.FastScan)
 	if err != nil {
 		return err
@@ -68,7 +87,7 @@ func runStatus(jirix *jiri.X, args []string) error {
 	if err != nil {
 		return err
 	}
-	states, err := project.GetProjectStates(jirix, localProjects, false)
+	states, err := project.GetProjectStates(jirix, localProjects, selector.CheckDirty())
 	if err != nil {
 		return err
 	}
@@ -82,6 +101,9 @@ func runStatus(jirix *jiri.X, args []string) error {
 		if statusFlags.branch != "" && (statusFlags.branch != state.CurrentBranch.Name) {
 			continue
 		}
+		if !selector.Match(localProject, state) {
+			continue
+		}
 		changes, headRev, extraCommits, err := getStatus(jirix, localProject, remoteProject, state.CurrentBranch)
 		if err != nil {
 			return fmt.Errorf("Error while getting status for project %q :%s", localProject.Name, err)
@@ -182,3 +204,20 @@ func getStatus(jirix *jiri.X, local project.Project, remote project.Project, cur
 	}
 	return changes, headRev, extraCommits, nil
 }
+
+func runStatusMachineReadable(jirix *jiri.X, selector *project.Selector) error {
+	statuses, err := projectStatuses(jirix, false, func(state *project.ProjectState) bool {
+		if statusFlags.branch != "" && statusFlags.branch != state.CurrentBranch.Name {
+			return false
+		}
+		return selector.Match(state.Project, state)
+	})
+	if err != nil {
+		return err
+	}
+	if statusFlags.json {
+		return writeStatusJSON(jirix.Stdout(), statuses)
+	}
+	writeStatusPorcelain(jirix.Stdout(), statuses, false)
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/git"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+// porcelainHelp documents the -porcelain output of "jiri status" and
+// "jiri branch".
+const porcelainHelp = `
+With -porcelain, each project is printed as a block of "<key> <value>" lines
+followed by an empty line.  The keys are, in this order:
+  project <name>
+  path <absolute path>
+  branch.head <current branch, or (detached)>
+  branch.upstream <tracking branch>, omitted if there is none
+  branch.ab +<ahead> -<behind>, omitted if there is no tracking branch
+  head <revision of HEAD>
+  manifest <revision in the manifest>, omitted if not in the manifest
+  dirty <true or false>
+  untracked <true or false>
+  stash <number of stash entries>
+  operation <rebase, am, merge, cherry-pick, revert or bisect>, omitted if no
+    git operation is in progress
+  conflict <path>, once per file with unresolved conflicts
+  unmerged <revision>, once per commit not merged upstream
+  local-branch <name> <revision> <tracking branch or -> +<ahead> -<behind>,
+    once per local branch, only printed by "jiri branch"
+`
+
+// projectStatus is the machine-readable status of a project.
+type projectStatus struct {
+	Name             string         `json:"name"`
+	Path             string         `json:"path"`
+	CurrentBranch    string         `json:"current_branch"`
+	Tracking         string         `json:"tracking,omitempty"`
+	Ahead            int            `json:"ahead"`
+	Behind           int            `json:"behind"`
+	Dirty            bool           `json:"dirty"`
+	Untracked        bool           `json:"untracked"`
+	StashCount       int            `json:"stash_count"`
+	Operation        string         `json:"operation,omitempty"`
+	Conflicts        []string       `json:"conflicts,omitempty"`
+	Head             string         `json:"head"`
+	ManifestRevision string         `json:"manifest_revision,omitempty"`
+	UnmergedCommits  []string       `json:"unmerged_commits"`
+	Branches         []branchStatus `json:"branches,omitempty"`
+}
+
+// branchStatus is the machine-readable status of a local branch.
+type branchStatus struct {
+	Name     string `json:"name"`
+	Revision string `json:"revision"`
+	Tracking string `json:"tracking,omitempty"`
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+}
+
+type projectStatusesByPath []projectStatus
+
+func (p projectStatusesByPath) Len() int           { return len(p) }
+func (p projectStatusesByPath) Less(i, j int) bool { return p[i].Path < p[j].Path }
+func (p projectStatusesByPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// projectStatuses returns the status of the local projects whose state is
+// accepted by filter, sorted by path.  If withBranches is true, the status
+// of each local branch is included.
+func projectStatuses(jirix *jiri.X, withBranches bool, filter func(*project.ProjectState) bool) ([]projectStatus, error) {
+	localProjects, err := project.LocalProjects(jirix, project.FastScan)
+	if err != nil {
+		return nil, err
+	}
+	remoteProjects, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, false /*localManifest*/)
+	if err != nil {
+		return nil, err
+	}
+	states, err := project.GetProjectStates(jirix, localProjects, true)
+	if err != nil {
+		return nil, err
+	}
+	statuses := []projectStatus{}
+	for key, state := range states {
+		if filter != nil && !filter(state) {
+			continue
+		}
+		status, err := getProjectStatus(jirix, state, remoteProjects[key], withBranches)
+		if err != nil {
+			return nil, fmt.Errorf("Error while getting status for project %q :%s", state.Project.Name, err)
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Sort(projectStatusesByPath(statuses))
+	return statuses, nil
+}
+
+func getProjectStatus(jirix *jiri.X, state *project.ProjectState, remote project.Project, withBranches bool) (projectStatus, error) {
+	local := state.Project
+	scm := gitutil.New(jirix, gitutil.RootDirOpt(local.Path))
+	status := projectStatus{
+		Name:            local.Name,
+		Path:            local.Path,
+		CurrentBranch:   state.CurrentBranch.Name,
+		Dirty:           state.HasUncommitted,
+		Untracked:       state.HasUntracked,
+		StashCount:      state.StashCount,
+		Operation:       state.Operation,
+		Conflicts:       state.Conflicts,
+		Head:            state.CurrentBranch.Revision,
+		UnmergedCommits: []string{},
+	}
+	if remote.Name != "" {
+		headRev, err := project.GetHeadRevision(jirix, remote)
+		if err != nil {
+			return status, err
+		}
+		if status.ManifestRevision, err = git.NewGit(local.Path).CurrentRevisionForRef(headRev); err != nil {
+			return status, fmt.Errorf("Cannot find revision for ref %q: %s", headRev, err)
+		}
+	}
+	if state.CurrentBranch.Name != "" {
+		upstream := ""
+		if state.CurrentBranch.Tracking != nil {
+			status.Tracking = state.CurrentBranch.Tracking.Name
+			status.Ahead = state.CurrentBranch.Ahead
+			status.Behind = state.CurrentBranch.Behind
+			upstream = status.Tracking
+		} else if remote.Name != "" {
+			upstream = "remotes/origin/" + remote.RemoteBranch
+		}
+		if upstream != "" {
+			commits, err := scm.ExtraCommits(state.CurrentBranch.Name, upstream)
+			if err != nil {
+				return status, err
+			}
+			status.UnmergedCommits = append(status.UnmergedCommits, commits...)
+		}
+	}
+	if withBranches {
+		for _, b := range state.Branches {
+			bs := branchStatus{
+				Name:     b.Name,
+				Revision: b.Revision,
+				Ahead:    b.Ahead,
+				Behind:   b.Behind,
+			}
+			if b.Tracking != nil {
+				bs.Tracking = b.Tracking.Name
+			}
+			status.Branches = append(status.Branches, bs)
+		}
+	}
+	return status, nil
+}
+
+// writeStatusJSON writes statuses to w as a JSON list.
+func writeStatusJSON(w io.Writer, statuses []projectStatus) error {
+	out, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize JSON output: %s", err)
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// writeStatusPorcelain writes statuses to w in the format described by
+// porcelainHelp.
+func writeStatusPorcelain(w io.Writer, statuses []projectStatus, withBranches bool) {
+	for _, s := range statuses {
+		fmt.Fprintf(w, "project %s\n", s.Name)
+		fmt.Fprintf(w, "path %s\n", s.Path)
+		head := s.CurrentBranch
+		if head == "" {
+			head = "(detached)"
+		}
+		fmt.Fprintf(w, "branch.head %s\n", head)
+		if s.Tracking != "" {
+			fmt.Fprintf(w, "branch.upstream %s\n", s.Tracking)
+			fmt.Fprintf(w, "branch.ab +%d -%d\n", s.Ahead, s.Behind)
+		}
+		fmt.Fprintf(w, "head %s\n", s.Head)
+		if s.ManifestRevision != "" {
+			fmt.Fprintf(w, "manifest %s\n", s.ManifestRevision)
+		}
+		fmt.Fprintf(w, "dirty %t\n", s.Dirty)
+		fmt.Fprintf(w, "untracked %t\n", s.Untracked)
+		fmt.Fprintf(w, "stash %d\n", s.StashCount)
+		if s.Operation != "" {
+			fmt.Fprintf(w, "operation %s\n", s.Operation)
+		}
+		for _, path := range s.Conflicts {
+			fmt.Fprintf(w, "conflict %s\n", path)
+		}
+		for _, commit := range s.UnmergedCommits {
+			fmt.Fprintf(w, "unmerged %s\n", commit)
+		}
+		if withBranches {
+			for _, b := range s.Branches {
+				tracking := b.Tracking
+				if tracking == "" {
+					tracking = "-"
+				}
+				fmt.Fprintf(w, "local-branch %s %s %s +%d -%d\n", b.Name, b.Revision, tracking, b.Ahead, b.Behind)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -5,6 +5,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -26,6 +28,8 @@ func setDefaultStatusFlags() {
 	statusFlags.checkHead = true
 	statusFlags.branch = ""
 	statusFlags.commits = true
+	statusFlags.json = false
+	statusFlags.porcelain = false
 }
 
 func createCommits(t *testing.T, fake *jiritest.FakeJiriRoot, localProjects []project.Project) ([]string, []string, []string, []string) {
@@ -370,3 +374,97 @@ func setDummyUser(t *testing.T, jirix *jiri.X, projectDir string) {
 		t.Fatal(err)
 	}
 }
+
+func TestStatusMachineReadable(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	projects := makeProjects(t, fake)
+
+	// r.a has a new branch with an unmerged commit, r.b has untracked files
+	// and r.c has uncommitted changes.
+	if err := project.StartBranch(fake.X, *projects[0], "feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, projects[0].Path, "file", "feature")
+	if err := ioutil.WriteFile(filepath.Join(projects[1].Path, "untracked"), []byte("untracked"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fake.X, projects[2].Path, "file", "committed")
+	if err := ioutil.WriteFile(filepath.Join(projects[2].Path, "file"), []byte("uncommitted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := projectStatuses(fake.X, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The manifest project is also listed.
+	if got, want := len(statuses), len(projects)+1; got != want {
+		t.Fatalf("expected %d statuses, got %d", want, got)
+	}
+	byName := map[string]projectStatus{}
+	for _, s := range statuses {
+		byName[s.Name] = s
+	}
+
+	a := byName[projects[0].Name]
+	head, err := git.NewGit(projects[0].Path).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.CurrentBranch != "feature" || a.Tracking != "origin/master" || a.Ahead != 1 || a.Behind != 0 {
+		t.Errorf("unexpected branch status for %s: %+v", a.Name, a)
+	}
+	if a.Head != head || a.ManifestRevision == "" || a.ManifestRevision == a.Head {
+		t.Errorf("unexpected revisions for %s: %+v", a.Name, a)
+	}
+	if len(a.UnmergedCommits) != 1 || a.UnmergedCommits[0] != head {
+		t.Errorf("expected unmerged commit %s for %s, got %v", head, a.Name, a.UnmergedCommits)
+	}
+	if a.Dirty || a.Untracked {
+		t.Errorf("expected %s to be clean: %+v", a.Name, a)
+	}
+	// The master branch is created by the initial update.
+	if len(a.Branches) != 2 || a.Branches[0].Name != "feature" || a.Branches[0].Ahead != 1 || a.Branches[1].Name != "master" {
+		t.Errorf("unexpected branches for %s: %+v", a.Name, a.Branches)
+	}
+	if b := byName[projects[1].Name]; b.Dirty || !b.Untracked || b.CurrentBranch != "" || b.Head != b.ManifestRevision {
+		t.Errorf("unexpected status for %s: %+v", b.Name, b)
+	}
+	if c := byName[projects[2].Name]; !c.Dirty || c.Untracked {
+		t.Errorf("unexpected status for %s: %+v", c.Name, c)
+	}
+
+	var buf bytes.Buffer
+	if err := writeStatusJSON(&buf, statuses); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []projectStatus
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(statuses) || decoded[0].Name != statuses[0].Name {
+		t.Errorf("unexpected JSON output: %s", buf.String())
+	}
+
+	buf.Reset()
+	writeStatusPorcelain(&buf, []projectStatus{a}, true)
+	want := fmt.Sprintf(`project %s
+path %s
+branch.head feature
+branch.upstream origin/master
+branch.ab +1 -0
+head %s
+manifest %s
+dirty false
+untracked false
+stash 0
+unmerged %s
+local-branch feature %s origin/master +1 -0
+local-branch master %s origin/master +0 -0
+
+`, a.Name, a.Path, head, a.ManifestRevision, head, head, a.ManifestRevision)
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected porcelain output, got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -6,6 +6,8 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"fuchsia.googlesource.com/jiri"
 	"fuchsia.googlesource.com/jiri/cmdline"
@@ -23,6 +25,8 @@ var (
 	rebaseUntrackedFlag bool
 	hookTimeoutFlag     uint
 	rebaseAllFlag       bool
+	resumeFlag          bool
+	autostashFlag       bool
 )
 
 func init() {
@@ -30,12 +34,15 @@ func init() {
 
 	cmdUpdate.Flags.BoolVar(&gcFlag, "gc", false, "Garbage collect obsolete repositories.")
 	cmdUpdate.Flags.BoolVar(&localManifestFlag, "local-manifest", false, "Use local manifest")
-	cmdUpdate.Flags.IntVar(&attemptsFlag, "attempts", 1, "Number of attempts before failing.")
+	cmdUpdate.Flags.IntVar(&attemptsFlag, "attempts", 1, "Number of attempts of the whole update before failing. Fetching a single project is retried on transient errors regardless.")
 	cmdUpdate.Flags.BoolVar(&autoupdateFlag, "autoupdate", true, "Automatically update to the new version.")
 	cmdUpdate.Flags.BoolVar(&forceAutoupdateFlag, "force-autoupdate", false, "Always update to the current version.")
 	cmdUpdate.Flags.BoolVar(&rebaseUntrackedFlag, "rebase-untracked", false, "Rebase untracked branches onto HEAD.")
 	cmdUpdate.Flags.UintVar(&hookTimeoutFlag, "hook-timeout", project.DefaultHookTimeout, "Timeout in minutes for running the hooks operation.")
 	cmdUpdate.Flags.BoolVar(&rebaseAllFlag, "rebase-all", false, "Rebase all tracked branches. Also rebase all untracked bracnhes if -rebase-untracked is passed")
+	cmdUpdate.Flags.BoolVar(&autostashFlag, "autostash", false, "Stash uncommitted changes before updating a project and restore them afterwards.")
+	cmdUpdate.Flags.BoolVar(&resumeFlag, "resume", false, "Resume an interrupted update instead of rolling back its incomplete moves.")
+	cmdUpdate.Flags.StringVar(&jsonOutputFlag, "json-output", "", "Path to write update report to.")
 }
 
 // cmdUpdate represents the "jiri update" command.
@@ -48,17 +55,48 @@ Updates all projects. The sequence in which the individual updates happen
 guarantees that we end up with a consistent workspace. The set of projects
 to update is described in the manifest.
 
+If projects are given, only those projects and their hooks are updated, and
+all other projects are left untouched.
+
 Run "jiri help manifest" for details on manifests.
 `,
-	ArgsName: "<file or url>",
-	ArgsLong: "<file or url> points to snapshot to checkout.",
+	ArgsName: "<file or url> | <project>...",
+	ArgsLong: `
+<file or url> points to snapshot to checkout.
+<project> is a project name or a regular expression matching project names.
+`,
 }
 
-func runUpdate(jirix *jiri.X, args []string) error {
-	if len(args) > 1 {
-		return jirix.UsageErrorf("unexpected number of arguments")
+func runUpdate(jirix *jiri.X, args []string) (e error) {
+	// A single argument naming a file or a URL is a snapshot to checkout,
+	// otherwise the arguments select the projects to update.
+	snapshot := ""
+	var filter project.ProjectFilter
+	if len(args) == 1 && isSnapshotArg(args[0]) {
+		snapshot = args[0]
+	} else if len(args) > 0 {
+		if gcFlag {
+			return jirix.UsageErrorf("-gc cannot be used when updating selected projects")
+		}
+		var err error
+		if filter, err = project.NewProjectFilter(args); err != nil {
+			return err
+		}
 	}
 
+	// Summarize the problems and write the report even if the update fails,
+	// so that users can tell which projects were affected.
+	report := project.NewUpdateReport()
+	defer func() {
+		report.PrintSummary(jirix)
+		if jsonOutputFlag == "" {
+			return
+		}
+		if err := writeJSONOutput(report.Output(jirix, e)); err != nil && e == nil {
+			e = err
+		}
+	}()
+
 	if autoupdateFlag {
 		// Try to update Jiri itself.
 		err := jiri.UpdateAndExecute(forceAutoupdateFlag)
@@ -67,13 +105,18 @@ func runUpdate(jirix *jiri.X, args []string) error {
 		}
 	}
 
+	// Clean up after a previous update that was interrupted.
+	if err := project.RecoverUpdate(jirix, resumeFlag); err != nil {
+		return err
+	}
+
 	// Update all projects to their latest version.
 	// Attempt <attemptsFlag> times before failing.
 	if err := retry.Function(jirix.Context, func() error {
-		if len(args) > 0 {
-			return project.CheckoutSnapshot(jirix, args[0], gcFlag, hookTimeoutFlag)
+		if snapshot != "" {
+			return project.CheckoutSnapshot(jirix, snapshot, gcFlag, hookTimeoutFlag, report)
 		} else {
-			return project.UpdateUniverse(jirix, gcFlag, localManifestFlag, rebaseUntrackedFlag, rebaseAllFlag, hookTimeoutFlag)
+			return project.UpdateUniverse(jirix, gcFlag, localManifestFlag, rebaseUntrackedFlag, rebaseAllFlag, autostashFlag, hookTimeoutFlag, filter, report)
 		}
 	}, retry.AttemptsOpt(attemptsFlag)); err != nil {
 		return err
@@ -86,3 +129,14 @@ func runUpdate(jirix *jiri.X, args []string) error {
 	}
 	return nil
 }
+
+// isSnapshotArg returns true if arg refers to a snapshot rather than to
+// projects.  Directories are never snapshots, since project paths usually
+// match project names.
+func isSnapshotArg(arg string) bool {
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		return true
+	}
+	fi, err := os.Stat(arg)
+	return err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsSnapshotArg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jiri-update-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "snapshot")
+	if err := ioutil.WriteFile(snapshot, []byte("<manifest/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	project := filepath.Join(dir, "project")
+	if err := os.Mkdir(project, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		arg  string
+		want bool
+	}{
+		{snapshot, true},
+		{"https://example.com/snapshot", true},
+		{"http://example.com/snapshot", true},
+		// A project directory names a project, not a snapshot.
+		{project, false},
+		{filepath.Join(dir, "missing"), false},
+		{"project", false},
+	}
+	for _, test := range tests {
+		if got := isSnapshotArg(test.arg); got != test.want {
+			t.Errorf("isSnapshotArg(%q) = %v, want %v", test.arg, got, test.want)
+		}
+	}
+}
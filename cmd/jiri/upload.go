@@ -6,7 +6,6 @@ package main
 
 import (
 	"fmt"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,6 +16,7 @@ import (
 	"fuchsia.googlesource.com/jiri/git"
 	"fuchsia.googlesource.com/jiri/gitutil"
 	"fuchsia.googlesource.com/jiri/project"
+	"fuchsia.googlesource.com/jiri/review"
 )
 
 var (
@@ -31,6 +31,7 @@ var (
 	uploadMultipartFlag    bool
 	uploadBranchFlag       string
 	uploadRemoteBranchFlag string
+	uploadSelectorFlag     string
 )
 
 type uploadError string
@@ -45,12 +46,25 @@ var cmdUpload = &cmdline.Command{
 	Runner: jiri.RunnerFunc(runUpload),
 	Name:   "upload",
 	Short:  "Upload a changelist for review",
-	Long:   `Command "upload" uploads all commits of a local branch to Gerrit.`,
+	Long: `
+Command "upload" uploads all commits of a local branch for review, to the code
+review system set by the "reviewtype" attribute of the project in the
+manifest.
+
+Gerrit projects, the default, get the commits pushed to refs/for/<branch>.
+
+For projects with a "pullrequest" review type, the commits are pushed to the
+branch "jiri/<topic>" of the project remote, and a pull request from that
+branch is opened through the REST API at the "reviewhost" of the project,
+unless one is open already.  The push is refused if someone else updated the
+branch since it was last fetched.  The -r and -cc flags take user names of the
+forge.  The -presubmit flag only applies to Gerrit.
+`,
 }
 
 func init() {
 	cmdUpload.Flags.StringVar(&uploadCcsFlag, "cc", "", `Comma-separated list of emails or LDAPs to cc.`)
-	cmdUpload.Flags.StringVar(&uploadHostFlag, "host", "", `Gerrit host to use.  Defaults to gerrit host specified in manifest.`)
+	cmdUpload.Flags.StringVar(&uploadHostFlag, "host", "", `Review host to use.  Defaults to the review host specified in manifest.`)
 	cmdUpload.Flags.StringVar(&uploadPresubmitFlag, "presubmit", string(gerrit.PresubmitTestTypeAll),
 		fmt.Sprintf("The type of presubmit tests to run. Valid values: %s.", strings.Join(gerrit.PresubmitTestTypes(), ",")))
 	cmdUpload.Flags.StringVar(&uploadReviewersFlag, "r", "", `Comma-separated list of emails or LDAPs to request review.`)
@@ -59,6 +73,7 @@ func init() {
 	cmdUpload.Flags.BoolVar(&uploadVerifyFlag, "verify", true, `Run pre-push git hooks.`)
 	cmdUpload.Flags.BoolVar(&uploadRebaseFlag, "rebase", false, `Run rebase before pushing.`)
 	cmdUpload.Flags.BoolVar(&uploadMultipartFlag, "multipart", false, `Send multipart CL.`)
+	cmdUpload.Flags.StringVar(&uploadSelectorFlag, "select", "", `Used when multipart flag is true to only upload the projects matching this project selector. See 'jiri help selector' for its syntax.`)
 	cmdUpload.Flags.StringVar(&uploadBranchFlag, "branch", "", `Used when multipart flag is true and this command is executed from root folder`)
 	cmdUpload.Flags.StringVar(&uploadRemoteBranchFlag, "remoteBranch", "", `Remote branch to upload change to. If this is not specified and branch is untracked,
 change would be uploaded to branch in project manifest`)
@@ -113,7 +128,15 @@ func runUpload(jirix *jiri.X, _ []string) error {
 		return err
 	}
 	if uploadMultipartFlag {
-		for _, project := range localProjects {
+		selector, err := parseSelector(jirix, uploadSelectorFlag)
+		if err != nil {
+			return err
+		}
+		selectedProjects, err := selector.Select(jirix, localProjects)
+		if err != nil {
+			return err
+		}
+		for _, project := range selectedProjects {
 			scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
 			if scm.IsOnBranch() {
 				branch, err := scm.CurrentBranchName()
@@ -136,16 +159,17 @@ func runUpload(jirix *jiri.X, _ []string) error {
 	if len(projectsToProcess) == 0 {
 		return fmt.Errorf("Did not find any project to push for branch %q", currentBranch)
 	}
-	type GerritPushOption struct {
+	type uploadOption struct {
 		Project      project.Project
-		CLOpts       gerrit.CLOpts
+		Backend      review.Backend
+		Opts         review.UploadOpts
 		relativePath string
 	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	var gerritPushOptions []GerritPushOption
+	var uploadOptions []uploadOption
 	remoteProjects, _, err := project.LoadManifestFile(jirix, jirix.JiriManifestFile(), localProjects, false /*localManifest*/)
 	if err != nil {
 		return err
@@ -180,61 +204,44 @@ func runUpload(jirix *jiri.X, _ []string) error {
 			}
 		}
 
-		host := uploadHostFlag
-		if host == "" {
-			if project.GerritHost == "" {
-				return fmt.Errorf("No gerrit host found.  Please use the '--host' flag, or add a 'gerrithost' attribute for project %s(%s).", project.Name, relativePath)
-			}
-			host = project.GerritHost
-		}
-		hostUrl, err := url.Parse(host)
-		if err != nil {
-			return fmt.Errorf("invalid Gerrit host for project %s(%s) %q: %s", project.Name, relativePath, host, err)
-		}
-		projectRemoteUrl, err := url.Parse(project.Remote)
-		if err != nil {
-			return fmt.Errorf("invalid project remote for project %s(%s): %s", project.Name, relativePath, project.Remote, err)
+		backend, err := review.New(jirix, project, uploadHostFlag)
+		if err == review.ErrNoHost {
+			return fmt.Errorf("No review host found.  Please use the '--host' flag, or add a '%s' attribute for project %s(%s).", review.HostAttribute(project), project.Name, relativePath)
+		} else if err != nil {
+			return err
 		}
-		gerritRemote := *hostUrl
-		gerritRemote.Path = projectRemoteUrl.Path
-		opts := gerrit.CLOpts{
-			Ccs:          parseEmails(uploadCcsFlag),
-			Host:         hostUrl,
+		opts := review.UploadOpts{
+			Ccs:          parseNames(uploadCcsFlag),
 			Presubmit:    gerrit.PresubmitTestType(uploadPresubmitFlag),
 			RemoteBranch: remoteBranch,
-			Remote:       gerritRemote.String(),
-			Reviewers:    parseEmails(uploadReviewersFlag),
+			Reviewers:    parseNames(uploadReviewersFlag),
 			Verify:       uploadVerifyFlag,
 			Topic:        topic,
 			Branch:       currentBranch,
 		}
-
-		if opts.Presubmit == gerrit.PresubmitTestType("") {
-			opts.Presubmit = gerrit.PresubmitTestTypeAll
-		}
-		gerritPushOptions = append(gerritPushOptions, GerritPushOption{project, opts, relativePath})
+		uploadOptions = append(uploadOptions, uploadOption{project, backend, opts, relativePath})
 	}
 
 	// Rebase all projects before pushing
 	if uploadRebaseFlag {
-		for _, gerritPushOption := range gerritPushOptions {
-			scm := gitutil.New(jirix, gitutil.RootDirOpt(gerritPushOption.Project.Path))
+		for _, uploadOption := range uploadOptions {
+			scm := gitutil.New(jirix, gitutil.RootDirOpt(uploadOption.Project.Path))
 			if err := scm.Fetch("origin"); err != nil {
 				return err
 			}
-			remoteBranch := "remotes/origin/" + gerritPushOption.CLOpts.RemoteBranch
+			remoteBranch := "remotes/origin/" + uploadOption.Opts.RemoteBranch
 			if err = scm.Rebase(remoteBranch); err != nil {
 				if err2 := scm.RebaseAbort(); err2 != nil {
 					return err2
 				}
-				return fmt.Errorf("For project %s(%s), not able to rebase the branch to %s, please rebase manually: %s", gerritPushOption.Project.Name, gerritPushOption.relativePath, remoteBranch, err)
+				return fmt.Errorf("For project %s(%s), not able to rebase the branch to %s, please rebase manually: %s", uploadOption.Project.Name, uploadOption.relativePath, remoteBranch, err)
 			}
 		}
 	}
 
-	for _, gerritPushOption := range gerritPushOptions {
-		fmt.Printf("Pushing project %s(%s)\n", gerritPushOption.Project.Name, gerritPushOption.relativePath)
-		if err := gerrit.Push(jirix.NewSeq().Dir(gerritPushOption.Project.Path), gerritPushOption.CLOpts); err != nil {
+	for _, uploadOption := range uploadOptions {
+		fmt.Printf("Pushing project %s(%s)\n", uploadOption.Project.Name, uploadOption.relativePath)
+		if err := uploadOption.Backend.Upload(uploadOption.Opts); err != nil {
 			if strings.Contains(err.Error(), "(no new changes)") {
 				if gitErr, ok := err.(gerrit.PushError); ok {
 					fmt.Printf("%s", gitErr.Output)
@@ -251,21 +258,13 @@ func runUpload(jirix *jiri.X, _ []string) error {
 	return nil
 }
 
-// parseEmails input a list of comma separated tokens and outputs a
-// list of email addresses. The tokens can either be email addresses
-// or Google LDAPs in which case the suffix @google.com is appended to
-// them to turn them into email addresses.
-func parseEmails(value string) []string {
-	var emails []string
-	tokens := strings.Split(value, ",")
-	for _, token := range tokens {
-		if token == "" {
-			continue
-		}
-		if !strings.Contains(token, "@") {
-			token += "@google.com"
+// parseNames splits a list of comma separated user names or emails.
+func parseNames(value string) []string {
+	var names []string
+	for _, token := range strings.Split(value, ",") {
+		if token != "" {
+			names = append(names, token)
 		}
-		emails = append(emails, token)
 	}
-	return emails
+	return names
 }
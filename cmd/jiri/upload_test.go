@@ -14,6 +14,7 @@ import (
 
 	"fuchsia.googlesource.com/jiri"
 	"fuchsia.googlesource.com/jiri/gerrit"
+	"fuchsia.googlesource.com/jiri/git"
 	"fuchsia.googlesource.com/jiri/gitutil"
 	"fuchsia.googlesource.com/jiri/jiritest"
 	"fuchsia.googlesource.com/jiri/project"
@@ -455,6 +456,79 @@ func TestUploadUntrackedBranch(t *testing.T) {
 	assertUploadPushedFilesToRef(t, fake.X, gerritPath, expectedRef, files)
 }
 
+func TestUploadToGerrit(t *testing.T) {
+	defer resetFlags()
+	fake, localProjects, cleanup := setupUploadTest(t)
+	defer cleanup()
+	g, stop := jiritest.NewFakeGerrit(t)
+	defer stop()
+	currentDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Chdir(currentDir); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if err := os.Chdir(localProjects[1].Path); err != nil {
+		t.Fatal(err)
+	}
+	scm := gitutil.New(fake.X, gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	if err := scm.CreateBranchWithUpstream("my-branch", "origin/master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CheckoutBranch("my-branch"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, fake.X, "file1", "first")
+	if err := scm.CommitAmendWithMessage("Add file1\n\nChange-Id: I0123456789abcdef0123456789abcdef01234567"); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadHostFlag = g.URL
+	uploadTopicFlag = "test"
+	if err := runUpload(fake.X, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	changes := g.Changes()
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change, got %+v", changes)
+	}
+	c := changes[0]
+	if c.Topic != "test" || c.Branch != "master" || c.Subject != "Add file1" || c.Status != "NEW" || c.Reference() != "refs/changes/01/1/1" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	if pushes := g.Pushes(); len(pushes) != 1 || pushes[0].Ref != "refs/for/master%topic=test" || pushes[0].Repository != fake.Projects[localProjects[1].Name] {
+		t.Errorf("unexpected pushes: %+v", pushes)
+	}
+
+	// Uploading the amended commit creates a new patchset.
+	if err := fake.X.NewSeq().WriteFile("file1", []byte("second"), 0644).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Add("file1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CommitAmend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runUpload(fake.X, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	changes = g.Changes()
+	if len(changes) != 1 || len(changes[0].Revisions) != 2 || changes[0].Reference() != "refs/changes/01/1/2" {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+	rev, err := git.NewGit(localProjects[1].Path).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes[0].Current_revision != rev {
+		t.Errorf("got current revision %q, want %q", changes[0].Current_revision, rev)
+	}
+}
+
 // commitFile commits a file with the specified content into a branch
 func commitFile(t *testing.T, jirix *jiri.X, filename string, content string) {
 	s := jirix.NewSeq()
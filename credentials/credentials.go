@@ -0,0 +1,355 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package credentials finds the credentials jiri uses to authenticate HTTP
+// requests to Gerrit, gitiles and other hosts.  Help describes where they
+// are looked up.
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// TokenEnv is the environment variable holding a bearer token.
+	TokenEnv = "JIRI_AUTH_TOKEN"
+	// TokenFileEnv is the environment variable holding the name of a file
+	// containing a bearer token.
+	TokenFileEnv = "JIRI_AUTH_TOKEN_FILE"
+	// TokenHostsEnv is the environment variable holding the comma-separated
+	// list of hosts the bearer token is sent to.
+	TokenHostsEnv = "JIRI_AUTH_TOKEN_HOSTS"
+)
+
+// Help documents how credentials are found.
+const Help = `
+jiri authenticates its HTTP requests to Gerrit, gitiles and other hosts with
+the first credentials found for the host in:
+  $JIRI_AUTH_TOKEN       a bearer token
+  $JIRI_AUTH_TOKEN_FILE  a file containing a bearer token
+  ~/.netrc               lines of the form
+                         "machine <host> login <username> password <password>"
+  git cookie file        the file set by git's http.cookiefile, or
+                         ~/.gitcookies; cookies for the host are sent, and
+                         googlesource cookies are also used as username and
+                         password
+  git credential helper  credentials returned by "git credential fill", so
+                         that any helper configured with credential.helper
+                         works; git is not allowed to prompt for them
+
+The bearer token is sent to the hosts listed in $JIRI_AUTH_TOKEN_HOSTS,
+separated by commas, or to the Gerrit, review and gitiles hosts of the
+manifest if it is not set.  The git credential helper is only asked for the
+credentials of Gerrit, review and gitiles hosts, not for those of snapshot
+URLs or of the hosts jiri updates itself from.
+
+Credentials are only sent over https, or to the local host.
+`
+
+// Credentials authenticate requests to a host.
+type Credentials struct {
+	// Token is a bearer token.  It takes precedence over Username and
+	// Password.
+	Token    string
+	Username string
+	Password string
+	// Cookies are sent along with every request.
+	Cookies []*http.Cookie
+	// Source describes where the credentials were found.
+	Source string
+}
+
+// Authenticate adds the credentials to req.  It does nothing if c is nil.
+func (c *Credentials) Authenticate(req *http.Request) {
+	if c == nil {
+		return
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	for _, cookie := range c.Cookies {
+		req.AddCookie(cookie)
+	}
+}
+
+type lookup struct {
+	creds *Credentials
+	err   error
+}
+
+// hostKind tells which sources of credentials apply to a host.
+type hostKind int
+
+const (
+	// reviewHost is a Gerrit, review or gitiles host of the manifest.
+	reviewHost hostKind = iota
+	// downloadHost is any other host files are downloaded from.
+	downloadHost
+)
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]lookup{}
+)
+
+// resetCache forgets the credentials found so far.
+func resetCache() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cache = map[string]lookup{}
+}
+
+// ForHost returns the credentials for the host of u, which must be a Gerrit,
+// review or gitiles host.  The lookup is done once per scheme and host, and
+// its result is reused afterwards.
+func ForHost(u *url.URL) (*Credentials, error) {
+	return forHost(u, reviewHost)
+}
+
+// Authenticate adds the credentials for the host of req to req, if there are
+// any.  The host must be a Gerrit, review or gitiles host.
+func Authenticate(req *http.Request) {
+	creds, _ := ForHost(req.URL)
+	creds.Authenticate(req)
+}
+
+// AuthenticateDownload adds the credentials for the host of req to req, if
+// there are any.  It is meant for hosts which are not known to serve code,
+// such as those of snapshot URLs: the bearer token is only sent if the host
+// is listed in $JIRI_AUTH_TOKEN_HOSTS, and git credential helpers are not
+// asked.
+func AuthenticateDownload(req *http.Request) {
+	creds, _ := forHost(req.URL, downloadHost)
+	creds.Authenticate(req)
+}
+
+func forHost(u *url.URL, kind hostKind) (*Credentials, error) {
+	key := fmt.Sprintf("%d:%s://%s", kind, u.Scheme, u.Host)
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if l, ok := cache[key]; ok {
+		return l.creds, l.err
+	}
+	creds, err := findCredentials(u, kind)
+	cache[key] = lookup{creds, err}
+	return creds, err
+}
+
+func findCredentials(u *url.URL, kind hostKind) (*Credentials, error) {
+	if !isSecure(u) {
+		return nil, fmt.Errorf("not sending credentials to %q over %s", u.Scheme+"://"+u.Host, u.Scheme)
+	}
+	if tokenAllowed(u, kind) {
+		if creds, err := tokenCredentials(); creds != nil || err != nil {
+			return creds, err
+		}
+	}
+	host := u.Hostname()
+	home := os.Getenv("HOME")
+	if home != "" {
+		if creds, err := netrcCredentials(filepath.Join(home, ".netrc"), host); creds != nil || err != nil {
+			return creds, err
+		}
+	}
+	if creds, err := cookieCredentials(gitCookieFile(home), host); creds != nil || err != nil {
+		return creds, err
+	}
+	if kind == reviewHost {
+		if creds := helperCredentials(u); creds != nil {
+			return creds, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find credentials for %q", u.Scheme+"://"+u.Host)
+}
+
+// isSecure returns true if credentials can be sent to u: it uses https, or
+// it is on the local host.
+func isSecure(u *url.URL) bool {
+	if u.Scheme == "https" {
+		return true
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tokenAllowed returns true if the bearer token may be sent to u: its host is
+// listed in $JIRI_AUTH_TOKEN_HOSTS, or the variable is not set and u is a
+// review host.
+func tokenAllowed(u *url.URL, kind hostKind) bool {
+	hosts := strings.TrimSpace(os.Getenv(TokenHostsEnv))
+	if hosts == "" {
+		return kind == reviewHost
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host == u.Host || host == u.Hostname() {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenCredentials returns the bearer token credentials from the environment,
+// or nil if there are none.
+func tokenCredentials() (*Credentials, error) {
+	if token := strings.TrimSpace(os.Getenv(TokenEnv)); token != "" {
+		return &Credentials{Token: token, Source: "$" + TokenEnv}, nil
+	}
+	file := os.Getenv(TokenFileEnv)
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read token file named by $%s: %v", TokenFileEnv, err)
+	}
+	if token := strings.TrimSpace(string(data)); token != "" {
+		return &Credentials{Token: token, Source: file}, nil
+	}
+	return nil, fmt.Errorf("token file %q named by $%s is empty", file, TokenFileEnv)
+}
+
+// netrcCredentials returns the credentials for host in the given netrc file,
+// or nil if there are none.
+func netrcCredentials(file, host string) (_ *Credentials, e error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); e == nil {
+			e = err
+		}
+	}()
+	credsMap, err := parseNetrcFile(f)
+	if err != nil {
+		return nil, err
+	}
+	if creds, ok := credsMap[host]; ok {
+		creds.Source = file
+		return creds, nil
+	}
+	return nil, nil
+}
+
+// gitCookieFile returns the git cookie file: the one configured by
+// http.cookiefile, or ~/.gitcookies.
+func gitCookieFile(home string) string {
+	var stdout bytes.Buffer
+	cmd := exec.Command("git", "config", "--get", "--path", "http.cookiefile")
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err == nil {
+		if file := strings.TrimSpace(stdout.String()); file != "" {
+			return file
+		}
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".gitcookies")
+}
+
+// cookieCredentials returns the credentials for host in the given git cookie
+// file, or nil if there are none.
+func cookieCredentials(file, host string) (*Credentials, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var creds *Credentials
+	for _, cookie := range parseCookieFile(data) {
+		if !domainMatches(cookie.Domain, host) {
+			continue
+		}
+		if creds == nil {
+			creds = &Credentials{Source: file}
+		}
+		creds.Cookies = append(creds.Cookies, cookie)
+		// Googlesource cookies have the form "o=<username>=<password>".  An
+		// exact host match takes precedence over a site-wide one.
+		tokens := strings.Split(cookie.Value, "=")
+		if len(tokens) == 2 && (creds.Username == "" || cookie.Domain == host) {
+			creds.Username, creds.Password = tokens[0], tokens[1]
+		}
+	}
+	return creds, nil
+}
+
+// domainMatches returns true if a cookie for domain should be sent to host.
+// A domain of the form ".<name>" matches any host "*.<name>".
+func domainMatches(domain, host string) bool {
+	if domain == host {
+		return true
+	}
+	return strings.HasPrefix(domain, ".") && strings.HasSuffix(host, domain)
+}
+
+// helperCredentials returns the credentials git credential helpers have for
+// u, or nil if there are none.  Git is not allowed to prompt the user.
+func helperCredentials(u *url.URL) *Credentials {
+	var stdin, stdout bytes.Buffer
+	fmt.Fprintf(&stdin, "protocol=%s\nhost=%s\n\n", u.Scheme, u.Host)
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin, cmd.Stdout = &stdin, &stdout
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	if err := cmd.Run(); err != nil {
+		return nil
+	}
+	creds := parseCredentialOutput(&stdout)
+	if creds == nil {
+		return nil
+	}
+	creds.Source = "git credential helper"
+	return creds
+}
+
+// parseCredentialOutput parses the output of "git credential fill" and
+// returns the credentials it contains, or nil if it has no password.
+func parseCredentialOutput(reader io.Reader) *Credentials {
+	creds := &Credentials{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		switch key, value := line[:i], line[i+1:]; key {
+		case "username":
+			creds.Username = value
+		case "password":
+			creds.Password = value
+		}
+	}
+	if scanner.Err() != nil || creds.Password == "" {
+		return nil
+	}
+	return creds
+}
@@ -0,0 +1,313 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package credentials
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func assertStringParsesToCookie(t *testing.T, s string, want http.Cookie) {
+	got, err := parseCookie(s)
+	if err != nil {
+		t.Errorf("parseCookie(%q) returned error %v", s, err)
+		return
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("expected parseCookie(%q) to be %#v but got %#v", s, want, *got)
+	}
+}
+
+func TestParseCookie(t *testing.T) {
+	testTime := time.Unix(1445039205394, 0)
+
+	assertStringParsesToCookie(t,
+		fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s", ".example.com", "TRUE", "/", "TRUE", testTime.Unix(), "foo", "bar"),
+		http.Cookie{
+			Domain:  ".example.com",
+			Path:    "/",
+			Secure:  true,
+			Expires: testTime,
+			Name:    "foo",
+			Value:   "bar",
+		})
+
+	assertStringParsesToCookie(t,
+		fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s", "whitehouse.gov", "FALSE", "/some/path", "FALSE", 0, "biz", "baz"),
+		http.Cookie{
+			Domain:  "whitehouse.gov",
+			Path:    "/some/path",
+			Secure:  false,
+			Expires: time.Unix(0, 0),
+			Name:    "biz",
+			Value:   "baz",
+		})
+
+	// Test with missing field.
+	s := fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%s", ".example.com", "/", "TRUE", testTime.Unix(), "foo", "bar")
+	if _, err := parseCookie(s); err == nil {
+		t.Errorf("expected parseCookie(%q) to return error but it did not", s)
+	}
+
+	// Test with extra field.
+	s = fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s", ".example.com", "TRUE", "/", "TRUE", testTime.Unix(), "foo", "bar", "baz")
+	if _, err := parseCookie(s); err == nil {
+		t.Errorf("expected parseCookie(%q) to return error but it did not", s)
+	}
+
+	// Test with invalid expiration.
+	s = fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", ".example.com", "TRUE", "/", "TRUE", "thisIsNotATime", "foo", "bar")
+	if _, err := parseCookie(s); err == nil {
+		t.Errorf("expected parseCookie(%q) to return error but it did not", s)
+	}
+}
+
+func TestParseCookieFile(t *testing.T) {
+	content := fmt.Sprintf("\n# this is a comment\n%s\t%s\t%s\t%s\t%d\t%s\t%s\ninvalid line\n", ".example.com", "FALSE", "/", "FALSE", 0, "name", "value")
+	want := []*http.Cookie{{
+		Domain:  ".example.com",
+		Path:    "/",
+		Secure:  false,
+		Expires: time.Unix(0, 0),
+		Name:    "name",
+		Value:   "value",
+	}}
+	if got := parseCookieFile([]byte(content)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got cookies %#v, want %#v", got, want)
+	}
+}
+
+func TestParseValidNetRcFile(t *testing.T) {
+	// Valid content.
+	netrcFileContent := `
+machine vanadium.googlesource.com login git-johndoe.example.com password 12345
+machine vanadium-review.googlesource.com login git-johndoe.example.com password 54321
+	`
+	got, err := parseNetrcFile(strings.NewReader(netrcFileContent))
+	expected := map[string]*Credentials{
+		"vanadium.googlesource.com": &Credentials{
+			Username: "git-johndoe.example.com",
+			Password: "12345",
+		},
+		"vanadium-review.googlesource.com": &Credentials{
+			Username: "git-johndoe.example.com",
+			Password: "54321",
+		},
+	}
+	if err != nil {
+		t.Fatalf("want no errors, got: %v", err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("want: %#v, got: %#v", expected, got)
+	}
+}
+
+func TestParseInvalidNetRcFile(t *testing.T) {
+	// Content with invalid entries which should be skipped.
+	netRcFileContentWithInvalidEntries := `
+machine vanadium.googlesource.com login git-johndoe.example.com password
+machine_blah vanadium3.googlesource.com login git-johndoe.example.com password 12345
+machine vanadium2.googlesource.com login_blah git-johndoe.example.com password 12345
+machine vanadium4.googlesource.com login git-johndoe.example.com password_blah 12345
+machine vanadium-review.googlesource.com login git-johndoe.example.com password 54321
+	`
+	got, err := parseNetrcFile(strings.NewReader(netRcFileContentWithInvalidEntries))
+	expected := map[string]*Credentials{
+		"vanadium-review.googlesource.com": &Credentials{
+			Username: "git-johndoe.example.com",
+			Password: "54321",
+		},
+	}
+	if err != nil {
+		t.Fatalf("want no errors, got: %v", err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("want: %#v, got: %#v", expected, got)
+	}
+}
+
+func TestCookieCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, ".gitcookies")
+	content := `
+vanadium.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com=12345
+.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com=12321
+vanadium-review.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com
+vanadium-review.googlesource.com FALSE / TRUE 2147483647 o git-johndoe.example.com=54321
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host, password string
+		cookies        int
+	}{
+		// Exact matches take precedence over site-wide credentials.
+		{"vanadium.googlesource.com", "12345", 2},
+		// Invalid entries are skipped.
+		{"vanadium-review.googlesource.com", "12321", 2},
+		{"fuchsia.googlesource.com", "12321", 1},
+		{"example.com", "", 0},
+	}
+	for _, test := range tests {
+		creds, err := cookieCredentials(file, test.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.cookies == 0 {
+			if creds != nil {
+				t.Errorf("%s: expected no credentials, got %+v", test.host, creds)
+			}
+			continue
+		}
+		if creds == nil || creds.Username != "git-johndoe.example.com" || creds.Password != test.password || len(creds.Cookies) != test.cookies {
+			t.Errorf("%s: unexpected credentials %+v", test.host, creds)
+		}
+	}
+}
+
+// setenv sets the environment variable key to value, and returns a function
+// restoring its previous value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestForHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setenv("HOME", dir)()
+	defer setenv("GIT_CONFIG_NOSYSTEM", "1")()
+	defer setenv(TokenEnv, "")()
+	defer setenv(TokenFileEnv, "")()
+	defer setenv(TokenHostsEnv, "")()
+	defer resetCache()
+
+	netrc := "machine netrc.example.com login john password netrc\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ".netrc"), []byte(netrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gitconfig := `[credential "https://helper.example.com"]
+	helper = "!f() { echo username=jane; echo password=helper; }; f"
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gitconfig"), []byte(gitconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(host, username, password, token string) {
+		resetCache()
+		u, err := url.Parse(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds, err := ForHost(u)
+		if username == "" && token == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got credentials %+v", host, creds)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if creds.Username != username || creds.Password != password || creds.Token != token {
+			t.Errorf("%s: unexpected credentials %+v", host, creds)
+		}
+	}
+	check("https://netrc.example.com", "john", "netrc", "")
+	check("https://helper.example.com", "jane", "helper", "")
+	check("https://none.example.com", "", "", "")
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("filetoken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(TokenFileEnv, tokenFile)
+	check("https://netrc.example.com", "", "", "filetoken")
+	os.Setenv(TokenEnv, "envtoken")
+	check("https://none.example.com", "", "", "envtoken")
+
+	req, err := http.NewRequest("GET", "https://none.example.com/a/changes/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Authenticate(req)
+	if got, want := req.Header.Get("Authorization"), "Bearer envtoken"; got != want {
+		t.Errorf("got Authorization header %q, want %q", got, want)
+	}
+}
+
+func TestAuthenticateScope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setenv("HOME", dir)()
+	defer setenv("GIT_CONFIG_NOSYSTEM", "1")()
+	defer setenv(TokenEnv, "token")()
+	defer setenv(TokenFileEnv, "")()
+	defer setenv(TokenHostsEnv, "")()
+	defer resetCache()
+
+	gitconfig := `[credential "https://helper.example.com"]
+	helper = "!f() { echo username=jane; echo password=helper; }; f"
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gitconfig"), []byte(gitconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(authenticate func(*http.Request), rawurl, want string) {
+		resetCache()
+		req, err := http.NewRequest("GET", rawurl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticate(req)
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s: got Authorization header %q, want %q", rawurl, got, want)
+		}
+	}
+	// Without $JIRI_AUTH_TOKEN_HOSTS, the token is only sent to review hosts.
+	check(Authenticate, "https://review.example.com/a/changes/", "Bearer token")
+	check(AuthenticateDownload, "https://snapshots.example.com/snapshot", "")
+	// Credentials are never sent in clear text, except to the local host.
+	check(Authenticate, "http://review.example.com/a/changes/", "")
+	check(Authenticate, "http://127.0.0.1:8080/a/changes/", "Bearer token")
+	check(Authenticate, "http://localhost/a/changes/", "Bearer token")
+
+	os.Setenv(TokenHostsEnv, "snapshots.example.com, review.example.com:8443")
+	check(Authenticate, "https://review.example.com/a/changes/", "")
+	check(Authenticate, "https://review.example.com:8443/a/changes/", "Bearer token")
+	check(AuthenticateDownload, "https://snapshots.example.com/snapshot", "Bearer token")
+	check(AuthenticateDownload, "http://snapshots.example.com/snapshot", "")
+
+	// Git credential helpers are only asked for review hosts.
+	os.Setenv(TokenEnv, "")
+	check(Authenticate, "https://helper.example.com/a/changes/", "Basic amFuZTpoZWxwZXI=")
+	check(AuthenticateDownload, "https://helper.example.com/snapshot", "")
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package credentials
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseCookie takes a single line from a cookie jar and parses it, returning
+// an *http.Cookie.
+func parseCookie(s string) (*http.Cookie, error) {
+	// Cookiejar files have 7 tab-delimited fields.
+	// See http://curl.haxx.se/mail/archive-2005-03/0099.html
+	// 0: domain
+	// 1: tailmatch
+	// 2: path
+	// 3: secure
+	// 4: expires
+	// 5: name
+	// 6: value
+
+	fields := strings.Split(strings.TrimSpace(s), "\t")
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("expected 7 fields but got %d: %q", len(fields), s)
+	}
+	expires, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiration: %q", fields[4])
+	}
+
+	cookie := &http.Cookie{
+		Domain:  fields[0],
+		Path:    fields[2],
+		Secure:  fields[3] == "TRUE",
+		Expires: time.Unix(expires, 0),
+		Name:    fields[5],
+		Value:   fields[6],
+	}
+	return cookie, nil
+}
+
+// parseCookieFile parses the content of a cookie jar and returns its cookies.
+// Comments and invalid lines are skipped.
+func parseCookieFile(bytes []byte) (cookies []*http.Cookie) {
+	lines := strings.Split(string(bytes), "\n")
+
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" || line[0] == '#' {
+			continue
+		}
+		if cookie, err := parseCookie(line); err == nil {
+			cookies = append(cookies, cookie)
+		}
+	}
+	return
+}
+
+// parseNetrcFile parses the content of the given netrc file and
+// returns credentials stored in the file indexed by hosts.
+func parseNetrcFile(reader io.Reader) (map[string]*Credentials, error) {
+	credsMap := map[string]*Credentials{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.Split(line, " ")
+		if len(parts) != 6 || parts[0] != "machine" || parts[2] != "login" || parts[4] != "password" {
+			continue
+		}
+		host := parts[1]
+		if _, present := credsMap[host]; present {
+			return nil, fmt.Errorf("multiple logins exist for %q, please ensure there is only one", host)
+		}
+		credsMap[host] = &Credentials{
+			Username: parts[3],
+			Password: parts[5],
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Scan() failed: %v", err)
+	}
+	return credsMap, nil
+}
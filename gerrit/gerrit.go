@@ -18,8 +18,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"fuchsia.googlesource.com/jiri/collect"
+	"fuchsia.googlesource.com/jiri/credentials"
 	"fuchsia.googlesource.com/jiri/runutil"
 )
 
@@ -29,9 +31,75 @@ var (
 	multiPartRE     = regexp.MustCompile(`MultiPart:\s*(\d+)\s*/\s*(\d+)`)
 	presubmitTestRE = regexp.MustCompile(`PresubmitTest:\s*(.*)`)
 
-	queryParameters = []string{"CURRENT_REVISION", "CURRENT_COMMIT", "CURRENT_FILES", "LABELS", "DETAILED_ACCOUNTS"}
+	queryParameters = []string{"CURRENT_REVISION", "CURRENT_COMMIT", "CURRENT_FILES", "LABELS", "DETAILED_LABELS", "DETAILED_ACCOUNTS", "MESSAGES", "SUBMITTABLE"}
 )
 
+const (
+	// requestTimeout bounds every request to Gerrit, so that a hung server
+	// cannot hang jiri.
+	requestTimeout = 2 * time.Minute
+	// requestAttempts is the number of times a request is attempted when it
+	// fails with a network error or a server error.
+	requestAttempts = 3
+)
+
+var (
+	httpClient = &http.Client{Timeout: requestTimeout}
+	// retryInterval is the wait before the second attempt of a request.  It
+	// doubles after every failed attempt.
+	retryInterval = 2 * time.Second
+)
+
+// HTTPError is returned when Gerrit answers a request with an unexpected
+// status code.  Use IsUnauthorized, IsForbidden, IsNotFound and IsConflict to
+// tell the common ones apart.
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Body is the message Gerrit sent along with the status.
+	Body string
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s %s failed: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if body := strings.TrimSpace(e.Body); body != "" {
+		msg += ": " + body
+	}
+	return msg
+}
+
+func hasStatus(err error, code int) bool {
+	if e, ok := err.(*HTTPError); ok {
+		return e.StatusCode == code
+	}
+	return false
+}
+
+// IsUnauthorized returns true if err is an HTTPError for a request Gerrit
+// could not authenticate, usually because of missing or stale credentials.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true if err is an HTTPError for a request the user is
+// not allowed to make.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsNotFound returns true if err is an HTTPError for a change or revision
+// which does not exist or is not visible to the user.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict returns true if err is an HTTPError for a request conflicting
+// with the state of the change, e.g. submitting a change which is merged.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
 // Comment represents a single inline file comment.
 type Comment struct {
 	Line    int    `json:"line,omitempty"`
@@ -90,22 +158,94 @@ func New(s runutil.Sequence, host *url.URL) *Gerrit {
 	}
 }
 
-// PostReview posts a review to the given Gerrit reference.
-func (g *Gerrit) PostReview(ref string, message string, labels map[string]string) (e error) {
-	cred, err := hostCredentials(g.s, g.host)
+// request sends a request with the given method to the given path of the
+// Gerrit instance, with data encoded as JSON as its body unless it is nil,
+// and returns the body of the response.  Requests are authenticated with the
+// credentials of the host when there are any; if authRequired is set, missing
+// credentials are an error.  Idempotent requests failing with a network error
+// or a server error are retried with exponential backoff.
+func (g *Gerrit) request(method, path string, query url.Values, data interface{}, authRequired bool) ([]byte, error) {
+	cred, err := credentials.ForHost(g.host)
+	if err != nil && authRequired {
+		return nil, err
+	}
+	u, err := url.Parse(g.host.String())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	u.Path = path
+	if cred != nil {
+		// Gerrit requires prefixing the endpoint URL with /a/ for authentication.
+		u.Path = "/a" + u.Path
+	}
+	u.RawQuery = query.Encode()
+	var encoded []byte
+	if data != nil {
+		if encoded, err = json.Marshal(data); err != nil {
+			return nil, fmt.Errorf("Marshal(%#v) failed: %v", data, err)
+		}
 	}
 
-	review := Review{
-		Message: message,
-		Labels:  labels,
+	attempts := 1
+	if method == "GET" || method == "PUT" {
+		attempts = requestAttempts
+	}
+	wait := retryInterval
+	for i := 1; ; i++ {
+		content, err := g.send(cred, method, u.String(), encoded)
+		if err == nil || i >= attempts || !isTransient(err) {
+			return content, err
+		}
+		time.Sleep(wait)
+		wait *= 2
 	}
+}
 
-	// Encode "review" as JSON.
-	encodedBytes, err := json.Marshal(review)
+// send sends a single request and returns the body of the response.
+func (g *Gerrit) send(cred *credentials.Credentials, method, url string, data []byte) (_ []byte, e error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
 	if err != nil {
-		return fmt.Errorf("Marshal(%#v) failed: %v", review, err)
+		return nil, fmt.Errorf("NewRequest(%q, %q) failed: %v", method, url, err)
+	}
+	req.Header.Add("Accept", "application/json")
+	if data != nil {
+		req.Header.Add("Content-Type", "application/json;charset=UTF-8")
+	}
+	cred.Authenticate(req)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %v", method, url, err)
+	}
+	defer collect.Error(func() error { return res.Body.Close() }, &e)
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %v", method, url, err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &HTTPError{Method: method, URL: url, StatusCode: res.StatusCode, Body: string(content)}
+	}
+	return content, nil
+}
+
+// isTransient returns true if a request failing with err is worth retrying.
+func isTransient(err error) bool {
+	e, ok := err.(*HTTPError)
+	if !ok {
+		// Network errors and timeouts.
+		return true
+	}
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// PostReview posts a review to the given Gerrit reference.
+func (g *Gerrit) PostReview(ref string, message string, labels map[string]string) error {
+	review := Review{
+		Message: message,
+		Labels:  labels,
 	}
 
 	// Construct API URL.
@@ -115,26 +255,9 @@ func (g *Gerrit) PostReview(ref string, message string, labels map[string]string
 		return fmt.Errorf("unexpected number of %q parts: expected %v, got %v", ref, expected, got)
 	}
 	cl, revision := parts[3], parts[4]
-	url := fmt.Sprintf("%s/a/changes/%s/revisions/%s/review", g.host, cl, revision)
-
-	// Post the review.
-	method, body := "POST", bytes.NewReader(encodedBytes)
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return fmt.Errorf("NewRequest(%q, %q, %v) failed: %v", method, url, body, err)
-	}
-	req.Header.Add("Content-Type", "application/json;charset=UTF-8")
-	req.SetBasicAuth(cred.username, cred.password)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("Do(%v) failed: %v", req, err)
-	}
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("PostReview:Do(%v) failed: %v", req, res.StatusCode)
-	}
-	defer collect.Error(func() error { return res.Body.Close() }, &e)
-
-	return nil
+	path := fmt.Sprintf("/changes/%s/revisions/%s/review", cl, revision)
+	_, err := g.request("POST", path, nil, review, true)
+	return err
 }
 
 type Topic struct {
@@ -142,35 +265,9 @@ type Topic struct {
 }
 
 // SetTopic sets the topic of the given Gerrit reference.
-func (g *Gerrit) SetTopic(cl string, opts CLOpts) (e error) {
-	cred, err := hostCredentials(g.s, g.host)
-	if err != nil {
-		return err
-	}
-	topic := Topic{opts.Topic}
-	data, err := json.Marshal(topic)
-	if err != nil {
-		return fmt.Errorf("Marshal(%#v) failed: %v", topic, err)
-	}
-
-	url := fmt.Sprintf("%s/a/changes/%s/topic", g.host, cl)
-	method, body := "PUT", bytes.NewReader(data)
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return fmt.Errorf("NewRequest(%q, %q, %v) failed: %v", method, url, body, err)
-	}
-	req.Header.Add("Content-Type", "application/json;charset=UTF-8")
-	req.SetBasicAuth(cred.username, cred.password)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("Do(%v) failed: %v", req, err)
-	}
-	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("SetTopic:Do(%v) failed: %v", req, res.StatusCode)
-	}
-	defer collect.Error(func() error { return res.Body.Close() }, &e)
-
-	return nil
+func (g *Gerrit) SetTopic(cl string, opts CLOpts) error {
+	_, err := g.request("PUT", fmt.Sprintf("/changes/%s/topic", cl), nil, Topic{opts.Topic}, true)
+	return err
 }
 
 // The following types reflect the schema Gerrit uses to represent
@@ -181,12 +278,27 @@ type Change struct {
 	// CL data.
 	Change_id        string
 	Current_revision string
+	Number           int `json:"_number"`
 	Project          string
 	Topic            string
 	Branch           string
+	Status           string
+	Subject          string
 	Revisions        Revisions
 	Owner            Owner
 	Labels           map[string]map[string]interface{}
+	// Reviewers maps a reviewer state, "REVIEWER" or "CC", to the accounts
+	// in that state.
+	Reviewers map[string][]Owner
+	Messages  []ChangeMessage
+	// Submittable is set if the change can be submitted as is.
+	Submittable bool
+	// Mergeable is set if the change merges cleanly into its branch.  Gerrit
+	// only reports it for open changes, and only if it is configured to.
+	Mergeable bool
+	// MoreChanges is set on the last change of a query result truncated by
+	// the server.
+	MoreChanges bool `json:"_more_changes"`
 
 	// Custom labels.
 	AutoSubmit    bool
@@ -212,6 +324,15 @@ type Owner struct {
 	Name  string
 	Email string
 }
+
+// ChangeMessage is a message posted on a change, by a reviewer or by Gerrit.
+type ChangeMessage struct {
+	Id             string
+	Author         Owner
+	Date           string
+	Message        string
+	RevisionNumber int `json:"_revision_number"`
+}
 type Files map[string]struct{}
 type ChangeError struct {
 	Err error
@@ -234,6 +355,26 @@ func (c Change) OwnerEmail() string {
 	return c.Owner.Email
 }
 
+// Vote returns the strongest vote on label: the lowest one if there is a
+// negative vote, the highest one otherwise, or 0 if there are no votes.
+func (c Change) Vote(label string) int {
+	all, _ := c.Labels[label]["all"].([]interface{})
+	min, max := 0, 0
+	for _, approval := range all {
+		a, _ := approval.(map[string]interface{})
+		value, _ := a["value"].(float64)
+		if v := int(value); v < min {
+			min = v
+		} else if v > max {
+			max = v
+		}
+	}
+	if min < 0 {
+		return min
+	}
+	return max
+}
+
 type PresubmitTestType string
 
 const (
@@ -320,51 +461,39 @@ func parsePresubmitTestType(match string) PresubmitTestType {
 // Query returns a list of QueryResult entries matched by the given
 // Gerrit query string from the given Gerrit instance. The result is
 // sorted by the last update time, most recently updated to oldest
-// updated.
+// updated.  Results the server splits into several pages are fetched
+// page by page.
 //
 // See the following links for more details about Gerrit search syntax:
 // - https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#list-changes
 // - https://gerrit-review.googlesource.com/Documentation/user-search.html
-func (g *Gerrit) Query(query string) (_ CLList, e error) {
-	u, err := url.Parse(g.host.String())
-	if err != nil {
-		return nil, err
-	}
-	u.Path = "/changes/"
-	cred, _ := hostCredentials(g.s, g.host)
-	if cred != nil {
-		// Gerrit requires prefixing the endpoint URL with /a/ for authentication.
-		u.Path = "/a" + u.Path
-	}
+func (g *Gerrit) Query(query string) (CLList, error) {
 	v := url.Values{}
 	v.Set("q", query)
 	for _, o := range queryParameters {
 		v.Add("o", o)
 	}
-	u.RawQuery = v.Encode()
-	url := u.String()
-
-	var body io.Reader
-	method, body := "GET", nil
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, fmt.Errorf("NewRequest(%q, %q, %v) failed: %v", method, url, body, err)
-	}
-	req.Header.Add("Accept", "application/json")
-	// We ignore all errors when obtaining credentials since not every host requires them.
-	if cred != nil {
-		req.SetBasicAuth(cred.username, cred.password)
-	}
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("Do(%v) failed: %v", req, err)
-	}
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Query:Do(%v) failed: %v", req, res.StatusCode)
+	changes := CLList{}
+	for {
+		if len(changes) > 0 {
+			v.Set("S", strconv.Itoa(len(changes)))
+		}
+		// We ignore missing credentials since not every host requires them.
+		content, err := g.request("GET", "/changes/", v, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		page, err := parseQueryResults(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, page...)
+		if len(page) == 0 || !page[len(page)-1].MoreChanges {
+			break
+		}
+		changes[len(changes)-1].MoreChanges = false
 	}
-	defer collect.Error(func() error { return res.Body.Close() }, &e)
-	return parseQueryResults(res.Body)
+	return changes, nil
 }
 
 func (g *Gerrit) ListOpenChangesByTopic(topic string) (CLList, error) {
@@ -388,63 +517,92 @@ func (g *Gerrit) GetChange(changeNumber int) (*Change, error) {
 	return &clList[0], nil
 }
 
-func (g *Gerrit) GetChangeURL(changeNumber int) string {
-	return fmt.Sprintf("%s/c/%d", g.host, changeNumber)
+// RelatedChange is a change in the relation chain of another change.
+type RelatedChange struct {
+	Project               string
+	Change_id             string
+	Commit                RelatedCommit
+	Number                int `json:"_change_number"`
+	RevisionNumber        int `json:"_revision_number"`
+	CurrentRevisionNumber int `json:"_current_revision_number"`
+	Status                string
+}
+type RelatedCommit struct {
+	Commit  string
+	Parents []RelatedCommit
+	Subject string
 }
 
-// Submit submits the given changelist through Gerrit.
-func (g *Gerrit) Submit(changeID string) (e error) {
-	cred, err := hostCredentials(g.s, g.host)
+// Reference returns the reference of the latest patchset of the change.
+func (c RelatedChange) Reference() string {
+	return ChangeReference(c.Number, c.CurrentRevisionNumber)
+}
+
+// ChangeReference returns the reference of the given patchset of the given
+// change.
+func ChangeReference(cl, patchset int) string {
+	return fmt.Sprintf("refs/changes/%02d/%d/%d", cl%100, cl, patchset)
+}
+
+// parseRelatedChanges parses the json result of a related changes request.
+func parseRelatedChanges(reader io.Reader) ([]RelatedChange, error) {
+	r := bufio.NewReader(reader)
+
+	// The first line of the input is the XSSI guard
+	// ")]}'". Getting rid of that.
+	if _, err := r.ReadSlice('\n'); err != nil {
+		return nil, err
+	}
+
+	var related struct {
+		Changes []RelatedChange
+	}
+	if err := json.NewDecoder(r).Decode(&related); err != nil {
+		return nil, fmt.Errorf("Decode() failed: %v", err)
+	}
+	return related.Changes, nil
+}
+
+// GetRelatedChanges returns the changes in the relation chain of the given
+// revision of the given change, from the newest descendant to the oldest
+// ancestor.  The change itself is part of the result.
+//
+// See https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#get-related-changes
+func (g *Gerrit) GetRelatedChanges(changeNumber int, revision string) ([]RelatedChange, error) {
+	if revision == "" {
+		revision = "current"
+	}
+	path := fmt.Sprintf("/changes/%d/revisions/%s/related", changeNumber, revision)
+	content, err := g.request("GET", path, nil, nil, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return parseRelatedChanges(bytes.NewReader(content))
+}
 
+func (g *Gerrit) GetChangeURL(changeNumber int) string {
+	return fmt.Sprintf("%s/c/%d", g.host, changeNumber)
+}
+
+// Submit submits the given changelist through Gerrit.
+func (g *Gerrit) Submit(changeID string) error {
 	// Encode data needed for Submit.
 	data := struct {
 		WaitForMerge bool `json:"wait_for_merge"`
 	}{
 		WaitForMerge: true,
 	}
-	encodedBytes, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("Marshal(%#v) failed: %v", data, err)
-	}
 
 	// Call Submit API.
 	// https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#submit-change
-	url := fmt.Sprintf("%s/a/changes/%s/submit", g.host, changeID)
-	var body io.Reader
-	method, body := "POST", bytes.NewReader(encodedBytes)
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return fmt.Errorf("NewRequest(%q, %q, %v) failed: %v", method, url, body, err)
-	}
-	req.Header.Add("Content-Type", "application/json;charset=UTF-8")
-	req.SetBasicAuth(cred.username, cred.password)
-
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("Do(%v) failed: %v", req, err)
-	}
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("Submit:Do(%v) failed: %v", req, res.StatusCode)
-	}
-	defer collect.Error(func() error { return res.Body.Close() }, &e)
-
-	// Check response.
-	bytes, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return err
-	}
-	resContent := string(bytes)
+	_, err := g.request("POST", fmt.Sprintf("/changes/%s/submit", changeID), nil, data, true)
 	// For a "TBR" CL, the response code is not 200 but the submit will still succeed.
 	// In those cases, the "error" message will be "change is new".
 	// We don't treat this case as error.
-	if res.StatusCode != http.StatusOK && strings.TrimSpace(resContent) != "change is new" {
-		return fmt.Errorf("Failed to submit CL %q:\n%s", changeID, resContent)
+	if e, ok := err.(*HTTPError); ok && strings.TrimSpace(e.Body) == "change is new" {
+		return nil
 	}
-
-	return nil
+	return err
 }
 
 // formatParams formats parameters of a change list.
@@ -6,9 +6,16 @@ package gerrit
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"fuchsia.googlesource.com/jiri/credentials"
 )
 
 func TestParseQueryResults(t *testing.T) {
@@ -36,9 +43,16 @@ func TestParseQueryResults(t *testing.T) {
 		{
 			"change_id": "I26f771cebd6e512b89e98bec1fadfa1cb2aad6e8",
 			"current_revision": "3654e38b2f80a5410ea94f1d7321477d89cac391",
+			"_number": 4440,
+			"status": "NEW",
+			"subject": "test change",
 			"labels": {
-				"Code-Review": {},
-				"Verified": {}
+				"Code-Review": {
+					"all": [{"value": 1}, {"value": 2}, {"value": 0}]
+				},
+				"Verified": {
+					"all": [{"value": 1}, {"value": -1}]
+				}
 			},
 			"project": "vanadium",
 			"owner": {
@@ -140,6 +154,18 @@ func TestParseQueryResults(t *testing.T) {
 			t.Fatalf("%d: want: %q, got: %q", i, want, got)
 		}
 	}
+	if got[0].Vote("Code-Review") != 0 || got[0].Vote("Verified") != 0 {
+		t.Errorf("unexpected votes on change without labels: %+v", got[0].Labels)
+	}
+	if got[1].Number != 4440 || got[1].Status != "NEW" || got[1].Subject != "test change" {
+		t.Errorf("unexpected change fields: %+v", got[1])
+	}
+	if want, got := 2, got[1].Vote("Code-Review"); want != got {
+		t.Errorf("Code-Review: want %d, got %d", want, got)
+	}
+	if want, got := -1, got[1].Vote("Verified"); want != got {
+		t.Errorf("Verified: want %d, got %d", want, got)
+	}
 }
 
 func TestParseMultiPartMatch(t *testing.T) {
@@ -208,108 +234,6 @@ func TestParseMultiPartMatch(t *testing.T) {
 	}
 }
 
-func TestParseValidGitCookieFile(t *testing.T) {
-	// Valid content.
-	gitCookieFileContent := `
-vanadium.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com=12345
-vanadium-review.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com=54321
-.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com=12321
-	`
-	got, err := parseGitCookieFile(strings.NewReader(gitCookieFileContent))
-	expected := map[string]*credentials{
-		"vanadium.googlesource.com": &credentials{
-			username: "git-johndoe.example.com",
-			password: "12345",
-		},
-		"vanadium-review.googlesource.com": &credentials{
-			username: "git-johndoe.example.com",
-			password: "54321",
-		},
-		".googlesource.com": &credentials{
-			username: "git-johndoe.example.com",
-			password: "12321",
-		},
-	}
-	if err != nil {
-		t.Fatalf("want no errors, got: %v", err)
-	}
-	if !reflect.DeepEqual(expected, got) {
-		t.Fatalf("want: %#v, got: %#v", expected, got)
-	}
-}
-
-func TestParseInvalidGitCookieFile(t *testing.T) {
-	// Content with invalid entries which should be skipped.
-	gitCookieFileContentWithInvalidEntries := `
-vanadium.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com
-vanadium-review.googlesource.com FALSE / TRUE 2147483647 o git-johndoe.example.com=54321
-vanadium.googlesource.com	FALSE	/	TRUE	2147483647	o	git-johndoe.example.com=12345
-vanadium-review.googlesource.com	FALSE	/	TRUE	2147483647	o
-	`
-	got, err := parseGitCookieFile(strings.NewReader(gitCookieFileContentWithInvalidEntries))
-	expected := map[string]*credentials{
-		"vanadium.googlesource.com": &credentials{
-			username: "git-johndoe.example.com",
-			password: "12345",
-		},
-	}
-	if err != nil {
-		t.Fatalf("want no errors, got: %v", err)
-	}
-	if !reflect.DeepEqual(expected, got) {
-		t.Fatalf("want: %#v, got: %#v", expected, got)
-	}
-}
-
-func TestParseValidNetRcFile(t *testing.T) {
-	// Valid content.
-	netrcFileContent := `
-machine vanadium.googlesource.com login git-johndoe.example.com password 12345
-machine vanadium-review.googlesource.com login git-johndoe.example.com password 54321
-	`
-	got, err := parseNetrcFile(strings.NewReader(netrcFileContent))
-	expected := map[string]*credentials{
-		"vanadium.googlesource.com": &credentials{
-			username: "git-johndoe.example.com",
-			password: "12345",
-		},
-		"vanadium-review.googlesource.com": &credentials{
-			username: "git-johndoe.example.com",
-			password: "54321",
-		},
-	}
-	if err != nil {
-		t.Fatalf("want no errors, got: %v", err)
-	}
-	if !reflect.DeepEqual(expected, got) {
-		t.Fatalf("want: %#v, got: %#v", expected, got)
-	}
-}
-
-func TestParseInvalidNetRcFile(t *testing.T) {
-	// Content with invalid entries which should be skipped.
-	netRcFileContentWithInvalidEntries := `
-machine vanadium.googlesource.com login git-johndoe.example.com password
-machine_blah vanadium3.googlesource.com login git-johndoe.example.com password 12345
-machine vanadium2.googlesource.com login_blah git-johndoe.example.com password 12345
-machine vanadium4.googlesource.com login git-johndoe.example.com password_blah 12345
-machine vanadium-review.googlesource.com login git-johndoe.example.com password 54321
-	`
-	got, err := parseNetrcFile(strings.NewReader(netRcFileContentWithInvalidEntries))
-	expected := map[string]*credentials{
-		"vanadium-review.googlesource.com": &credentials{
-			username: "git-johndoe.example.com",
-			password: "54321",
-		},
-	}
-	if err != nil {
-		t.Fatalf("want no errors, got: %v", err)
-	}
-	if !reflect.DeepEqual(expected, got) {
-		t.Fatalf("want: %#v, got: %#v", expected, got)
-	}
-}
-
 func TestParseRefString(t *testing.T) {
 	type testCase struct {
 		ref              string
@@ -358,5 +282,206 @@ func TestParseRefString(t *testing.T) {
 	}
 }
 
-// TODO(jsimsa): Add a test for the hostCredentials function that
-// exercises the logic that reads the .netrc and git cookie files.
+func TestParseRelatedChanges(t *testing.T) {
+	input := `)]}'
+	{
+		"changes": [
+			{
+				"project": "vanadium",
+				"change_id": "I5e1ae37ba2ce3d5c5d7b2c4e1d1c6a9a3d2f5b4e",
+				"commit": {
+					"commit": "b5e1ae37ba2ce3d5c5d7b2c4e1d1c6a9a3d2f5b4",
+					"parents": [{"commit": "a3654e38b2f80a5410ea94f1d7321477d89cac39"}],
+					"subject": "second"
+				},
+				"_change_number": 4441,
+				"_revision_number": 1,
+				"_current_revision_number": 1,
+				"status": "NEW"
+			},
+			{
+				"project": "vanadium",
+				"change_id": "I26f771cebd6e512b89e98bec1fadfa1cb2aad6e8",
+				"commit": {
+					"commit": "a3654e38b2f80a5410ea94f1d7321477d89cac39",
+					"parents": [{"commit": "0000000000000000000000000000000000000000"}],
+					"subject": "first"
+				},
+				"_change_number": 4440,
+				"_revision_number": 1,
+				"_current_revision_number": 3,
+				"status": "NEW"
+			}
+		]
+	}
+	`
+	got, err := parseRelatedChanges(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", got)
+	}
+	if c := got[0]; c.Number != 4441 || c.Commit.Subject != "second" || len(c.Commit.Parents) != 1 || c.Commit.Parents[0].Commit != got[1].Commit.Commit {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	if c := got[1]; c.Number != 4440 || c.RevisionNumber != 1 || c.CurrentRevisionNumber != 3 || c.Status != "NEW" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	if got, want := got[1].Reference(), "refs/changes/40/4440/3"; got != want {
+		t.Errorf("got reference %q, want %q", got, want)
+	}
+	if got, want := ChangeReference(7, 2), "refs/changes/07/7/2"; got != want {
+		t.Errorf("got reference %q, want %q", got, want)
+	}
+}
+
+// newTestGerrit returns a Gerrit client for a server running handler, which
+// authenticates with a bearer token.  Retries are not delayed.
+func newTestGerrit(t *testing.T, handler http.HandlerFunc) (*Gerrit, func()) {
+	server := httptest.NewServer(handler)
+	host, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, hadToken := os.LookupEnv(credentials.TokenEnv)
+	os.Setenv(credentials.TokenEnv, "token")
+	oldInterval := retryInterval
+	retryInterval = 0
+	return &Gerrit{host: host}, func() {
+		retryInterval = oldInterval
+		if hadToken {
+			os.Setenv(credentials.TokenEnv, oldToken)
+		} else {
+			os.Unsetenv(credentials.TokenEnv)
+		}
+		server.Close()
+	}
+}
+
+func TestQueryPagination(t *testing.T) {
+	var starts []string
+	g, cleanup := newTestGerrit(t, func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer token"; got != want {
+			t.Errorf("got Authorization header %q, want %q", got, want)
+		}
+		if r.URL.Path != "/a/changes/" {
+			http.NotFound(w, r)
+			return
+		}
+		start := r.URL.Query().Get("S")
+		starts = append(starts, start)
+		switch start {
+		case "":
+			fmt.Fprint(w, ")]}'\n"+`[{"_number": 1}, {"_number": 2, "_more_changes": true}]`)
+		case "2":
+			fmt.Fprint(w, ")]}'\n"+`[{"_number": 3, "submittable": true, "mergeable": true, "reviewers": {"REVIEWER": [{"email": "jane@example.com"}]}, "messages": [{"id": "m1", "author": {"email": "jane@example.com"}, "message": "LGTM", "_revision_number": 1}]}]`)
+		default:
+			t.Errorf("unexpected start %q", start)
+		}
+	})
+	defer cleanup()
+
+	changes, err := g.Query("topic:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := starts, []string{"", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got starts %v, want %v", got, want)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %+v", changes)
+	}
+	for i, c := range changes {
+		if c.Number != i+1 || c.MoreChanges {
+			t.Errorf("unexpected change %d: %+v", i, c)
+		}
+	}
+	c := changes[2]
+	if !c.Submittable || !c.Mergeable || len(c.Reviewers["REVIEWER"]) != 1 || c.Reviewers["REVIEWER"][0].Email != "jane@example.com" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+	if len(c.Messages) != 1 || c.Messages[0].Message != "LGTM" || c.Messages[0].RevisionNumber != 1 || c.Messages[0].Author.Email != "jane@example.com" {
+		t.Errorf("unexpected messages: %+v", c.Messages)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	requests := map[string]int{}
+	g, cleanup := newTestGerrit(t, func(w http.ResponseWriter, r *http.Request) {
+		requests[r.Method+" "+r.URL.Path]++
+		switch {
+		case r.URL.Path == "/a/changes/":
+			if requests[r.Method+" "+r.URL.Path] < requestAttempts {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, ")]}'\n[]")
+		case strings.HasSuffix(r.URL.Path, "/related"):
+			http.NotFound(w, r)
+		case strings.HasSuffix(r.URL.Path, "/submit"):
+			http.Error(w, "change is merged", http.StatusConflict)
+		case r.URL.Path == "/a/changes/1/revisions/1/review":
+			http.Error(w, "not permitted", http.StatusForbidden)
+		default:
+			http.Error(w, "server error", http.StatusInternalServerError)
+		}
+	})
+	defer cleanup()
+
+	// Server errors are retried.
+	if _, err := g.Query("status:open"); err != nil {
+		t.Errorf("Query failed: %v", err)
+	}
+	if got, want := requests["GET /a/changes/"], requestAttempts; got != want {
+		t.Errorf("got %d query requests, want %d", got, want)
+	}
+	// Client errors are not.
+	if _, err := g.GetRelatedChanges(1, ""); !IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if got := requests["GET /a/changes/1/revisions/current/related"]; got != 1 {
+		t.Errorf("got %d related requests, want 1", got)
+	}
+	err := g.Submit("1")
+	if !IsConflict(err) || !strings.Contains(err.Error(), "change is merged") {
+		t.Errorf("expected conflict error, got %v", err)
+	}
+	if got := requests["POST /a/changes/1/submit"]; got != 1 {
+		t.Errorf("got %d submit requests, want 1", got)
+	}
+	if err := g.PostReview("refs/changes/01/1/1", "", nil); !IsForbidden(err) || IsUnauthorized(err) {
+		t.Errorf("expected forbidden error, got %v", err)
+	}
+	// PUT requests are idempotent, and retried on server errors.
+	if err := g.SetTopic("1", CLOpts{Topic: "test"}); err == nil {
+		t.Errorf("SetTopic did not fail")
+	}
+	if got, want := requests["PUT /a/changes/1/topic"], requestAttempts; got != want {
+		t.Errorf("got %d topic requests, want %d", got, want)
+	}
+	// POST requests are not idempotent, and not retried even on server
+	// errors.
+	if err := g.PostReview("refs/changes/02/2/1", "", nil); err == nil {
+		t.Errorf("PostReview did not fail")
+	}
+	if got := requests["POST /a/changes/2/revisions/1/review"]; got != 1 {
+		t.Errorf("got %d review requests, want 1", got)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	g, cleanup := newTestGerrit(t, func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	})
+	defer cleanup()
+	defer close(done)
+	oldClient := httpClient
+	httpClient = &http.Client{Timeout: 10 * time.Millisecond}
+	defer func() { httpClient = oldClient }()
+
+	if _, err := g.GetRelatedChanges(1, ""); err == nil {
+		t.Errorf("expected a timeout")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ErrorKind classifies the cause of a failed git command.
+type ErrorKind int
+
+const (
+	ErrorUnknown ErrorKind = iota
+	// ErrorNetwork means the remote could not be reached or the connection
+	// was dropped.
+	ErrorNetwork
+	// ErrorAuth means the remote rejected our credentials.
+	ErrorAuth
+	// ErrorServer means the remote answered with a 5xx status.
+	ErrorServer
+	// ErrorCorrupt means a corrupt or missing object was found.
+	ErrorCorrupt
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorNetwork:
+		return "network"
+	case ErrorAuth:
+		return "auth"
+	case ErrorServer:
+		return "server"
+	case ErrorCorrupt:
+		return "corrupt object"
+	}
+	return "unknown"
+}
+
+// Transient returns true if retrying the command may succeed.
+func (k ErrorKind) Transient() bool {
+	return k == ErrorNetwork || k == ErrorServer
+}
+
+var (
+	authErrorRE    = regexp.MustCompile(`authentication failed|could not read (username|password)|invalid (username|credentials)|permission denied|access denied|returned error: 40[13]|http 40[13]`)
+	serverErrorRE  = regexp.MustCompile(`returned error: 5[0-9][0-9]|http 5[0-9][0-9]|internal server error|bad gateway|service unavailable|gateway time-?out`)
+	corruptErrorRE = regexp.MustCompile(`is corrupt|bad object|corrupt (loose|packed) object|object file .* is empty|invalid sha1 pointer|did not send all necessary objects`)
+	networkErrorRE = regexp.MustCompile(`could not resolve host|connection (refused|reset|timed out)|operation timed out|network is unreachable|failed to connect|early eof|the remote end hung up unexpectedly|rpc failed|unexpected disconnect|ssl_error|gnutls|tls connection`)
+)
+
+// Kind classifies the error based on what git printed to stderr.
+func (ge GitError) Kind() ErrorKind {
+	out := strings.ToLower(ge.ErrorOutput)
+	switch {
+	case authErrorRE.MatchString(out):
+		return ErrorAuth
+	case serverErrorRE.MatchString(out):
+		return ErrorServer
+	case corruptErrorRE.MatchString(out):
+		return ErrorCorrupt
+	case networkErrorRE.MatchString(out):
+		return ErrorNetwork
+	}
+	return ErrorUnknown
+}
+
+// ClassifyError returns the kind of err if it is a GitError, and ErrorUnknown
+// otherwise.
+func ClassifyError(err error) ErrorKind {
+	switch e := err.(type) {
+	case GitError:
+		return e.Kind()
+	case *GitError:
+		return e.Kind()
+	}
+	return ErrorUnknown
+}
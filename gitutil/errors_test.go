@@ -0,0 +1,70 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		stderr string
+		kind   ErrorKind
+	}{
+		// Network errors.
+		{"fatal: unable to access 'https://fuchsia.googlesource.com/jiri/': Could not resolve host: fuchsia.googlesource.com\n", ErrorNetwork},
+		{"fatal: unable to access 'https://fuchsia.googlesource.com/jiri/': Failed to connect to fuchsia.googlesource.com port 443: Connection refused\n", ErrorNetwork},
+		{"fatal: unable to access 'https://fuchsia.googlesource.com/jiri/': Operation timed out after 300000 milliseconds with 0 out of 0 bytes received\n", ErrorNetwork},
+		{"error: RPC failed; curl 56 GnuTLS recv error (-54): Error in the pull function.\nfatal: The remote end hung up unexpectedly\nfatal: early EOF\nfatal: index-pack failed\n", ErrorNetwork},
+		{"error: RPC failed; curl 18 transfer closed with outstanding read data remaining\nfatal: the remote end hung up unexpectedly\n", ErrorNetwork},
+		{"ssh: connect to host github.com port 22: Network is unreachable\nfatal: Could not read from remote repository.\n", ErrorNetwork},
+		{"fatal: unable to access 'https://fuchsia.googlesource.com/jiri/': OpenSSL SSL_connect: SSL_ERROR_SYSCALL in connection to fuchsia.googlesource.com:443\n", ErrorNetwork},
+		// Authentication errors.
+		{"remote: Invalid username or password.\nfatal: Authentication failed for 'https://github.com/owner/repo.git/'\n", ErrorAuth},
+		{"fatal: could not read Username for 'https://fuchsia.googlesource.com': terminal prompts disabled\n", ErrorAuth},
+		{"git@github.com: Permission denied (publickey).\nfatal: Could not read from remote repository.\n", ErrorAuth},
+		{"fatal: unable to access 'https://fuchsia.googlesource.com/jiri/': The requested URL returned error: 403\n", ErrorAuth},
+		// Server errors.
+		{"fatal: unable to access 'https://fuchsia.googlesource.com/jiri/': The requested URL returned error: 502\n", ErrorServer},
+		{"error: RPC failed; HTTP 503 curl 22 The requested URL returned error: 503\nfatal: the remote end hung up unexpectedly\n", ErrorServer},
+		{"remote: Internal Server Error\nfatal: unable to access 'https://fuchsia.googlesource.com/jiri/': The requested URL returned error: 500\n", ErrorServer},
+		// Corrupt objects.
+		{"error: object file .git/objects/3d/3f2b8c0a7e1f4e8f0f1e2c9d8b7a6f5e4d3c2b1a is empty\nfatal: loose object 3d3f2b8c0a7e1f4e8f0f1e2c9d8b7a6f5e4d3c2b1a (stored in .git/objects/3d/3f2b8c0a) is corrupt\n", ErrorCorrupt},
+		{"fatal: bad object HEAD\n", ErrorCorrupt},
+		{"error: refs/heads/master: invalid sha1 pointer 0000000000000000000000000000000000000000\n", ErrorCorrupt},
+		{"fatal: remote did not send all necessary objects\n", ErrorCorrupt},
+		// Other errors.
+		{"error: pathspec 'foo' did not match any file(s) known to git\n", ErrorUnknown},
+		{"fatal: couldn't find remote ref refs/heads/nonexistent\n", ErrorUnknown},
+		{"", ErrorUnknown},
+	}
+	for _, test := range tests {
+		err := Error("", test.stderr, "fetch", "origin")
+		if got := ClassifyError(err); got != test.kind {
+			t.Errorf("ClassifyError(%q) = %v, want %v", test.stderr, got, test.kind)
+		}
+		if got := ClassifyError(&err); got != test.kind {
+			t.Errorf("ClassifyError(&%q) = %v, want %v", test.stderr, got, test.kind)
+		}
+	}
+	if got := ClassifyError(errors.New("could not resolve host")); got != ErrorUnknown {
+		t.Errorf("ClassifyError of a non-git error = %v, want %v", got, ErrorUnknown)
+	}
+}
+
+func TestErrorKindTransient(t *testing.T) {
+	for kind, want := range map[ErrorKind]bool{
+		ErrorUnknown: false,
+		ErrorNetwork: true,
+		ErrorAuth:    false,
+		ErrorServer:  true,
+		ErrorCorrupt: false,
+	} {
+		if got := kind.Transient(); got != want {
+			t.Errorf("%v.Transient() = %v, want %v", kind, got, want)
+		}
+	}
+}
@@ -116,6 +116,19 @@ func (g *Git) AddRemote(name, path string) error {
 	return g.run("remote", "add", name, path)
 }
 
+// Am applies the given mailbox patches on top of the current branch,
+// falling back to a three-way merge if they do not apply cleanly.
+func (g *Git) Am(patches ...string) error {
+	args := []string{"am", "--3way"}
+	args = append(args, patches...)
+	return g.run(args...)
+}
+
+// AmAbort aborts an in-progress am operation.
+func (g *Git) AmAbort() error {
+	return g.run("am", "--abort")
+}
+
 // BranchExists tests whether a branch with the given name exists in
 // the local repository.
 func (g *Git) BranchExists(branch string) bool {
@@ -432,6 +445,11 @@ func (g *Git) CherryPick(rev string) error {
 	return err
 }
 
+// CherryPickAbort aborts an in-progress cherry-pick operation.
+func (g *Git) CherryPickAbort() error {
+	return g.run("cherry-pick", "--abort")
+}
+
 // DeleteBranch deletes the given branch.
 func (g *Git) DeleteBranch(branch string, opts ...DeleteBranchOpt) error {
 	args := []string{"branch"}
@@ -451,6 +469,20 @@ func (g *Git) DeleteBranch(branch string, opts ...DeleteBranchOpt) error {
 	return g.run(args...)
 }
 
+// Diff returns the diff between the given revisions, or between the
+// working tree and the given revision if only one is given.  Paths in the
+// diff are prefixed with <prefix>.
+func (g *Git) Diff(prefix string, revs ...string) (string, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff", "--src-prefix=a/" + prefix, "--dst-prefix=b/" + prefix}
+	args = append(args, revs...)
+	args = append(args, "--")
+	var stdout, stderr bytes.Buffer
+	if err := g.runGit(&stdout, &stderr, args...); err != nil {
+		return "", Error(stdout.String(), stderr.String(), args...)
+	}
+	return stdout.String(), nil
+}
+
 // DirExistsOnBranch returns true if a directory with the given name
 // exists on the branch.  If branch is empty it defaults to "master".
 func (g *Git) DirExistsOnBranch(dir, branch string) bool {
@@ -531,6 +563,12 @@ func (g *Git) FilesWithUncommittedChanges() ([]string, error) {
 	return append(out, out2...), nil
 }
 
+// FormatPatch writes the commits on <branch> that are not on <base> to
+// <outDir> as mailbox patches, and returns the paths of the patch files.
+func (g *Git) FormatPatch(branch, base, outDir string) ([]string, error) {
+	return g.runOutput("format-patch", "--no-color", "-o", outDir, fmt.Sprintf("%v..%v", base, branch), "--")
+}
+
 // GetBranches returns a slice of the local branches of the current
 // repository, followed by the name of the current branch. The
 // behavior can be customized by providing optional arguments
@@ -572,12 +610,22 @@ func (g *Git) ListBranchesContainingRef(commit string) (map[string]bool, error)
 }
 
 // Grep searches for matching text and returns a list of lines from
-// `git grep`.
-func (g *Git) Grep(query string) ([]string, error) {
-	// TODO(ianloic): handle patterns that start with "-"
-	// TODO(ianloic): handle different pattern types (-i, -P, -E, etc)
-	// TODO(ianloic): handle different response types (--full-name, -v, --name-only, etc)
-	return g.runOutput("grep", query)
+// `git grep`.  The flags are passed to `git grep`, and the search is
+// restricted to pathSpecs if any are given.  It is not an error if nothing
+// matches.
+func (g *Git) Grep(query string, pathSpecs []string, flags ...string) ([]string, error) {
+	args := append([]string{"grep"}, flags...)
+	args = append(args, "-e", query)
+	if len(pathSpecs) > 0 {
+		args = append(args, "--")
+		args = append(args, pathSpecs...)
+	}
+	lines, err := g.runOutput(args...)
+	if gitErr, ok := err.(GitError); ok && gitErr.ErrorOutput == "" {
+		// git grep exits with status 1 when nothing matches.
+		return nil, nil
+	}
+	return lines, err
 }
 
 // Init initializes a new git repository.
@@ -615,22 +663,34 @@ func (g *Git) LatestCommitMessage() (string, error) {
 }
 
 // Log returns a list of commits on <branch> that are not on <base>,
-// using the specified format.
-func (g *Git) Log(branch, base, format string) ([][]string, error) {
-	n, err := g.CountCommits(branch, base)
-	if err != nil {
-		return nil, err
+// using the specified format.  If <base> is empty, all commits on <branch>
+// are listed.
+func (g *Git) Log(branch, base, format string, opts ...LogOpt) ([][]string, error) {
+	args := []string{"log", "-z", fmt.Sprintf("--format=%s", format)}
+	for _, opt := range opts {
+		switch typedOpt := opt.(type) {
+		case SinceOpt:
+			args = append(args, fmt.Sprintf("--since=%s", typedOpt))
+		case AuthorOpt:
+			args = append(args, fmt.Sprintf("--author=%s", typedOpt))
+		}
+	}
+	if base != "" {
+		args = append(args, fmt.Sprintf("%v..%v", base, branch))
+	} else {
+		args = append(args, branch)
+	}
+	args = append(args, "--")
+	var stdout, stderr bytes.Buffer
+	if err := g.runGit(&stdout, &stderr, args...); err != nil {
+		return nil, Error(stdout.String(), stderr.String(), args...)
 	}
 	result := [][]string{}
-	for i := 0; i < n; i++ {
-		skipArg := fmt.Sprintf("--skip=%d", i)
-		formatArg := fmt.Sprintf("--format=%s", format)
-		branchArg := fmt.Sprintf("%v..%v", base, branch)
-		out, err := g.runOutput("log", "-1", skipArg, formatArg, branchArg)
-		if err != nil {
-			return nil, err
+	// With -z, commits are separated by NUL characters.
+	for _, commit := range strings.Split(stdout.String(), "\x00") {
+		if out := trimOutput(commit); out != nil {
+			result = append(result, out)
 		}
-		result = append(result, out)
 	}
 	return result, nil
 }
@@ -711,6 +771,7 @@ func (g *Git) Pull(remote, branch string) error {
 func (g *Git) Push(remote, branch string, opts ...PushOpt) error {
 	args := []string{"push"}
 	force := false
+	forceWithLease := false
 	verify := true
 	// TODO(youngseokyoon): consider making followTags option default to true, after verifying that
 	// it works well for the madb repository.
@@ -719,6 +780,8 @@ func (g *Git) Push(remote, branch string, opts ...PushOpt) error {
 		switch typedOpt := opt.(type) {
 		case ForceOpt:
 			force = bool(typedOpt)
+		case ForceWithLeaseOpt:
+			forceWithLease = bool(typedOpt)
 		case VerifyOpt:
 			verify = bool(typedOpt)
 		case FollowTagsOpt:
@@ -727,6 +790,8 @@ func (g *Git) Push(remote, branch string, opts ...PushOpt) error {
 	}
 	if force {
 		args = append(args, "--force")
+	} else if forceWithLease {
+		args = append(args, "--force-with-lease")
 	}
 	if verify {
 		args = append(args, "--verify")
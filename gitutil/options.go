@@ -21,6 +21,9 @@ type DeleteBranchOpt interface {
 type FetchOpt interface {
 	fetchOpt()
 }
+type LogOpt interface {
+	logOpt()
+}
 type MergeOpt interface {
 	mergeOpt()
 }
@@ -41,6 +44,12 @@ func (ForceOpt) checkoutOpt()     {}
 func (ForceOpt) deleteBranchOpt() {}
 func (ForceOpt) pushOpt()         {}
 
+// ForceWithLeaseOpt forces a push only if the remote branch is still at the
+// revision of its remote-tracking branch.
+type ForceWithLeaseOpt bool
+
+func (ForceWithLeaseOpt) pushOpt() {}
+
 type DetachOpt bool
 
 func (DetachOpt) checkoutOpt() {}
@@ -102,3 +111,11 @@ type NoCheckoutOpt bool
 func (NoCheckoutOpt) cloneOpt() {}
 
 func (DepthOpt) cloneOpt() {}
+
+type SinceOpt string
+
+func (SinceOpt) logOpt() {}
+
+type AuthorOpt string
+
+func (AuthorOpt) logOpt() {}
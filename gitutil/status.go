@@ -0,0 +1,353 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Status is the state of a working tree, as reported by
+// "git status --porcelain=v2 --branch".
+type Status struct {
+	// Head is the revision of HEAD, empty if there are no commits yet.
+	Head string
+	// Branch is the current branch, empty if HEAD is detached.
+	Branch string
+	// Upstream is the tracking branch of Branch, if any.
+	Upstream string
+	// Ahead and Behind are the number of commits on Branch which are not
+	// on Upstream, and the other way around.
+	Ahead, Behind int
+	// StashCount is the number of stash entries.
+	StashCount int
+	// Changed are the paths with staged or unstaged changes.
+	Changed []string
+	// Untracked are the untracked paths.
+	Untracked []string
+	// Conflicted are the paths with unresolved conflicts.
+	Conflicted []string
+	// Submodules are the submodules which differ from HEAD.
+	Submodules []SubmoduleStatus
+
+	hasStashHeader bool
+}
+
+// SubmoduleStatus describes how a submodule differs from HEAD.
+type SubmoduleStatus struct {
+	Path string
+	// CommitChanged is true if the submodule is at another commit than the
+	// one recorded in HEAD.
+	CommitChanged bool
+	// Modified is true if the submodule has changes to tracked files.
+	Modified bool
+	// Untracked is true if the submodule has untracked files.
+	Untracked bool
+}
+
+// Status returns the state of the working tree, listing untracked files if
+// untracked is true.
+func (g *Git) Status(untracked bool) (*Status, error) {
+	untrackedArg := "--untracked-files=no"
+	if untracked {
+		untrackedArg = "--untracked-files=normal"
+	}
+	args := []string{"status", "--porcelain=v2", "--branch", "--show-stash", "-z", untrackedArg}
+	var stdout, stderr bytes.Buffer
+	if err := g.runGit(&stdout, &stderr, args...); err != nil {
+		return nil, Error(stdout.String(), stderr.String(), args...)
+	}
+	s, err := parseStatus(stdout.String())
+	if err != nil {
+		return nil, err
+	}
+	if !s.hasStashHeader && !g.statusShowsStash() {
+		if s.StashCount, err = g.stashCount(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+var (
+	statusShowsStashOnce sync.Once
+	statusShowsStash     bool
+)
+
+// statusShowsStash returns true if git status prints the "# stash" header,
+// which was added to the porcelain v2 format in git 2.35.  The header is
+// omitted when there are no stash entries.
+func (g *Git) statusShowsStash() bool {
+	statusShowsStashOnce.Do(func() {
+		major, minor, err := g.Version()
+		statusShowsStash = err == nil && (major > 2 || major == 2 && minor >= 35)
+	})
+	return statusShowsStash
+}
+
+// stashCount returns the number of stash entries.
+func (g *Git) stashCount() (int, error) {
+	out, err := g.runOutput("rev-list", "--walk-reflogs", "--count", "--ignore-missing", "refs/stash")
+	if err != nil {
+		return 0, err
+	}
+	if len(out) != 1 {
+		return 0, fmt.Errorf("unexpected git rev-list output %q", out)
+	}
+	count, err := strconv.Atoi(out[0])
+	if err != nil {
+		return 0, fmt.Errorf("unexpected git rev-list output %q", out)
+	}
+	return count, nil
+}
+
+// parseStatus parses the output of
+// "git status --porcelain=v2 --branch --show-stash -z".
+func parseStatus(out string) (*Status, error) {
+	s := &Status{}
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if entry == "" {
+			continue
+		}
+		var path, sub string
+		switch entry[0] {
+		case '#':
+			if err := s.parseHeader(entry); err != nil {
+				return nil, err
+			}
+			continue
+		case '1':
+			fields := strings.SplitN(entry, " ", 9)
+			if len(fields) != 9 {
+				return nil, fmt.Errorf("unexpected git status entry %q", entry)
+			}
+			sub, path = fields[2], fields[8]
+		case '2':
+			fields := strings.SplitN(entry, " ", 10)
+			if len(fields) != 10 {
+				return nil, fmt.Errorf("unexpected git status entry %q", entry)
+			}
+			sub, path = fields[2], fields[9]
+			// The original path of a rename or copy is the next entry.
+			i++
+		case 'u':
+			fields := strings.SplitN(entry, " ", 11)
+			if len(fields) != 11 {
+				return nil, fmt.Errorf("unexpected git status entry %q", entry)
+			}
+			s.Conflicted = append(s.Conflicted, fields[10])
+			continue
+		case '?':
+			s.Untracked = append(s.Untracked, strings.TrimPrefix(entry, "? "))
+			continue
+		case '!':
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected git status entry %q", entry)
+		}
+		if len(sub) == 4 && sub[0] == 'S' {
+			sm := SubmoduleStatus{
+				Path:          path,
+				CommitChanged: sub[1] == 'C',
+				Modified:      sub[2] == 'M',
+				Untracked:     sub[3] == 'U',
+			}
+			s.Submodules = append(s.Submodules, sm)
+			// Changes inside a submodule are not changes of this
+			// repository.
+			if !sm.CommitChanged && !sm.Modified {
+				continue
+			}
+		}
+		s.Changed = append(s.Changed, path)
+	}
+	return s, nil
+}
+
+func (s *Status) parseHeader(header string) error {
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return nil
+	}
+	switch fields[1] {
+	case "branch.oid":
+		if fields[2] != "(initial)" {
+			s.Head = fields[2]
+		}
+	case "branch.head":
+		if fields[2] != "(detached)" {
+			s.Branch = fields[2]
+		}
+	case "branch.upstream":
+		s.Upstream = fields[2]
+	case "branch.ab":
+		if len(fields) != 4 {
+			return fmt.Errorf("unexpected git status header %q", header)
+		}
+		var err error
+		if s.Ahead, err = strconv.Atoi(strings.TrimPrefix(fields[2], "+")); err != nil {
+			return fmt.Errorf("unexpected git status header %q", header)
+		}
+		if s.Behind, err = strconv.Atoi(strings.TrimPrefix(fields[3], "-")); err != nil {
+			return fmt.Errorf("unexpected git status header %q", header)
+		}
+	case "stash":
+		var err error
+		if s.StashCount, err = strconv.Atoi(fields[2]); err != nil {
+			return fmt.Errorf("unexpected git status header %q", header)
+		}
+		s.hasStashHeader = true
+	}
+	return nil
+}
+
+// BranchInfo describes a local branch.
+type BranchInfo struct {
+	Name     string
+	Revision string
+	IsHead   bool
+	// Upstream and UpstreamRevision describe the tracking branch.  They are
+	// empty if the branch does not track anything, or if its tracking
+	// branch does not exist.
+	Upstream         string
+	UpstreamRevision string
+	// Ahead and Behind are the number of commits on the branch which are
+	// not on its tracking branch, and the other way around.
+	Ahead, Behind int
+}
+
+// BranchesInfo returns the local branches, with their tracking branches,
+// using a single "git for-each-ref".
+func (g *Git) BranchesInfo() ([]BranchInfo, error) {
+	format := "%(refname)%00%(objectname)%00%(HEAD)%00%(upstream)%00%(upstream:short)%00%(upstream:track,nobracket)"
+	out, err := g.runOutput("for-each-ref", "--format="+format, "refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	revisions := map[string]string{}
+	var branches []BranchInfo
+	var upstreams []string
+	for _, line := range out {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("unexpected git for-each-ref output %q", line)
+		}
+		revisions[fields[0]] = fields[1]
+		if !strings.HasPrefix(fields[0], "refs/heads/") {
+			continue
+		}
+		b := BranchInfo{
+			Name:     strings.TrimPrefix(fields[0], "refs/heads/"),
+			Revision: fields[1],
+			IsHead:   fields[2] == "*",
+			Upstream: fields[4],
+		}
+		for _, track := range strings.Split(fields[5], ", ") {
+			var err error
+			if strings.HasPrefix(track, "ahead ") {
+				b.Ahead, err = strconv.Atoi(strings.TrimPrefix(track, "ahead "))
+			} else if strings.HasPrefix(track, "behind ") {
+				b.Behind, err = strconv.Atoi(strings.TrimPrefix(track, "behind "))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unexpected git for-each-ref output %q", line)
+			}
+		}
+		branches = append(branches, b)
+		upstreams = append(upstreams, fields[3])
+	}
+	for i := range branches {
+		rev, ok := revisions[upstreams[i]]
+		if !ok {
+			// The tracking branch is gone.
+			branches[i].Upstream = ""
+			continue
+		}
+		branches[i].UpstreamRevision = rev
+	}
+	return branches, nil
+}
+
+// Operations which can be in progress in a repository.
+const (
+	OperationRebase     = "rebase"
+	OperationAm         = "am"
+	OperationMerge      = "merge"
+	OperationCherryPick = "cherry-pick"
+	OperationRevert     = "revert"
+	OperationBisect     = "bisect"
+)
+
+// OperationInProgress returns the operation which is in progress in the
+// repository, or "" if there is none.
+func (g *Git) OperationInProgress() (string, error) {
+	gitDir, err := g.gitDir()
+	if err != nil {
+		return "", err
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(gitDir, name))
+		return err == nil
+	}
+	switch {
+	case exists("rebase-merge"):
+		return OperationRebase, nil
+	case exists("rebase-apply"):
+		if exists(filepath.Join("rebase-apply", "applying")) {
+			return OperationAm, nil
+		}
+		return OperationRebase, nil
+	case exists("MERGE_HEAD"):
+		return OperationMerge, nil
+	case exists("CHERRY_PICK_HEAD"):
+		return OperationCherryPick, nil
+	case exists("REVERT_HEAD"):
+		return OperationRevert, nil
+	case exists("BISECT_LOG"):
+		return OperationBisect, nil
+	}
+	return "", nil
+}
+
+// gitDir returns the git directory of the repository, following the
+// "gitdir:" indirection of worktrees and submodules.
+func (g *Git) gitDir() (string, error) {
+	root := g.rootDir
+	if root == "" {
+		var err error
+		if root, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	dotGit := filepath.Join(root, ".git")
+	fi, err := os.Stat(dotGit)
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return dotGit, nil
+	}
+	data, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return "", err
+	}
+	dir := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(dir, "gitdir: ") {
+		return "", fmt.Errorf("unexpected content of %q: %q", dotGit, dir)
+	}
+	dir = strings.TrimPrefix(dir, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir, nil
+}
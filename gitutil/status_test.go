@@ -0,0 +1,136 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	hash1 = "78981922613b2afb6025042ff6bd878ac1994e85"
+	hash2 = "61780798228d17af2d34fce4cfbdf35556832472"
+)
+
+// statusOutput joins entries as "git status -z" does.
+func statusOutput(entries ...string) string {
+	return strings.Join(entries, "\x00") + "\x00"
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want Status
+	}{
+		{
+			"clean",
+			statusOutput(
+				"# branch.oid "+hash1,
+				"# branch.head master",
+				"# branch.upstream origin/master",
+				"# branch.ab +0 -0",
+			),
+			Status{Head: hash1, Branch: "master", Upstream: "origin/master"},
+		},
+		{
+			"no commits",
+			statusOutput("# branch.oid (initial)", "# branch.head master"),
+			Status{Branch: "master"},
+		},
+		{
+			"detached",
+			statusOutput("# branch.oid "+hash1, "# branch.head (detached)"),
+			Status{Head: hash1},
+		},
+		{
+			"ahead, behind and stashed",
+			statusOutput(
+				"# branch.oid "+hash1,
+				"# branch.head feature",
+				"# branch.upstream origin/master",
+				"# branch.ab +2 -13",
+				"# stash 3",
+			),
+			Status{Head: hash1, Branch: "feature", Upstream: "origin/master", Ahead: 2, Behind: 13, StashCount: 3, hasStashHeader: true},
+		},
+		{
+			"changes",
+			statusOutput(
+				"# branch.oid "+hash1,
+				"# branch.head master",
+				"1 .M N... 100644 100644 100644 "+hash1+" "+hash1+" a",
+				"1 A. N... 000000 100644 100644 0000000000000000000000000000000000000000 "+hash2+" new",
+				"1 .M N... 100644 100644 100644 "+hash2+" "+hash2+" with space",
+				"2 R. N... 100644 100644 100644 "+hash2+" "+hash2+" R100 renamed",
+				"b",
+				"? untracked",
+				"? dir/",
+			),
+			Status{
+				Head:      hash1,
+				Branch:    "master",
+				Changed:   []string{"a", "new", "with space", "renamed"},
+				Untracked: []string{"untracked", "dir/"},
+			},
+		},
+		{
+			"conflicts",
+			statusOutput(
+				"# branch.oid "+hash1,
+				"# branch.head master",
+				"u UU N... 100644 100644 100644 100644 "+hash1+" "+hash2+" "+hash2+" f",
+			),
+			Status{Head: hash1, Branch: "master", Conflicted: []string{"f"}},
+		},
+		{
+			"submodules",
+			statusOutput(
+				"# branch.oid "+hash1,
+				"# branch.head master",
+				"1 .M SC.. 160000 160000 160000 "+hash1+" "+hash1+" moved",
+				"1 .M S.M. 160000 160000 160000 "+hash1+" "+hash1+" modified",
+				"1 .M S..U 160000 160000 160000 "+hash1+" "+hash1+" untracked",
+			),
+			Status{
+				Head:    hash1,
+				Branch:  "master",
+				Changed: []string{"moved", "modified"},
+				Submodules: []SubmoduleStatus{
+					{Path: "moved", CommitChanged: true},
+					{Path: "modified", Modified: true},
+					{Path: "untracked", Untracked: true},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := parseStatus(test.out)
+		if err != nil {
+			t.Errorf("%s: parseStatus failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+}
+
+func TestParseStatusErrors(t *testing.T) {
+	for _, out := range []string{
+		statusOutput("# branch.ab +1"),
+		statusOutput("# branch.ab +x -1"),
+		statusOutput("# stash many"),
+		statusOutput("1 .M N... a"),
+		statusOutput("2 R. N... 100644 100644 100644 " + hash1 + " " + hash1 + " renamed"),
+		statusOutput("u UU N... f"),
+		statusOutput("x unknown"),
+	} {
+		if _, err := parseStatus(out); err == nil {
+			t.Errorf("parseStatus(%q) did not fail", out)
+		}
+	}
+}
@@ -13,14 +13,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
-	"time"
 
 	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/credentials"
 )
 
 // RepoStatus represents the status of a remote repository on googlesource.
@@ -34,74 +31,6 @@ type RepoStatus struct {
 // RepoStatuses is a map of repository name to RepoStatus.
 type RepoStatuses map[string]RepoStatus
 
-// parseCookie takes a single line from a cookie jar and parses it, returning
-// an *http.Cookie.
-func parseCookie(s string) (*http.Cookie, error) {
-	// Cookiejar files have 7 tab-delimited fields.
-	// See http://curl.haxx.se/mail/archive-2005-03/0099.html
-	// 0: domain
-	// 1: tailmatch
-	// 2: path
-	// 3: secure
-	// 4: expires
-	// 5: name
-	// 6: value
-
-	fields := strings.Fields(s)
-	if len(fields) != 7 {
-		return nil, fmt.Errorf("expected 7 fields but got %d: %q", len(fields), s)
-	}
-	expires, err := strconv.Atoi(fields[4])
-	if err != nil {
-		return nil, fmt.Errorf("invalid expiration: %q", fields[4])
-	}
-
-	cookie := &http.Cookie{
-		Domain:  fields[0],
-		Path:    fields[2],
-		Secure:  fields[3] == "TRUE",
-		Expires: time.Unix(int64(expires), 0),
-		Name:    fields[5],
-		Value:   fields[6],
-	}
-	return cookie, nil
-}
-
-// gitCookies attempts to read and parse cookies from the .gitcookies file in
-// the users home directory.
-func gitCookies(jirix *jiri.X) []*http.Cookie {
-	cookies := []*http.Cookie{}
-
-	homeDir := os.Getenv("HOME")
-	if homeDir == "" {
-		return cookies
-	}
-
-	cookieFile := filepath.Join(homeDir, ".gitcookies")
-	bytes, err := jirix.NewSeq().ReadFile(cookieFile)
-	if err != nil {
-		return cookies
-	}
-	return parseCookieFile(jirix, bytes)
-}
-
-func parseCookieFile(jirix *jiri.X, bytes []byte) (cookies []*http.Cookie) {
-	lines := strings.Split(string(bytes), "\n")
-
-	for _, line := range lines {
-		if strings.TrimSpace(line) == "" || line[0] == '#' {
-			continue
-		}
-		cookie, err := parseCookie(line)
-		if err != nil {
-			fmt.Fprintf(jirix.Stderr(), "error parsing cookie in .gitcookies: %v\n", err)
-		} else {
-			cookies = append(cookies, cookie)
-		}
-	}
-	return
-}
-
 // GetRepoStatuses returns the RepoStatus of all public projects hosted on the
 // remote host.  Host must be a googlesource host.
 //
@@ -136,9 +65,7 @@ func GetRepoStatuses(jirix *jiri.X, host string, branches []string) (RepoStatuse
 	if err != nil {
 		return nil, fmt.Errorf("NewRequest(%q, %q, %v) failed: %v", "GET", u.String(), nil, err)
 	}
-	for _, c := range gitCookies(jirix) {
-		req.AddCookie(c)
-	}
+	credentials.Authenticate(req)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Do(%v) failed: %v", req, err)
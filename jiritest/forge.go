@@ -0,0 +1,280 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiritest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// FakeForge is an in-process forge taking pull requests for tests.  It serves
+// the part of a GitHub-style REST API the review package uses.  Repositories
+// are named by their absolute path on the local file system without the
+// leading slash, e.g. "tmp/remote/project", and pull request branches are
+// pushed to them directly.
+type FakeForge struct {
+	// URL is the base URL of the REST API.
+	URL string
+
+	server *httptest.Server
+
+	mu    sync.Mutex
+	pulls []*FakePullRequest
+}
+
+// FakePullRequest is a pull request of a FakeForge.
+type FakePullRequest struct {
+	Number     int
+	Repository string
+	Title      string
+	Body       string
+	// Head is the branch the pull request is made from, and Base the branch
+	// it is meant for.
+	Head      string
+	Base      string
+	State     string
+	Merged    bool
+	Reviewers []string
+}
+
+type fakePullRequestJSON struct {
+	Number  int        `json:"number"`
+	State   string     `json:"state"`
+	Merged  bool       `json:"merged"`
+	Title   string     `json:"title"`
+	Body    string     `json:"body"`
+	HTMLURL string     `json:"html_url"`
+	Head    fakeBranch `json:"head"`
+	Base    fakeBranch `json:"base"`
+}
+
+type fakeBranch struct {
+	Ref string `json:"ref"`
+}
+
+// NewFakeForge starts a FakeForge and returns it with a closure stopping
+// it.
+func NewFakeForge(t *testing.T) (*FakeForge, func()) {
+	f := &FakeForge{}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	f.URL = f.server.URL
+	return f, f.server.Close
+}
+
+// PullRequests returns the pull requests of the forge, in the order they
+// were opened.
+func (f *FakeForge) PullRequests() []FakePullRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pulls := []FakePullRequest{}
+	for _, pr := range f.pulls {
+		pulls = append(pulls, *pr)
+	}
+	return pulls
+}
+
+// Merge merges pull request number: its head is fast-forwarded into its
+// base.
+func (f *FakeForge) Merge(number int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pr := f.find(number)
+	if pr == nil {
+		return fmt.Errorf("no pull request %d", number)
+	}
+	repo := "/" + pr.Repository
+	out, err := runGit(repo, "rev-parse", "refs/heads/"+pr.Head)
+	if err != nil {
+		return err
+	}
+	if err := fastForward(repo, pr.Base, strings.TrimSpace(out)); err != nil {
+		return err
+	}
+	pr.State, pr.Merged = "closed", true
+	return nil
+}
+
+// Close closes pull request number without merging it.
+func (f *FakeForge) Close(number int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if pr := f.find(number); pr != nil {
+		pr.State = "closed"
+	}
+}
+
+func (f *FakeForge) find(number int) *FakePullRequest {
+	for _, pr := range f.pulls {
+		if pr.Number == number {
+			return pr
+		}
+	}
+	return nil
+}
+
+// toJSON returns pr as the REST API shows it, and points refs/pull/<n>/head
+// of its repository to its head, as forges do.
+func (f *FakeForge) toJSON(pr *FakePullRequest) (fakePullRequestJSON, error) {
+	repo := "/" + pr.Repository
+	if !pr.Merged {
+		if _, err := runGit(repo, "update-ref", fmt.Sprintf("refs/pull/%d/head", pr.Number), "refs/heads/"+pr.Head); err != nil {
+			return fakePullRequestJSON{}, err
+		}
+	}
+	return fakePullRequestJSON{
+		Number:  pr.Number,
+		State:   pr.State,
+		Merged:  pr.Merged,
+		Title:   pr.Title,
+		Body:    pr.Body,
+		HTMLURL: fmt.Sprintf("%s/%s/pull/%d", f.URL, pr.Repository, pr.Number),
+		Head:    fakeBranch{pr.Head},
+		Base:    fakeBranch{pr.Base},
+	}, nil
+}
+
+func (f *FakeForge) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Paths have the form /repos/<repository>/pulls[/<number>[/<action>]].
+	i := strings.LastIndex(r.URL.Path, "/pulls")
+	if !strings.HasPrefix(r.URL.Path, "/repos/") || i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	repo := strings.TrimPrefix(r.URL.Path[:i], "/repos/")
+	rest := strings.Split(strings.Trim(r.URL.Path[i+len("/pulls"):], "/"), "/")
+	if rest[0] == "" {
+		switch r.Method {
+		case "GET":
+			f.serveList(w, r, repo)
+		case "POST":
+			f.serveCreate(w, r, repo)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	number, err := strconv.Atoi(rest[0])
+	pr := f.find(number)
+	if err != nil || pr == nil || pr.Repository != repo {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(rest) == 1 && r.Method == "GET":
+		f.writePullRequest(w, http.StatusOK, pr)
+	case len(rest) == 2 && rest[1] == "requested_reviewers" && r.Method == "POST":
+		var data struct {
+			Reviewers []string `json:"reviewers"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	next:
+		for _, reviewer := range data.Reviewers {
+			for _, existing := range pr.Reviewers {
+				if existing == reviewer {
+					continue next
+				}
+			}
+			pr.Reviewers = append(pr.Reviewers, reviewer)
+		}
+		f.writePullRequest(w, http.StatusCreated, pr)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *FakeForge) serveList(w http.ResponseWriter, r *http.Request, repo string) {
+	q := r.URL.Query()
+	state := q.Get("state")
+	if state == "" {
+		state = "open"
+	}
+	// head has the form <owner>:<branch>.
+	head := q.Get("head")
+	if i := strings.Index(head, ":"); i >= 0 {
+		head = head[i+1:]
+	}
+	pulls := []fakePullRequestJSON{}
+	for _, pr := range f.pulls {
+		if pr.Repository != repo || (state != "all" && pr.State != state) || (head != "" && pr.Head != head) || (q.Get("base") != "" && pr.Base != q.Get("base")) {
+			continue
+		}
+		data, err := f.toJSON(pr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pulls = append(pulls, data)
+	}
+	writeForgeJSON(w, http.StatusOK, pulls)
+}
+
+func (f *FakeForge) serveCreate(w http.ResponseWriter, r *http.Request, repo string) {
+	var data struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+		Head  string `json:"head"`
+		Base  string `json:"base"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.Title == "" || data.Head == "" || data.Base == "" {
+		http.Error(w, "title, head and base are required", http.StatusUnprocessableEntity)
+		return
+	}
+	if _, err := runGit("/"+repo, "rev-parse", "--verify", "refs/heads/"+data.Head); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	for _, pr := range f.pulls {
+		if pr.Repository == repo && pr.Head == data.Head && pr.State == "open" {
+			http.Error(w, "a pull request already exists for "+data.Head, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	pr := &FakePullRequest{
+		Number:     len(f.pulls) + 1,
+		Repository: repo,
+		Title:      data.Title,
+		Body:       data.Body,
+		Head:       data.Head,
+		Base:       data.Base,
+		State:      "open",
+	}
+	f.pulls = append(f.pulls, pr)
+	f.writePullRequest(w, http.StatusCreated, pr)
+}
+
+func (f *FakeForge) writePullRequest(w http.ResponseWriter, status int, pr *FakePullRequest) {
+	data, err := f.toJSON(pr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeForgeJSON(w, status, data)
+}
+
+func writeForgeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,610 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jiritest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unicode"
+
+	"fuchsia.googlesource.com/jiri/gerrit"
+)
+
+var changeIdRE = regexp.MustCompile(`(?m)^Change-Id: (I[0-9a-f]+)\s*$`)
+
+// commitMsgHook is the commit-msg hook served by FakeGerrit.  It adds a
+// Change-Id footer to commit messages which do not have one.
+const commitMsgHook = `#!/bin/sh
+grep -q '^Change-Id: ' "$1" && exit 0
+id=$(cat "$1" | git hash-object --stdin)
+printf '\nChange-Id: I%s\n' "$id" >> "$1"
+`
+
+// FakeGerrit is an in-process Gerrit instance for tests.  It serves the REST
+// endpoints the gerrit package uses from the changes it knows about, and git
+// over HTTP for the repositories on the local file system: pushing a commit
+// to <URL>/<path of a repository> with a refs/for/<branch> reference creates
+// or updates a change, as it does with Gerrit.
+type FakeGerrit struct {
+	// URL is the base URL of the instance.
+	URL string
+	// PageSize is the maximum number of changes returned for a query, or 0
+	// for no limit.
+	PageSize int
+
+	t      *testing.T
+	server *httptest.Server
+	git    http.Handler
+
+	mu      sync.Mutex
+	changes []*fakeChange
+	related map[int][]gerrit.RelatedChange
+	pushes  []FakePush
+	reviews []FakeReview
+	queries []string
+}
+
+// FakePush is a push to a refs/for/ or refs/drafts/ reference.
+type FakePush struct {
+	// Repository is the path of the repository pushed to.
+	Repository string
+	// Ref is the reference pushed to, e.g. "refs/for/master%topic=foo".
+	Ref      string
+	Revision string
+}
+
+// FakeReview is a review posted on a change.
+type FakeReview struct {
+	Change   int
+	Revision string
+	gerrit.Review
+}
+
+type fakeChange struct {
+	gerrit.Change
+	// repo is the repository the change was pushed to, or "" if it was
+	// added with AddChange.
+	repo string
+}
+
+// NewFakeGerrit starts a FakeGerrit and returns it with a closure stopping
+// it.
+func NewFakeGerrit(t *testing.T) (*FakeGerrit, func()) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &FakeGerrit{
+		t:       t,
+		related: map[int][]gerrit.RelatedChange{},
+		git: &cgi.Handler{
+			Path: gitPath,
+			Args: []string{"http-backend"},
+			// Repositories are named by their absolute path, and everyone
+			// may push to them.
+			Env: []string{"GIT_PROJECT_ROOT=/", "GIT_HTTP_EXPORT_ALL=1", "REMOTE_USER=fake"},
+		},
+	}
+	g.server = httptest.NewServer(http.HandlerFunc(g.serveHTTP))
+	g.URL = g.server.URL
+	return g, g.server.Close
+}
+
+// AddChange adds c to the changes of the instance and returns it.  Unless
+// they are set, c is given the next change number, the status "NEW" and a
+// single revision.
+func (g *FakeGerrit) AddChange(c gerrit.Change) gerrit.Change {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c.Number == 0 {
+		c.Number = g.nextNumber()
+	}
+	if c.Status == "" {
+		c.Status = "NEW"
+	}
+	if c.Change_id == "" {
+		c.Change_id = fmt.Sprintf("I%040x", c.Number)
+	}
+	if c.Current_revision == "" {
+		c.Current_revision = fmt.Sprintf("%040x", c.Number)
+	}
+	if c.Revisions == nil {
+		c.Revisions = gerrit.Revisions{}
+	}
+	if _, ok := c.Revisions[c.Current_revision]; !ok {
+		rev := gerrit.Revision{}
+		rev.Fetch.Http.Ref = gerrit.ChangeReference(c.Number, len(c.Revisions)+1)
+		rev.Commit.Message = c.Subject
+		c.Revisions[c.Current_revision] = rev
+	}
+	g.changes = append(g.changes, &fakeChange{Change: c})
+	return c
+}
+
+// Change returns the change with the given number.
+func (g *FakeGerrit) Change(number int) (gerrit.Change, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c := g.findChange(strconv.Itoa(number)); c != nil {
+		return c.Change, true
+	}
+	return gerrit.Change{}, false
+}
+
+// Changes returns all the changes of the instance, in the order they were
+// created.
+func (g *FakeGerrit) Changes() []gerrit.Change {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	changes := []gerrit.Change{}
+	for _, c := range g.changes {
+		changes = append(changes, c.Change)
+	}
+	return changes
+}
+
+// SetRelatedChanges sets the relation chain returned for the change with
+// the given number.
+func (g *FakeGerrit) SetRelatedChanges(number int, related []gerrit.RelatedChange) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.related[number] = related
+}
+
+// Pushes returns the pushes to refs/for/ and refs/drafts/ references.
+func (g *FakeGerrit) Pushes() []FakePush {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]FakePush(nil), g.pushes...)
+}
+
+// Reviews returns the reviews posted on changes.
+func (g *FakeGerrit) Reviews() []FakeReview {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]FakeReview(nil), g.reviews...)
+}
+
+// Queries returns the queries the instance answered.
+func (g *FakeGerrit) Queries() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.queries...)
+}
+
+func (g *FakeGerrit) nextNumber() int {
+	number := 1
+	for _, c := range g.changes {
+		if c.Number >= number {
+			number = c.Number + 1
+		}
+	}
+	return number
+}
+
+// findChange returns the change with the given number or Change-Id, or nil
+// if there is none.
+func (g *FakeGerrit) findChange(id string) *fakeChange {
+	for _, c := range g.changes {
+		if strconv.Itoa(c.Number) == id || c.Change_id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (g *FakeGerrit) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/tools/hooks/commit-msg":
+		io.WriteString(w, commitMsgHook)
+		return
+	case r.URL.Query().Get("service") != "" || strings.HasSuffix(path, "/git-upload-pack") || strings.HasSuffix(path, "/git-receive-pack"):
+		g.git.ServeHTTP(w, r)
+		if strings.HasSuffix(path, "/git-receive-pack") {
+			if err := g.receivePushes(strings.TrimSuffix(path, "/git-receive-pack")); err != nil {
+				// The push itself succeeded; there is no way to report the
+				// error to git at this point.
+				g.t.Errorf("FakeGerrit: %v", err)
+			}
+		}
+		return
+	}
+
+	// Authenticated requests are prefixed with /a/.
+	if strings.HasPrefix(path, "/a/") {
+		path = "/" + strings.TrimPrefix(path, "/a/")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if path == "/changes/" && r.Method == "GET" {
+		g.serveQuery(w, r)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/changes/"), "/")
+	if !strings.HasPrefix(path, "/changes/") || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	c := g.findChange(parts[0])
+	if c == nil {
+		http.Error(w, "Not found: "+parts[0], http.StatusNotFound)
+		return
+	}
+	switch action := strings.Join(parts[1:], "/"); {
+	case r.Method == "GET" && (action == "" || action == "detail"):
+		writeJSON(w, c.Change)
+	case action == "topic":
+		g.serveTopic(w, r, c)
+	case r.Method == "POST" && action == "submit":
+		g.serveSubmit(w, c)
+	case r.Method == "POST" && len(parts) == 4 && parts[1] == "revisions" && parts[3] == "review":
+		g.serveReview(w, r, c, parts[2])
+	case r.Method == "GET" && len(parts) == 4 && parts[1] == "revisions" && parts[3] == "related":
+		writeJSON(w, struct {
+			Changes []gerrit.RelatedChange `json:"changes"`
+		}{g.related[c.Number]})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON writes v as JSON, preceded by the XSSI guard Gerrit uses.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	fmt.Fprintf(w, ")]}'\n%s\n", data)
+}
+
+func (g *FakeGerrit) serveQuery(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	g.queries = append(g.queries, query)
+	clauses, err := parseQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	changes := []gerrit.Change{}
+	// Gerrit returns the most recently updated changes first.
+	for i := len(g.changes) - 1; i >= 0; i-- {
+		if c := g.changes[i]; clauses.match(&c.Change) {
+			changes = append(changes, c.Change)
+		}
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("S"))
+	if start > len(changes) {
+		start = len(changes)
+	}
+	changes = changes[start:]
+	limit := g.PageSize
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && (limit == 0 || n < limit) {
+		limit = n
+	}
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+		changes[limit-1].MoreChanges = true
+	}
+	writeJSON(w, changes)
+}
+
+func (g *FakeGerrit) serveTopic(w http.ResponseWriter, r *http.Request, c *fakeChange) {
+	switch r.Method {
+	case "GET":
+	case "PUT":
+		var topic gerrit.Topic
+		if err := json.NewDecoder(r.Body).Decode(&topic); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.Topic = topic.Topic
+	case "DELETE":
+		c.Topic = ""
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.Topic == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, c.Topic)
+}
+
+func (g *FakeGerrit) serveReview(w http.ResponseWriter, r *http.Request, c *fakeChange, revision string) {
+	var review gerrit.Review
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.reviews = append(g.reviews, FakeReview{c.Number, revision, review})
+	if review.Message != "" {
+		c.Messages = append(c.Messages, gerrit.ChangeMessage{
+			Id:      strconv.Itoa(len(c.Messages) + 1),
+			Message: review.Message,
+		})
+	}
+	for label, value := range review.Labels {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid value %q for label %q", value, label), http.StatusBadRequest)
+			return
+		}
+		if c.Labels == nil {
+			c.Labels = map[string]map[string]interface{}{}
+		}
+		if c.Labels[label] == nil {
+			c.Labels[label] = map[string]interface{}{}
+		}
+		all, _ := c.Labels[label]["all"].([]interface{})
+		c.Labels[label]["all"] = append(all, map[string]interface{}{"value": float64(v)})
+	}
+	writeJSON(w, review)
+}
+
+// serveSubmit merges c.  Changes which were pushed are fast-forwarded into
+// their branch.
+func (g *FakeGerrit) serveSubmit(w http.ResponseWriter, c *fakeChange) {
+	if c.Status != "NEW" {
+		http.Error(w, "change is "+strings.ToLower(c.Status), http.StatusConflict)
+		return
+	}
+	if c.repo != "" {
+		if err := fastForward(c.repo, c.Branch, c.Current_revision); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+	c.Status = "MERGED"
+	writeJSON(w, c.Change)
+}
+
+// receivePushes turns the refs/for/ and refs/drafts/ references of repo into
+// changes, and deletes them so that they can be pushed to again.
+func (g *FakeGerrit) receivePushes(repo string) error {
+	out, err := runGit(repo, "for-each-ref", "--format=%(objectname) %(refname)", "refs/for/", "refs/drafts/")
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		revision, ref := fields[0], fields[1]
+		g.pushes = append(g.pushes, FakePush{repo, ref, revision})
+		if err := g.createChanges(repo, ref, revision); err != nil {
+			return err
+		}
+		if _, err := runGit(repo, "update-ref", "-d", ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createChanges creates or updates a change for every commit pushed to ref
+// which is not in the target branch yet.
+func (g *FakeGerrit) createChanges(repo, ref, revision string) error {
+	target := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/for/"), "refs/drafts/")
+	branch, topic := target, ""
+	if i := strings.Index(target, "%"); i >= 0 {
+		branch = target[:i]
+		for _, param := range strings.Split(target[i+1:], ",") {
+			if strings.HasPrefix(param, "topic=") {
+				topic = strings.TrimPrefix(param, "topic=")
+			}
+		}
+	}
+	out, err := runGit(repo, "rev-list", "--reverse", revision, "--not", "refs/heads/"+branch)
+	if err != nil {
+		return err
+	}
+	for _, commit := range strings.Fields(out) {
+		info, err := runGit(repo, "log", "-1", "--format=%an%x00%ae%x00%s%x00%B", commit)
+		if err != nil {
+			return err
+		}
+		fields := strings.SplitN(info, "\x00", 4)
+		if len(fields) != 4 {
+			return fmt.Errorf("unexpected log output %q", info)
+		}
+		message := fields[3]
+		changeId := "I" + commit
+		if match := changeIdRE.FindStringSubmatch(message); match != nil {
+			changeId = match[1]
+		}
+		var c *fakeChange
+		for _, existing := range g.changes {
+			if existing.repo == repo && existing.Branch == branch && existing.Change_id == changeId {
+				c = existing
+				break
+			}
+		}
+		if c == nil {
+			c = &fakeChange{repo: repo}
+			c.Number = g.nextNumber()
+			c.Change_id = changeId
+			c.Project = strings.TrimPrefix(repo, "/")
+			c.Branch = branch
+			c.Status = "NEW"
+			c.Revisions = gerrit.Revisions{}
+			g.changes = append(g.changes, c)
+		}
+		if _, ok := c.Revisions[commit]; ok {
+			continue
+		}
+		rev := gerrit.Revision{}
+		rev.Fetch.Http.Ref = gerrit.ChangeReference(c.Number, len(c.Revisions)+1)
+		rev.Commit.Message = message
+		if _, err := runGit(repo, "update-ref", rev.Fetch.Http.Ref, commit); err != nil {
+			return err
+		}
+		c.Revisions[commit] = rev
+		c.Current_revision = commit
+		c.Owner = gerrit.Owner{Name: fields[0], Email: fields[1]}
+		c.Subject = fields[2]
+		if topic != "" {
+			c.Topic = topic
+		}
+	}
+	return nil
+}
+
+// fastForward moves branch of repo to revision, updating the working tree if
+// the branch is checked out.
+func fastForward(repo, branch, revision string) error {
+	if _, err := runGit(repo, "merge-base", "--is-ancestor", "refs/heads/"+branch, revision); err != nil {
+		return fmt.Errorf("change cannot be merged into %s: %v", branch, err)
+	}
+	if current, err := runGit(repo, "symbolic-ref", "--short", "HEAD"); err == nil && strings.TrimSpace(current) == branch {
+		_, err := runGit(repo, "merge", "--ff-only", revision)
+		return err
+	}
+	_, err := runGit(repo, "update-ref", "refs/heads/"+branch, revision)
+	return err
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// queryClauses is a parsed query: every clause must match, and a clause
+// matches if one of its terms does.
+type queryClauses [][]string
+
+// parseQuery parses the subset of the Gerrit query syntax FakeGerrit
+// supports: terms separated by spaces or "AND", alternatives joined by "OR",
+// and parentheses, which are ignored.
+func parseQuery(query string) (queryClauses, error) {
+	var clauses queryClauses
+	or := false
+	for _, term := range splitQuery(query) {
+		switch {
+		case term == "AND":
+		case term == "OR":
+			if len(clauses) == 0 {
+				return nil, fmt.Errorf("unexpected OR in query %q", query)
+			}
+			or = true
+		default:
+			if err := checkTerm(term); err != nil {
+				return nil, err
+			}
+			if or {
+				clauses[len(clauses)-1] = append(clauses[len(clauses)-1], term)
+			} else {
+				clauses = append(clauses, []string{term})
+			}
+			or = false
+		}
+	}
+	return clauses, nil
+}
+
+// splitQuery splits query into terms.  Quoted values may contain spaces.
+func splitQuery(query string) []string {
+	var terms []string
+	var term []rune
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (unicode.IsSpace(r) || r == '(' || r == ')'):
+			if len(term) > 0 {
+				terms = append(terms, string(term))
+				term = nil
+			}
+		default:
+			term = append(term, r)
+		}
+	}
+	if len(term) > 0 {
+		terms = append(terms, string(term))
+	}
+	return terms
+}
+
+func checkTerm(term string) error {
+	if !strings.Contains(term, ":") {
+		return nil
+	}
+	switch strings.SplitN(term, ":", 2)[0] {
+	case "status", "is", "owner", "topic", "change", "project", "branch":
+		return nil
+	}
+	return fmt.Errorf("unsupported query term %q", term)
+}
+
+func (clauses queryClauses) match(c *gerrit.Change) bool {
+	for _, clause := range clauses {
+		matched := false
+		for _, term := range clause {
+			if matchTerm(c, term) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTerm(c *gerrit.Change, term string) bool {
+	if !strings.Contains(term, ":") {
+		term = "change:" + term
+	}
+	parts := strings.SplitN(term, ":", 2)
+	switch op, value := parts[0], parts[1]; op {
+	case "status", "is":
+		switch value {
+		case "open":
+			return c.Status == "NEW"
+		case "closed":
+			return c.Status != "NEW"
+		case "owner":
+			return true
+		}
+		return strings.EqualFold(c.Status, value)
+	case "owner":
+		// All changes belong to the user running the test.
+		return value == "self" || value == c.Owner.Email
+	case "topic":
+		return c.Topic == value
+	case "change":
+		return strconv.Itoa(c.Number) == value || c.Change_id == value
+	case "project":
+		return c.Project == value
+	case "branch":
+		return c.Branch == value
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ProjectFilter selects the projects an update applies to.  A nil
+// ProjectFilter selects all projects.
+type ProjectFilter []*regexp.Regexp
+
+// NewProjectFilter returns a filter selecting the projects whose name matches
+// one of the given patterns.  A pattern is either a project name or a regular
+// expression which has to match the whole name.
+func NewProjectFilter(patterns []string) (ProjectFilter, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	filter := make(ProjectFilter, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regexp %v: %v", pattern, err)
+		}
+		filter = append(filter, re)
+	}
+	return filter, nil
+}
+
+// MatchName returns true if the project with the given name is selected.
+func (f ProjectFilter) MatchName(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// projects returns the selected projects.
+func (f ProjectFilter) projects(ps Projects) Projects {
+	if f == nil {
+		return ps
+	}
+	result := make(Projects)
+	for key, p := range ps {
+		if f.MatchName(p.Name) {
+			result[key] = p
+		}
+	}
+	return result
+}
+
+// hooks returns the hooks belonging to the selected projects.
+func (f ProjectFilter) hooks(hooks Hooks) Hooks {
+	if f == nil {
+		return hooks
+	}
+	result := make(Hooks)
+	for key, h := range hooks {
+		if f.MatchName(h.ProjectName) {
+			result[key] = h
+		}
+	}
+	return result
+}
@@ -4,5 +4,21 @@
 
 package project
 
+import (
+	"fuchsia.googlesource.com/jiri"
+)
+
 // InternalWriteMetadata exports writeMetadata for tests.
 var InternalWriteMetadata = writeMetadata
+
+// InternalWriteMoveJournal writes the journal of an update that was
+// interrupted while moving project p from source to p.Path.
+func InternalWriteMoveJournal(jirix *jiri.X, p Project, source string) error {
+	op := moveOperation{commonOperation: commonOperation{
+		project:     p,
+		source:      source,
+		destination: p.Path,
+	}}
+	_, err := newUpdateJournal(jirix, []moveOperation{op}, nil)
+	return err
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"fuchsia.googlesource.com/jiri"
+)
+
+// journalEntry records an operation that leaves the workspace in a partially
+// updated state if "jiri update" is interrupted while running it.
+type journalEntry struct {
+	Kind        string   `xml:"kind,attr"`
+	Source      string   `xml:"source,attr,omitempty"`
+	Destination string   `xml:"destination,attr"`
+	Done        bool     `xml:"done,attr,omitempty"`
+	Project     Project  `xml:"project"`
+	XMLName     struct{} `xml:"operation"`
+}
+
+// updateJournal is written to the root metadata directory before the
+// operations of an update are executed, and removed once they all completed
+// or failed cleanly.  Finding it at the beginning of an update means that the
+// previous update was interrupted.
+type updateJournal struct {
+	Entries []journalEntry `xml:"operation"`
+	XMLName struct{}       `xml:"journal"`
+
+	file  string
+	mu    sync.Mutex
+	index map[ProjectKey]int
+}
+
+// newUpdateJournal returns a journal for the given move and create operations
+// and writes it to disk.  A nil journal is returned if there is nothing to
+// record.
+func newUpdateJournal(jirix *jiri.X, moves []moveOperation, creates []createOperation) (*updateJournal, error) {
+	if len(moves) == 0 && len(creates) == 0 {
+		return nil, nil
+	}
+	j := &updateJournal{
+		file:  jirix.UpdateJournalFile(),
+		index: make(map[ProjectKey]int),
+	}
+	for _, op := range moves {
+		j.add(op, op.source)
+	}
+	for _, op := range creates {
+		j.add(op, "")
+	}
+	if err := j.write(jirix); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *updateJournal) add(op operation, source string) {
+	j.index[op.Project().Key()] = len(j.Entries)
+	j.Entries = append(j.Entries, journalEntry{
+		Kind:        op.Kind(),
+		Source:      source,
+		Destination: op.Project().Path,
+		Project:     op.Project(),
+	})
+}
+
+// write writes the journal to disk.  The caller must hold j.mu.
+func (j *updateJournal) write(jirix *jiri.X) error {
+	data, err := xml.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("journal xml.Marshal failed: %v", err)
+	}
+	return safeWriteFile(jirix, j.file, append(data, '\n'))
+}
+
+// markDone records that the operation for project p has completed.
+func (j *updateJournal) markDone(jirix *jiri.X, p Project) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	i, ok := j.index[p.Key()]
+	if !ok {
+		return nil
+	}
+	j.Entries[i].Done = true
+	if err := j.write(jirix); err != nil {
+		return fmt.Errorf("cannot update journal %q: %v", j.file, err)
+	}
+	return nil
+}
+
+// close deletes the journal unless a move was started but not completed,
+// which only happens if the update is killed while moving a project or fails
+// after renaming its directory.  Operations which failed cleanly are retried
+// by the next update and need no recovery.
+func (j *updateJournal) close(jirix *jiri.X) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range j.Entries {
+		if e.Kind == "move" && !e.Done && e.Source != e.Destination && isPathDir(e.Destination) {
+			return nil
+		}
+	}
+	return jirix.NewSeq().RemoveAll(j.file).Done()
+}
+
+// RecoverUpdate checks whether a previous update was interrupted, and if so
+// brings the workspace back into a consistent state.  If resume is true,
+// projects which were already moved are kept at their new location, otherwise
+// incomplete moves are rolled back.  In both cases leftovers of interrupted
+// project creations are removed.
+func RecoverUpdate(jirix *jiri.X, resume bool) error {
+	file := jirix.UpdateJournalFile()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	j := new(updateJournal)
+	if err := xml.Unmarshal(data, j); err != nil {
+		return fmt.Errorf("invalid update journal %s: %v", file, err)
+	}
+	if resume {
+		jirix.Logger.Warningf("Previous update was interrupted, resuming it\n\n")
+	} else {
+		jirix.Logger.Warningf("Previous update was interrupted, rolling back its incomplete moves.\nRun %s to keep them instead.\n\n", jirix.Color.Yellow("jiri update -resume"))
+	}
+	for _, e := range j.Entries {
+		if e.Done {
+			continue
+		}
+		switch e.Kind {
+		case "move":
+			if err := recoverMove(jirix, e, resume); err != nil {
+				return err
+			}
+		case "create":
+			if err := removeCreateLeftovers(jirix, e); err != nil {
+				return err
+			}
+		}
+	}
+	return jirix.NewSeq().RemoveAll(file).Done()
+}
+
+// recoverMove rolls back or completes an interrupted move.
+func recoverMove(jirix *jiri.X, e journalEntry, resume bool) error {
+	if e.Source == e.Destination || !isPathDir(e.Destination) {
+		// The project was never moved, the next update will move it.
+		return nil
+	}
+	if p, err := ProjectAtPath(jirix, e.Destination); err == nil && p.Path == e.Destination {
+		// The move completed but was not recorded as such.
+		return nil
+	}
+	if resume {
+		jirix.Logger.Debugf("recording project %q at %q", e.Project.Name, e.Destination)
+		e.Project.Path = e.Destination
+		return writeMetadata(jirix, e.Project, e.Destination)
+	}
+	if _, err := os.Stat(e.Source); err == nil {
+		jirix.Logger.Warningf("Cannot move project %q back from %q to %q as the source already exists\n\n", e.Project.Name, e.Destination, e.Source)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	jirix.Logger.Debugf("moving project %q back from %q to %q", e.Project.Name, e.Destination, e.Source)
+	return jirix.NewSeq().
+		MkdirAll(filepath.Dir(e.Source), 0755).
+		Rename(e.Destination, e.Source).
+		Done()
+}
+
+// removeCreateLeftovers removes the temporary directories created by an
+// interrupted createOperation.
+func removeCreateLeftovers(jirix *jiri.X, e journalEntry) error {
+	parent := filepath.Dir(e.Destination)
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(createTmpDirPrefix(e.Project)) + "[0-9]+$")
+	fileInfos, err := ioutil.ReadDir(parent)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() || !re.MatchString(fileInfo.Name()) {
+			continue
+		}
+		dir := filepath.Join(parent, fileInfo.Name())
+		if p, err := ProjectAtPath(jirix, dir); err == nil && p.Path == dir {
+			// This is a real project that just happens to have a similar name.
+			continue
+		}
+		jirix.Logger.Debugf("removing %q left over from creating project %q", dir, e.Project.Name)
+		if err := jirix.NewSeq().RemoveAll(dir).Done(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTmpDirPrefix returns the prefix of the temporary directory used by
+// createOperation for project p.
+func createTmpDirPrefix(p Project) string {
+	return strings.Replace(p.Name, "/", ".", -1) + "-"
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"fuchsia.googlesource.com/jiri"
+)
+
+// PatchedBranch is a branch created by "jiri patch".
+type PatchedBranch struct {
+	// Name is the name of the branch.
+	Name string `xml:"name,attr"`
+	// Ref is the Gerrit reference of the change patched into the branch.
+	Ref string `xml:"ref,attr,omitempty"`
+	// Revision is the revision the branch pointed to once patched.
+	Revision string   `xml:"revision,attr,omitempty"`
+	XMLName  struct{} `xml:"branch"`
+}
+
+// PatchedBranches records the branches "jiri patch" created in a project, so
+// that "jiri patch -cleanup" can delete them.
+type PatchedBranches struct {
+	Branches []PatchedBranch `xml:"branch"`
+	XMLName  struct{}        `xml:"patches"`
+}
+
+// Add records b, replacing any branch with the same name.
+func (pb *PatchedBranches) Add(b PatchedBranch) {
+	pb.Remove(b.Name)
+	pb.Branches = append(pb.Branches, b)
+}
+
+// Remove forgets the branch with the given name.
+func (pb *PatchedBranches) Remove(name string) {
+	branches := pb.Branches[:0]
+	for _, b := range pb.Branches {
+		if b.Name != name {
+			branches = append(branches, b)
+		}
+	}
+	pb.Branches = branches
+}
+
+func patchesFile(project Project) string {
+	return filepath.Join(project.Path, jiri.ProjectMetaDir, jiri.ProjectPatchesFile)
+}
+
+// ReadPatchedBranches returns the branches "jiri patch" created in project.
+func ReadPatchedBranches(jirix *jiri.X, project Project) (PatchedBranches, error) {
+	var pb PatchedBranches
+	data, err := ioutil.ReadFile(patchesFile(project))
+	if os.IsNotExist(err) {
+		return pb, nil
+	} else if err != nil {
+		return pb, err
+	}
+	if err := xml.Unmarshal(data, &pb); err != nil {
+		return pb, fmt.Errorf("invalid patches file for project %s(%s): %v", project.Name, project.Path, err)
+	}
+	return pb, nil
+}
+
+// WritePatchedBranches records the branches "jiri patch" created in project.
+func WritePatchedBranches(jirix *jiri.X, project Project, pb PatchedBranches) error {
+	filename := patchesFile(project)
+	if len(pb.Branches) == 0 {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := xml.MarshalIndent(pb, "", " ")
+	if err != nil {
+		return err
+	}
+	return safeWriteFile(jirix, filename, data)
+}
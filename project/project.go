@@ -22,10 +22,12 @@ import (
 
 	"fuchsia.googlesource.com/jiri"
 	"fuchsia.googlesource.com/jiri/collect"
+	"fuchsia.googlesource.com/jiri/credentials"
 	"fuchsia.googlesource.com/jiri/git"
 	"fuchsia.googlesource.com/jiri/gitutil"
 	"fuchsia.googlesource.com/jiri/googlesource"
 	"fuchsia.googlesource.com/jiri/log"
+	"fuchsia.googlesource.com/jiri/retry"
 	"fuchsia.googlesource.com/jiri/runutil"
 )
 
@@ -38,6 +40,11 @@ var (
 var (
 	// time in minutes
 	DefaultHookTimeout = uint(5)
+
+	// Number of attempts and initial interval for fetching or cloning a
+	// single project that failed with a transient error.
+	fetchAttempts      = 3
+	fetchRetryInterval = 5 * time.Second
 )
 
 // CL represents a changelist.
@@ -465,9 +472,19 @@ type Project struct {
 	HistoryDepth int `xml:"historydepth,attr,omitempty"`
 	// GerritHost is the gerrit host where project CLs will be sent.
 	GerritHost string `xml:"gerrithost,attr,omitempty"`
+	// ReviewType is the code review system changes to the project are sent
+	// to: "gerrit", the default, or "pullrequest".
+	ReviewType string `xml:"reviewtype,attr,omitempty"`
+	// ReviewHost is the url of the code review system.  For pull requests it
+	// is the base url of the REST API of the forge.  Gerrit projects use
+	// GerritHost when it is not set.
+	ReviewHost string `xml:"reviewhost,attr,omitempty"`
 	// GitHooks is a directory containing git hooks that will be installed for
 	// this project.
 	GitHooks string `xml:"githooks,attr,omitempty"`
+	// Groups is a comma-separated list of groups the project belongs to,
+	// which can be used to select projects with "group:<name>".
+	Groups string `xml:"groups,attr,omitempty"`
 
 	XMLName struct{} `xml:"project"`
 
@@ -582,6 +599,11 @@ func (p *Project) validate() error {
 	if strings.Contains(p.Name, KeySeparator) {
 		return fmt.Errorf("bad project: name cannot contain %q: %+v", KeySeparator, *p)
 	}
+	switch p.ReviewType {
+	case "", "gerrit", "pullrequest":
+	default:
+		return fmt.Errorf("bad project: unknown review type %q: %+v", p.ReviewType, *p)
+	}
 	return nil
 }
 
@@ -730,7 +752,8 @@ func CreateSnapshot(jirix *jiri.X, file string, localManifest bool) error {
 
 // CheckoutSnapshot updates project state to the state specified in the given
 // snapshot file.  Note that the snapshot file must not contain remote imports.
-func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc bool, runHookTimeout uint) error {
+// If report is non-nil, the outcome for each project is recorded in it.
+func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc bool, runHookTimeout uint, report *UpdateReport) error {
 	// Find all local projects.
 	scanMode := FastScan
 	if gc {
@@ -744,7 +767,7 @@ func CheckoutSnapshot(jirix *jiri.X, snapshot string, gc bool, runHookTimeout ui
 	if err != nil {
 		return err
 	}
-	if err := updateProjects(jirix, localProjects, remoteProjects, hooks, gc, runHookTimeout, false /*rebaseUntracked*/, false /*rebaseAll*/, true /*snapshot*/); err != nil {
+	if err := updateProjects(jirix, localProjects, remoteProjects, hooks, gc, runHookTimeout, false /*rebaseUntracked*/, false /*rebaseAll*/, false /*autostash*/, true /*snapshot*/, nil /*filter*/, report); err != nil {
 		return err
 	}
 	return WriteUpdateHistorySnapshot(jirix, snapshot, false)
@@ -762,11 +785,19 @@ func LoadSnapshotFile(jirix *jiri.X, snapshot string) (Projects, Hooks, error) {
 			return nil, nil, fmt.Errorf("%q is neither a URL nor a valid file path", snapshot)
 		}
 		jirix.Logger.Infof("Getting snapshot from URL %q", u)
-		resp, err := http.Get(u.String())
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error getting snapshot from URL %q: %v", u, err)
+		}
+		credentials.AuthenticateDownload(req)
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Error getting snapshot from URL %q: %v", u, err)
 		}
 		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil, fmt.Errorf("Error getting snapshot from URL %q: %v", u, resp.Status)
+		}
 		tmpFile, err := ioutil.TempFile("", "snapshot")
 		if err != nil {
 			return nil, nil, fmt.Errorf("Error creating tmp file: %v", err)
@@ -973,9 +1004,19 @@ func matchLocalWithRemote(localProjects, remoteProjects Projects) {
 // UpdateUniverse updates all local projects and tools to match the remote
 // counterparts identified in the manifest. Optionally, the 'gc' flag can be
 // used to indicate that local projects that no longer exist remotely should be
-// removed.
-func UpdateUniverse(jirix *jiri.X, gc bool, localManifest bool, rebaseUntracked bool, rebaseAll bool, runHookTimeout uint) (e error) {
-	jirix.Logger.Infof("Updating all projects")
+// removed.  If filter is non-nil, only the projects it selects and their hooks
+// are updated, and 'gc' is ignored.  If autostash is true, uncommitted changes
+// are stashed before updating a project and restored afterwards.  If report is
+// non-nil, the outcome for each project is recorded in it.
+func UpdateUniverse(jirix *jiri.X, gc bool, localManifest bool, rebaseUntracked bool, rebaseAll bool, autostash bool, runHookTimeout uint, filter ProjectFilter, report *UpdateReport) (e error) {
+	if filter != nil {
+		// Updating a subset of the projects must leave all other projects
+		// alone, so never garbage collect.
+		gc = false
+		jirix.Logger.Infof("Updating selected projects")
+	} else {
+		jirix.Logger.Infof("Updating all projects")
+	}
 
 	updateFn := func(scanMode ScanMode) error {
 		jirix.TimerPush(fmt.Sprintf("update universe: %s", scanMode))
@@ -1002,7 +1043,7 @@ func UpdateUniverse(jirix *jiri.X, gc bool, localManifest bool, rebaseUntracked
 		}
 
 		// Actually update the projects.
-		return updateProjects(jirix, localProjects, remoteProjects, hooks, gc, runHookTimeout, rebaseUntracked, rebaseAll, false /*snapshot*/)
+		return updateProjects(jirix, localProjects, remoteProjects, hooks, gc, runHookTimeout, rebaseUntracked, rebaseAll, autostash, false /*snapshot*/, filter, report)
 	}
 
 	// Specifying gc should always force a full filesystem scan.
@@ -1023,6 +1064,41 @@ func UpdateUniverse(jirix *jiri.X, gc bool, localManifest bool, rebaseUntracked
 	return nil
 }
 
+// FetchUniverse fetches the cache and all local projects from their remotes
+// without checking out, rebasing or running hooks, so that a later update has
+// little left to download.
+func FetchUniverse(jirix *jiri.X, localManifest bool) (e error) {
+	jirix.Logger.Infof("Fetching all projects")
+	jirix.TimerPush("fetch universe")
+	defer jirix.TimerPop()
+
+	localProjects, err := LocalProjects(jirix, FastScan)
+	if err != nil {
+		return err
+	}
+
+	// Loading the updated manifest fetches the manifest projects.
+	remoteProjects, _, tmpLoadDir, err := LoadUpdatedManifest(jirix, localProjects, localManifest)
+	matchLocalWithRemote(localProjects, remoteProjects)
+	if tmpLoadDir != "" {
+		s := jirix.NewSeq()
+		defer collect.Error(func() error { return s.RemoveAll(tmpLoadDir).Done() }, &e)
+	}
+	if err != nil {
+		return err
+	}
+
+	jirix.TimerPush("update cache")
+	err = updateCache(jirix, remoteProjects)
+	jirix.TimerPop()
+	if err != nil {
+		return err
+	}
+	jirix.TimerPush("fetch local projects")
+	defer jirix.TimerPop()
+	return fetchLocalProjects(jirix, localProjects, remoteProjects)
+}
+
 // WriteUpdateHistorySnapshot creates a snapshot of the current state of all
 // projects and writes it to the update history directory.
 func WriteUpdateHistorySnapshot(jirix *jiri.X, snapshotPath string, localManifest bool) error {
@@ -1253,6 +1329,34 @@ func findLocalProjects(jirix *jiri.X, path string, projects Projects) error {
 	return nil
 }
 
+// FetchError is returned when fetching or cloning a project failed for a
+// known reason, even after retrying.
+type FetchError struct {
+	Kind gitutil.ErrorKind
+	Err  error
+}
+
+func (e FetchError) Error() string {
+	return fmt.Sprintf("%v error: %v", e.Kind, e.Err)
+}
+
+// retryFetch runs fn, which fetches or clones a single project, and retries it
+// with exponential backoff for as long as it fails with a transient error.
+func retryFetch(jirix *jiri.X, fn func() error) error {
+	// retry.Function wraps the last error once all attempts fail, so keep it
+	// around to classify the result.
+	var last error
+	err := retry.Function(jirix.Context, func() error {
+		last = fn()
+		return last
+	}, retry.AttemptsOpt(fetchAttempts), retry.IntervalOpt(fetchRetryInterval), retry.BackoffOpt(true),
+		retry.RetryIfOpt(func(err error) bool { return gitutil.ClassifyError(err).Transient() }))
+	if kind := gitutil.ClassifyError(last); err != nil && kind != gitutil.ErrorUnknown {
+		return FetchError{Kind: kind, Err: err}
+	}
+	return err
+}
+
 func fetchAll(jirix *jiri.X, project Project) error {
 	if project.Remote == "" {
 		return fmt.Errorf("project %q does not have a remote", project.Name)
@@ -1261,12 +1365,13 @@ func fetchAll(jirix *jiri.X, project Project) error {
 	if err := g.SetRemoteUrl("origin", project.Remote); err != nil {
 		return err
 	}
-	if project.HistoryDepth > 0 {
-		return gitutil.New(jirix, gitutil.RootDirOpt(project.Path)).Fetch("origin", gitutil.PruneOpt(true),
-			gitutil.DepthOpt(project.HistoryDepth), gitutil.UpdateShallowOpt(true))
-	} else {
+	return retryFetch(jirix, func() error {
+		if project.HistoryDepth > 0 {
+			return gitutil.New(jirix, gitutil.RootDirOpt(project.Path)).Fetch("origin", gitutil.PruneOpt(true),
+				gitutil.DepthOpt(project.HistoryDepth), gitutil.UpdateShallowOpt(true))
+		}
 		return gitutil.New(jirix, gitutil.RootDirOpt(project.Path)).Fetch("origin", gitutil.PruneOpt(true))
-	}
+	})
 }
 
 func GetHeadRevision(jirix *jiri.X, project Project) (string, error) {
@@ -1280,6 +1385,26 @@ func GetHeadRevision(jirix *jiri.X, project Project) (string, error) {
 	return "origin/" + project.RemoteBranch, nil
 }
 
+// StartBranch creates branch in project at the revision specified by the
+// manifest, makes it track the manifest remote branch and checks it out.
+func StartBranch(jirix *jiri.X, project Project, branch string) error {
+	if err := project.fillDefaults(); err != nil {
+		return err
+	}
+	revision, err := GetHeadRevision(jirix, project)
+	if err != nil {
+		return err
+	}
+	g := git.NewGit(project.Path)
+	if err := g.CreateBranchFromRef(branch, revision); err != nil {
+		return err
+	}
+	if err := g.SetUpstream(branch, "origin/"+project.RemoteBranch); err != nil {
+		return err
+	}
+	return gitutil.New(jirix, gitutil.RootDirOpt(project.Path)).CheckoutBranch(branch)
+}
+
 func checkoutHeadRevision(jirix *jiri.X, project Project, forceCheckout bool) error {
 	revision, err := GetHeadRevision(jirix, project)
 	if err != nil {
@@ -1289,6 +1414,21 @@ func checkoutHeadRevision(jirix *jiri.X, project Project, forceCheckout bool) er
 	return git.CheckoutBranch(revision, gitutil.DetachOpt(true), gitutil.ForceOpt(forceCheckout))
 }
 
+// operationAbortCommand returns the command aborting operation in the
+// project at path.
+func operationAbortCommand(path, operation string) string {
+	if operation == gitutil.OperationBisect {
+		return fmt.Sprintf("git -C %q bisect reset", path)
+	}
+	return fmt.Sprintf("git -C %q %s --abort", path, operation)
+}
+
+// rebaseCommand returns the commands rebasing branch onto upstream in the
+// project at path.
+func rebaseCommand(path, branch, upstream string) string {
+	return fmt.Sprintf("git -C %q checkout %s && git -C %q rebase %s", path, branch, path, upstream)
+}
+
 func tryRebase(jirix *jiri.X, project Project, branch string) (bool, error) {
 	scm := gitutil.New(jirix, gitutil.RootDirOpt(project.Path))
 	if err := scm.Rebase(branch); err != nil {
@@ -1300,7 +1440,7 @@ func tryRebase(jirix *jiri.X, project Project, branch string) (bool, error) {
 
 // syncProjectMaster checks out latest detached head if project is on one
 // else it rebases current branch onto its tracking branch
-func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebaseUntracked bool, rebaseAll bool, snapshot bool) error {
+func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebaseUntracked bool, rebaseAll bool, autostash bool, snapshot bool, report *UpdateReport) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
@@ -1311,7 +1451,20 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 		relativePath = project.Path
 	}
 	if project.LocalConfig.Ignore || project.LocalConfig.NoUpdate {
-		jirix.Logger.Warningf("Project %s(%s) won't be updated due to it's local-config\n\n", project.Name, relativePath)
+		msg := fmt.Sprintf("Project %s(%s) won't be updated due to it's local-config\n\n", project.Name, relativePath)
+		jirix.Logger.Warningf("%s", msg)
+		report.addSkipped(project, msg, fmt.Sprintf("cd %q && jiri project-config -ignore=false -no-update=false", relativePath))
+		return nil
+	}
+
+	if state.Operation != "" {
+		recovery := operationAbortCommand(relativePath, state.Operation)
+		msg := fmt.Sprintf("Project %s(%s) has a %s in progress.", project.Name, relativePath, state.Operation)
+		hint := "\nFinish it, or abort it with '%s', and try again.\n\n"
+		jirix.Logger.Errorf("%s", msg+fmt.Sprintf(hint, jirix.Color.Yellow("%s", recovery)))
+		msg += fmt.Sprintf(hint, recovery)
+		jirix.IncrementFailures()
+		report.addFailure(project, CauseOperationInProgress, msg, recovery)
 		return nil
 	}
 
@@ -1320,11 +1473,34 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 
 	if uncommitted, err := g.HasUncommittedChanges(); err != nil {
 		return fmt.Errorf("Cannot get uncommited changes for project %q: %s", project.Name, err)
+	} else if uncommitted && autostash {
+		stashed, err := scm.Stash()
+		if err != nil {
+			return fmt.Errorf("Cannot stash uncommited changes for project %q: %s", project.Name, err)
+		}
+		if stashed {
+			jirix.Logger.Debugf("For project %q, stashed uncommitted changes", project.Name)
+			// Deferred first so that it runs after the original branch is
+			// restored.
+			defer func() {
+				if err := scm.StashPop(); err != nil {
+					// git keeps the stash when it cannot be applied cleanly.
+					recovery := fmt.Sprintf("git -C %q stash show -p", relativePath)
+					msg := fmt.Sprintf("For project %s(%s), your stashed changes conflict with the update.", project.Name, relativePath)
+					hint := "\nResolve the conflicts, your changes are kept in the stash: '%s'\n\n"
+					jirix.Logger.Errorf("%s", msg+fmt.Sprintf(hint, jirix.Color.Yellow("%s", recovery)))
+					msg += fmt.Sprintf(hint, recovery)
+					jirix.IncrementFailures()
+					report.addFailure(project, CauseStashConflict, msg, recovery)
+				}
+			}()
+		}
 	} else if uncommitted {
 		msg := fmt.Sprintf("Project %s(%s) contains uncommited changes.", project.Name, relativePath)
 		msg += fmt.Sprintf("\nCommit or discard the changes and try again.\n\n")
 		jirix.Logger.Errorf(msg)
 		jirix.IncrementFailures()
+		report.addFailure(project, CauseUncommittedChanges, msg, projectUpdateCommand(project, "-autostash"))
 		return nil
 	}
 
@@ -1334,11 +1510,13 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 			if err2 != nil {
 				return err2
 			}
-			gitCommand := jirix.Color.Yellow("git -C %q checkout --detach %s", relativePath, revision)
+			recovery := fmt.Sprintf("git -C %q checkout --detach %s", relativePath, revision)
 			msg := fmt.Sprintf("For project %q, not able to checkout latest, error: %s", project.Name, err)
-			msg += fmt.Sprintf("\nPlease checkout manually use: '%s'\n\n", gitCommand)
-			jirix.Logger.Errorf(msg)
+			hint := "\nPlease checkout manually use: '%s'\n\n"
+			jirix.Logger.Errorf("%s", msg+fmt.Sprintf(hint, jirix.Color.Yellow("%s", recovery)))
+			msg += fmt.Sprintf(hint, recovery)
 			jirix.IncrementFailures()
+			report.addFailure(project, CauseCheckoutFailed, msg, recovery)
 		}
 		if snapshot || !rebaseAll {
 			return nil
@@ -1388,6 +1566,7 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 				msg += "\nPlease do it manually\n\n"
 				jirix.Logger.Errorf(msg)
 				jirix.IncrementFailures()
+				report.addFailure(project, CauseRebaseFailed, msg, rebaseCommand(relativePath, branch.Name, branch.Tracking.Name))
 				continue
 			}
 			rebaseSuccess, err := tryRebase(jirix, project, branch.Tracking.Name)
@@ -1396,11 +1575,13 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 			}
 			if rebaseSuccess {
 				jirix.Logger.Debugf("For project %q, rebased your local branch %q on %q", project.Name, branch.Name, branch.Tracking.Name)
+				report.addRebasedBranch(project, branch.Name)
 			} else {
 				msg := fmt.Sprintf("For project %s(%s), not able to rebase your local branch %q onto %q", project.Name, relativePath, branch.Name, branch.Tracking.Name)
 				msg += "\nPlease do it manually\n\n"
 				jirix.Logger.Errorf(msg)
 				jirix.IncrementFailures()
+				report.addFailure(project, CauseRebaseFailed, msg, rebaseCommand(relativePath, branch.Name, branch.Tracking.Name))
 				continue
 			}
 		} else {
@@ -1418,6 +1599,7 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 					msg += "\nPlease do it manually\n\n"
 					jirix.Logger.Errorf(msg)
 					jirix.IncrementFailures()
+					report.addFailure(project, CauseRebaseFailed, msg, rebaseCommand(relativePath, branch.Name, headRevision))
 					continue
 				}
 				rebaseSuccess, err := tryRebase(jirix, project, headRevision)
@@ -1426,11 +1608,13 @@ func syncProjectMaster(jirix *jiri.X, project Project, state ProjectState, rebas
 				}
 				if rebaseSuccess {
 					jirix.Logger.Debugf("For project %q, rebased your untracked branch %q on %q", project.Name, branch.Name, headRevision)
+					report.addRebasedBranch(project, branch.Name)
 				} else {
 					msg := fmt.Sprintf("For project %s(%s), not able to rebase your untracked branch %q onto JIRI_HEAD.", project.Name, relativePath, branch.Name)
 					msg += "\nPlease do it manually\n\n"
 					jirix.Logger.Errorf(msg)
 					jirix.IncrementFailures()
+					report.addFailure(project, CauseRebaseFailed, msg, rebaseCommand(relativePath, branch.Name, headRevision))
 					continue
 				}
 			} else if !rebaseUntrackedMessage {
@@ -1575,7 +1759,9 @@ func (ld *loader) load(jirix *jiri.X, root, file string, localManifest bool) err
 			if err := jirix.NewSeq().MkdirAll(path, 0755).Done(); err != nil {
 				return err
 			}
-			if err := gitutil.New(jirix).Clone(p.Remote, path, gitutil.NoCheckoutOpt(true)); err != nil {
+			if err := retryFetch(jirix, func() error {
+				return gitutil.New(jirix).Clone(p.Remote, path, gitutil.NoCheckoutOpt(true))
+			}); err != nil {
 				return err
 			}
 			p.Revision = "HEAD"
@@ -1795,12 +1981,16 @@ func updateCache(jirix *jiri.X, remoteProjects Projects) error {
 					if _, err := os.Stat(filepath.Join(dir, "shallow")); err == nil {
 						// Shallow cache, fetch only manifest tracked remote branch
 						refspec := fmt.Sprintf("+refs/heads/%s:refs/heads/%s", branch, branch)
-						if err := gitutil.New(jirix, gitutil.RootDirOpt(dir)).FetchRefspec("origin", refspec, gitutil.PruneOpt(true)); err != nil {
+						if err := retryFetch(jirix, func() error {
+							return gitutil.New(jirix, gitutil.RootDirOpt(dir)).FetchRefspec("origin", refspec, gitutil.PruneOpt(true))
+						}); err != nil {
 							errs <- err
 						}
 						return
 					}
-					if err := gitutil.New(jirix, gitutil.RootDirOpt(dir)).Fetch("origin", gitutil.PruneOpt(true)); err != nil {
+					if err := retryFetch(jirix, func() error {
+						return gitutil.New(jirix, gitutil.RootDirOpt(dir)).Fetch("origin", gitutil.PruneOpt(true))
+					}); err != nil {
 						errs <- err
 					}
 					return
@@ -1808,7 +1998,9 @@ func updateCache(jirix *jiri.X, remoteProjects Projects) error {
 					// Create cache
 					// TODO : If we in future need to support two projects with same remote url,
 					// one with shallow checkout and one with full, we should create two caches
-					if err := gitutil.New(jirix).CloneMirror(remote, dir, depth); err != nil {
+					if err := retryFetch(jirix, func() error {
+						return gitutil.New(jirix).CloneMirror(remote, dir, depth)
+					}); err != nil {
 						errs <- err
 					}
 					return
@@ -1870,7 +2062,7 @@ func fetchLocalProjects(jirix *jiri.X, localProjects, remoteProjects Projects) e
 }
 
 // This function creates worktree and runs create operation in parallel
-func runCreateOperations(jirix *jiri.X, ops []createOperation) MultiError {
+func runCreateOperations(jirix *jiri.X, ops []createOperation, journal *updateJournal, report *UpdateReport) MultiError {
 	count := len(ops)
 	if count == 0 {
 		return nil
@@ -1922,7 +2114,13 @@ func runCreateOperations(jirix *jiri.X, ops []createOperation) MultiError {
 		for _, op := range tree.ops {
 			jirix.Logger.Debugf("%v", op)
 			if err := op.Run(jirix); err != nil {
-				errs <- fmt.Errorf("error creating project %q: %v", op.Project().Name, err)
+				err = fmt.Errorf("error creating project %q: %v", op.Project().Name, err)
+				report.addFailure(op.Project(), CauseOperationFailed, err.Error(), projectUpdateCommand(op.Project(), ""))
+				errs <- err
+				return
+			}
+			if err := journal.markDone(jirix, op.Project()); err != nil {
+				errs <- err
 				return
 			}
 		}
@@ -1951,7 +2149,7 @@ func runCreateOperations(jirix *jiri.X, ops []createOperation) MultiError {
 	return multiErr
 }
 
-func runMoveOperations(jirix *jiri.X, ops []moveOperation) error {
+func runMoveOperations(jirix *jiri.X, ops []moveOperation, journal *updateJournal, report *UpdateReport) error {
 	parentSrcPath := ""
 	parentDestPath := ""
 	for _, op := range ops {
@@ -1963,25 +2161,38 @@ func runMoveOperations(jirix *jiri.X, ops []moveOperation) error {
 		}
 		jirix.Logger.Debugf("%s", op)
 		if err := op.Run(jirix); err != nil {
-			return fmt.Errorf("error moving and updating project %q: %s", op.Project().Name, err)
+			err = fmt.Errorf("error moving and updating project %q: %s", op.Project().Name, err)
+			report.addFailure(op.Project(), CauseOperationFailed, err.Error(), projectUpdateCommand(op.Project(), ""))
+			return err
+		}
+		if err := journal.markDone(jirix, op.Project()); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func runCommonOperations(jirix *jiri.X, ops operations) error {
+func runCommonOperations(jirix *jiri.X, ops operations, report *UpdateReport) error {
 	for _, op := range ops {
 		jirix.Logger.Debugf("%s", op)
 		if err := op.Run(jirix); err != nil {
-			return fmt.Errorf("error updating project %q: %s", op.Project().Name, err)
+			err = fmt.Errorf("error updating project %q: %s", op.Project().Name, err)
+			report.addFailure(op.Project(), CauseOperationFailed, err.Error(), projectUpdateCommand(op.Project(), ""))
+			return err
 		}
 	}
 	return nil
 }
 
-func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks Hooks, gc bool, runHookTimeout uint, rebaseUntracked bool, rebaseAll bool, snapshot bool) error {
+func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks Hooks, gc bool, runHookTimeout uint, rebaseUntracked bool, rebaseAll bool, autostash bool, snapshot bool, filter ProjectFilter, report *UpdateReport) (e error) {
 	jirix.TimerPush("update projects")
 	defer jirix.TimerPop()
+	report.reset()
+
+	// Projects not selected by the filter are neither fetched nor touched.
+	localProjects = filter.projects(localProjects)
+	remoteProjects = filter.projects(remoteProjects)
+	hooks = filter.hooks(hooks)
 
 	jirix.TimerPush("Fetch local projects and get remote revisions")
 	errs := make(chan error)
@@ -2026,7 +2237,7 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 	if len(multiErr) != 0 {
 		return multiErr
 	}
-	ops := computeOperations(localProjects, ps, states, gc, rebaseUntracked, rebaseAll, snapshot)
+	ops := computeOperations(localProjects, ps, states, gc, rebaseUntracked, rebaseAll, autostash, snapshot, report)
 	moveOperations := []moveOperation{}
 	deleteOperations := operations{}
 	updateOperations := operations{}
@@ -2037,6 +2248,7 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 		if err := op.Test(jirix, updates); err != nil {
 			return err
 		}
+		report.addOperation(op)
 		switch o := op.(type) {
 		case deleteOperation:
 			deleteOperations = append(deleteOperations, o)
@@ -2050,21 +2262,32 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 			nullOperations = append(nullOperations, o)
 		}
 	}
-	if err := runCommonOperations(jirix, deleteOperations); err != nil {
+	// Record the operations which cannot be safely interrupted, so that
+	// RecoverUpdate can clean up after them.
+	journal, err := newUpdateJournal(jirix, moveOperations, createOperations)
+	if err != nil {
+		return err
+	}
+	defer collect.Error(func() error { return journal.close(jirix) }, &e)
+	if err := runCommonOperations(jirix, deleteOperations, report); err != nil {
 		return err
 	}
-	if err := runMoveOperations(jirix, moveOperations); err != nil {
+	if err := runMoveOperations(jirix, moveOperations, journal, report); err != nil {
 		return err
 	}
-	if err := runCommonOperations(jirix, updateOperations); err != nil {
+	if err := runCommonOperations(jirix, updateOperations, report); err != nil {
 		return err
 	}
-	if err := runCreateOperations(jirix, createOperations); err != nil {
+	if err := runCreateOperations(jirix, createOperations, journal, report); err != nil {
 		return err
 	}
-	if err := runCommonOperations(jirix, nullOperations); err != nil {
+	if err := journal.close(jirix); err != nil {
 		return err
 	}
+	if err := runCommonOperations(jirix, nullOperations, report); err != nil {
+		return err
+	}
+	report.setNewRevisions()
 	jirix.TimerPush("jiri revision files")
 	for _, project := range ps {
 		if !(project.LocalConfig.Ignore || project.LocalConfig.NoUpdate) {
@@ -2072,20 +2295,22 @@ func updateProjects(jirix *jiri.X, localProjects, remoteProjects Projects, hooks
 		}
 	}
 	jirix.TimerPop()
-	if err := runHooks(jirix, ops, hooks, runHookTimeout); err != nil {
+	if err := runHooks(jirix, ops, hooks, runHookTimeout, report); err != nil {
 		return err
 	}
 	return applyGitHooks(jirix, ops)
 }
 
 // runHooks runs all hooks for the given operations.
-func runHooks(jirix *jiri.X, ops []operation, hooks Hooks, runHookTimeout uint) error {
+func runHooks(jirix *jiri.X, ops []operation, hooks Hooks, runHookTimeout uint, report *UpdateReport) error {
 	jirix.TimerPush("run hooks")
 	defer jirix.TimerPop()
 	type result struct {
 		outFile *os.File
 		errFile *os.File
 		err     error
+		hook    Hook
+		elapsed time.Duration
 	}
 	ch := make(chan result)
 	tmpDir, err := ioutil.TempDir("", "run-hooks")
@@ -2098,14 +2323,15 @@ func runHooks(jirix *jiri.X, ops []operation, hooks Hooks, runHookTimeout uint)
 	for _, hook := range hooks {
 		jirix.Logger.Infof("running hook(%v) for project %q", hook.Name, hook.ProjectName)
 		go func(hook Hook) {
+			start := time.Now()
 			outFile, err := ioutil.TempFile(tmpDir, hook.Name+"-out")
 			if err != nil {
-				ch <- result{nil, nil, err}
+				ch <- result{nil, nil, err, hook, time.Since(start)}
 				return
 			}
 			errFile, err := ioutil.TempFile(tmpDir, hook.Name+"-err")
 			if err != nil {
-				ch <- result{nil, nil, err}
+				ch <- result{nil, nil, err, hook, time.Since(start)}
 				return
 			}
 
@@ -2114,16 +2340,26 @@ func runHooks(jirix *jiri.X, ops []operation, hooks Hooks, runHookTimeout uint)
 			// Hack until sequence is changesd to use logger or is removed
 			s := jirix.NewSeq().Verbose(showHookOutput).CaptureAll(outFile, errFile)
 			if err := s.Dir(hook.ActionPath).Timeout(time.Duration(runHookTimeout) * time.Minute).Last(filepath.Join(hook.ActionPath, hook.Action)); err != nil {
-				ch <- result{outFile, errFile, err}
+				ch <- result{outFile, errFile, err, hook, time.Since(start)}
 				return
 			}
-			ch <- result{outFile, errFile, nil}
+			ch <- result{outFile, errFile, nil, hook, time.Since(start)}
 		}(hook)
 
 	}
 	multiErr := make(MultiError, 0)
 	for range hooks {
 		out := <-ch
+		hookResult := HookResult{
+			Name:     out.hook.Name,
+			Project:  out.hook.ProjectName,
+			Duration: seconds(out.elapsed),
+		}
+		if out.err != nil {
+			hookResult.Error = out.err.Error()
+			hookResult.TimedOut = runutil.IsTimeout(out.err)
+		}
+		report.addHookResult(hookResult)
 		defer func() {
 			if out.outFile != nil {
 				out.outFile.Close()
@@ -2340,7 +2576,7 @@ func (op createOperation) Run(jirix *jiri.X) (e error) {
 	s := jirix.NewSeq()
 
 	path, perm := filepath.Dir(op.destination), os.FileMode(0755)
-	tmpDirPrefix := strings.Replace(op.Project().Name, "/", ".", -1) + "-"
+	tmpDirPrefix := createTmpDirPrefix(op.project)
 
 	// Check the local file system.
 	if _, err := os.Stat(op.destination); err != nil {
@@ -2376,9 +2612,11 @@ func (op createOperation) Run(jirix *jiri.X) (e error) {
 	}
 
 	if jirix.Shared && cache != "" {
-		if err := gitutil.New(jirix).Clone(cache, tmpDir,
-			gitutil.SharedOpt(true),
-			gitutil.NoCheckoutOpt(true), gitutil.DepthOpt(op.project.HistoryDepth)); err != nil {
+		if err := retryFetch(jirix, func() error {
+			return gitutil.New(jirix).Clone(cache, tmpDir,
+				gitutil.SharedOpt(true),
+				gitutil.NoCheckoutOpt(true), gitutil.DepthOpt(op.project.HistoryDepth))
+		}); err != nil {
 			return err
 		}
 	} else {
@@ -2386,9 +2624,11 @@ func (op createOperation) Run(jirix *jiri.X) (e error) {
 		if op.project.HistoryDepth > 0 {
 			ref = ""
 		}
-		if err := gitutil.New(jirix).Clone(op.project.Remote, tmpDir,
-			gitutil.ReferenceOpt(ref),
-			gitutil.NoCheckoutOpt(true), gitutil.DepthOpt(op.project.HistoryDepth)); err != nil {
+		if err := retryFetch(jirix, func() error {
+			return gitutil.New(jirix).Clone(op.project.Remote, tmpDir,
+				gitutil.ReferenceOpt(ref),
+				gitutil.NoCheckoutOpt(true), gitutil.DepthOpt(op.project.HistoryDepth))
+		}); err != nil {
 			return err
 		}
 	}
@@ -2506,7 +2746,9 @@ type moveOperation struct {
 	commonOperation
 	rebaseUntracked bool
 	rebaseAll       bool
+	autostash       bool
 	snapshot        bool
+	report          *UpdateReport
 }
 
 func (op moveOperation) Kind() string {
@@ -2526,7 +2768,7 @@ func (op moveOperation) Run(jirix *jiri.X) error {
 			return err
 		}
 	}
-	if err := syncProjectMaster(jirix, op.project, op.state, op.rebaseUntracked, op.rebaseAll, op.snapshot); err != nil {
+	if err := syncProjectMaster(jirix, op.project, op.state, op.rebaseUntracked, op.rebaseAll, op.autostash, op.snapshot, op.report); err != nil {
 		return err
 	}
 	return writeMetadata(jirix, op.project, op.project.Path)
@@ -2560,7 +2802,9 @@ type updateOperation struct {
 	commonOperation
 	rebaseUntracked bool
 	rebaseAll       bool
+	autostash       bool
 	snapshot        bool
+	report          *UpdateReport
 }
 
 func (op updateOperation) Kind() string {
@@ -2568,7 +2812,7 @@ func (op updateOperation) Kind() string {
 }
 
 func (op updateOperation) Run(jirix *jiri.X) error {
-	if err := syncProjectMaster(jirix, op.project, op.state, op.rebaseUntracked, op.rebaseAll, op.snapshot); err != nil {
+	if err := syncProjectMaster(jirix, op.project, op.state, op.rebaseUntracked, op.rebaseAll, op.autostash, op.snapshot, op.report); err != nil {
 		return err
 	}
 	return writeMetadata(jirix, op.project, op.project.Path)
@@ -2652,8 +2896,9 @@ func (ops operations) Swap(i, j int) {
 // current and new projects (as defined by contents of the local file
 // system and manifest file respectively) and outputs a collection of
 // operations that describe the actions needed to update the target
-// projects.
-func computeOperations(localProjects, remoteProjects Projects, states map[ProjectKey]*ProjectState, gc, rebaseUntracked, rebaseAll, snapshot bool) operations {
+// projects.  Operations which update local branches record their results in
+// report.
+func computeOperations(localProjects, remoteProjects Projects, states map[ProjectKey]*ProjectState, gc, rebaseUntracked, rebaseAll, autostash, snapshot bool, report *UpdateReport) operations {
 	result := operations{}
 	allProjects := map[ProjectKey]bool{}
 	for _, p := range localProjects {
@@ -2674,13 +2919,13 @@ func computeOperations(localProjects, remoteProjects Projects, states map[Projec
 		if s, ok := states[key]; ok {
 			state = s
 		}
-		result = append(result, computeOp(local, remote, state, gc, rebaseUntracked, rebaseAll, snapshot))
+		result = append(result, computeOp(local, remote, state, gc, rebaseUntracked, rebaseAll, autostash, snapshot, report))
 	}
 	sort.Sort(result)
 	return result
 }
 
-func computeOp(local, remote *Project, state *ProjectState, gc, rebaseUntracked, rebaseAll, snapshot bool) operation {
+func computeOp(local, remote *Project, state *ProjectState, gc, rebaseUntracked, rebaseAll, autostash, snapshot bool, report *UpdateReport) operation {
 	switch {
 	case local == nil && remote != nil:
 		return createOperation{commonOperation{
@@ -2725,28 +2970,28 @@ func computeOp(local, remote *Project, state *ProjectState, gc, rebaseUntracked,
 				project:     *remote,
 				source:      local.Path,
 				state:       *state,
-			}, rebaseUntracked, rebaseAll, snapshot}
+			}, rebaseUntracked, rebaseAll, autostash, snapshot, report}
 		case snapshot && local.Revision != remote.Revision:
 			return updateOperation{commonOperation{
 				destination: remote.Path,
 				project:     *remote,
 				source:      local.Path,
 				state:       *state,
-			}, rebaseUntracked, rebaseAll, snapshot}
+			}, rebaseUntracked, rebaseAll, autostash, snapshot, report}
 		case localBranchesNeedUpdating || (state.CurrentBranch.Name == "" && local.Revision != remote.Revision):
 			return updateOperation{commonOperation{
 				destination: remote.Path,
 				project:     *remote,
 				source:      local.Path,
 				state:       *state,
-			}, rebaseUntracked, rebaseAll, snapshot}
+			}, rebaseUntracked, rebaseAll, autostash, snapshot, report}
 		case state.CurrentBranch.Tracking == nil && local.Revision != remote.Revision:
 			return updateOperation{commonOperation{
 				destination: remote.Path,
 				project:     *remote,
 				source:      local.Path,
 				state:       *state,
-			}, rebaseUntracked, rebaseAll, snapshot}
+			}, rebaseUntracked, rebaseAll, autostash, snapshot, report}
 		default:
 			return nullOperation{commonOperation{
 				destination: remote.Path,
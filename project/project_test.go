@@ -452,6 +452,48 @@ func TestLocalProjectWithConfig(t *testing.T) {
 	}
 }
 
+func TestPatchedBranches(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	p := localProjects[1]
+	var patched project.PatchedBranches
+	patched.Add(project.PatchedBranch{Name: "change/1/1", Ref: "refs/changes/01/1/1"})
+	patched.Add(project.PatchedBranch{Name: "fix", Ref: "refs/changes/02/2/1"})
+	patched.Add(project.PatchedBranch{Name: "change/1/1", Ref: "refs/changes/01/1/2"})
+	if err := project.WritePatchedBranches(fake.X, p, patched); err != nil {
+		t.Fatal(err)
+	}
+
+	// The patched branches are kept across updates.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := project.ReadPatchedBranches(fake.X, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []project.PatchedBranch{
+		{Name: "fix", Ref: "refs/changes/02/2/1"},
+		{Name: "change/1/1", Ref: "refs/changes/01/1/2"},
+	}
+	if !reflect.DeepEqual(got.Branches, want) {
+		t.Errorf("got patched branches %+v, want %+v", got.Branches, want)
+	}
+
+	got.Remove("fix")
+	got.Remove("change/1/1")
+	if err := project.WritePatchedBranches(fake.X, p, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(p.Path, jiri.ProjectMetaDir, jiri.ProjectPatchesFile)); !os.IsNotExist(err) {
+		t.Errorf("patches file should not exist: %v", err)
+	}
+}
+
 func TestProjectUpdateWhenNoRebase(t *testing.T) {
 	localProjects, fake, cleanup := setupUniverse(t)
 	defer cleanup()
@@ -799,6 +841,119 @@ func TestUpdateUniverseMovedProject(t *testing.T) {
 	checkReadme(t, fake.X, localProjects[1], "initial readme")
 }
 
+// interruptMove moves project p to a new path in the remote manifest, and
+// simulates an update that was interrupted right after renaming its
+// directory.  It returns the old and the new path of the project.
+func interruptMove(t *testing.T, fake *jiritest.FakeJiriRoot, p project.Project) (string, string) {
+	m, err := fake.ReadRemoteManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath, newPath := p.Path, filepath.Join(fake.X.Root, "new-project-path")
+	for i := range m.Projects {
+		if m.Projects[i].Name == p.Name {
+			m.Projects[i].Path = newPath
+		}
+	}
+	if err := fake.WriteRemoteManifest(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	p.Path = newPath
+	if err := project.InternalWriteMoveJournal(fake.X, p, oldPath); err != nil {
+		t.Fatal(err)
+	}
+	return oldPath, newPath
+}
+
+// TestRecoverUpdateRollsBackMove checks that RecoverUpdate moves a project
+// back to its old location if an update was interrupted while moving it.
+func TestRecoverUpdateRollsBackMove(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	oldPath, newPath := interruptMove(t, fake, localProjects[1])
+
+	if err := project.RecoverUpdate(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AssertDirExists(oldPath).Done(); err != nil {
+		t.Fatalf("expected project %q to be moved back to %q", localProjects[1].Name, oldPath)
+	}
+	if err := s.AssertDirExists(newPath).Done(); err == nil {
+		t.Fatalf("expected path %q not to exist but it did", newPath)
+	}
+	if err := s.AssertFileExists(fake.X.UpdateJournalFile()).Done(); err == nil {
+		t.Fatalf("expected journal to be removed")
+	}
+
+	// The next update should complete the move.
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	localProjects[1].Path = newPath
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+}
+
+// TestRecoverUpdateResumesMove checks that RecoverUpdate keeps a moved
+// project at its new location if asked to resume an interrupted update.
+func TestRecoverUpdateResumesMove(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	_, newPath := interruptMove(t, fake, localProjects[1])
+
+	if err := project.RecoverUpdate(fake.X, true); err != nil {
+		t.Fatal(err)
+	}
+	p, err := project.ProjectAtPath(fake.X, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Path != newPath {
+		t.Fatalf("got project path %q, want %q", p.Path, newPath)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	localProjects[1].Path = newPath
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+}
+
+// TestFailedUpdateRemovesJournal checks that an update which fails without
+// leaving a move half-done does not leave its journal behind.
+func TestFailedUpdateRemovesJournal(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	s := fake.X.NewSeq()
+	// Creating project 1 fails as its path is not empty.
+	obstacle := filepath.Join(localProjects[1].Path, "file")
+	if err := s.MkdirAll(localProjects[1].Path, 0755).WriteFile(obstacle, []byte("obstacle"), 0644).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err == nil {
+		t.Fatalf("expected update to fail")
+	}
+	if err := s.AssertFileExists(fake.X.UpdateJournalFile()).Done(); err == nil {
+		t.Fatalf("expected journal to be removed")
+	}
+
+	if err := s.RemoveAll(localProjects[1].Path).Done(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+}
+
 func TestIgnoredProjectsNotMoved(t *testing.T) {
 	localProjects, fake, cleanup := setupUniverse(t)
 	defer cleanup()
@@ -1093,6 +1248,238 @@ func TestUpdateWhenRemoteChangesRebased(t *testing.T) {
 	}
 }
 
+// TestUpdateUniverseAutostash checks that UpdateUniverse with autostash
+// updates projects with uncommitted changes and restores those changes, and
+// keeps them stashed if they conflict with the update.
+func TestUpdateUniverseAutostash(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Project 1 gets a non-conflicting remote change, project 2 a conflicting one.
+	for _, p := range localProjects[1:3] {
+		if err := ioutil.WriteFile(filepath.Join(p.Path, "README"), []byte("uncommitted"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, fake.X, fake.Projects[localProjects[1].Name], "file1", "file1")
+	newRev, err := git.NewGit(fake.Projects[localProjects[1].Name]).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[localProjects[2].Name], "new revision")
+
+	if err := project.UpdateUniverse(fake.X, false, false, false, false, true, project.DefaultHookTimeout, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rev, err := git.NewGit(localProjects[1].Path).CurrentRevision(); err != nil {
+		t.Fatal(err)
+	} else if rev != newRev {
+		t.Fatalf("project %q is at %s, want %s", localProjects[1].Name, rev, newRev)
+	}
+	checkReadme(t, fake.X, localProjects[1], "uncommitted")
+	for i, want := range []int{0, 1} {
+		p := localProjects[i+1]
+		size, err := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path)).StashSize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != want {
+			t.Fatalf("project %q has %d stashed changes, want %d", p.Name, size, want)
+		}
+	}
+	if got := fake.X.Failures(); got != 1 {
+		t.Fatalf("got %d failures, want 1", got)
+	}
+}
+
+// TestProjectStateOperation checks that the state of a project reports git
+// operations in progress, conflicts and stashes, and that UpdateUniverse
+// skips projects with an operation in progress.
+func TestProjectStateOperation(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave project 1 in the middle of a conflicting merge.
+	p := localProjects[1]
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	head, err := git.NewGit(p.Path).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CreateAndCheckoutBranch("a"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, p.Path, "a")
+	if err := scm.CheckoutBranch(head, gitutil.DetachOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CreateAndCheckoutBranch("b"); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, p.Path, "b")
+	if err := scm.Merge("a", gitutil.ResetOnFailureOpt(false)); err == nil {
+		t.Fatalf("expected the merge to conflict")
+	}
+
+	// Stash a change in project 2.
+	if err := ioutil.WriteFile(filepath.Join(localProjects[2].Path, "README"), []byte("stashed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gitutil.New(fake.X, gitutil.RootDirOpt(localProjects[2].Path)).Stash(); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := project.GetProjectStates(fake.X, project.Projects{p.Key(): p, localProjects[2].Key(): localProjects[2]}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := states[p.Key()]
+	if state.Operation != gitutil.OperationMerge {
+		t.Errorf("got operation %q, want %q", state.Operation, gitutil.OperationMerge)
+	}
+	if !reflect.DeepEqual(state.Conflicts, []string{"README"}) || !state.HasUncommitted {
+		t.Errorf("expected a conflict in README, got %+v", state)
+	}
+	if state.CurrentBranch.Name != "b" || state.CurrentBranch.Tracking != nil {
+		t.Errorf("unexpected current branch %+v", state.CurrentBranch)
+	}
+	if state := states[localProjects[2].Key()]; state.StashCount != 1 || state.Operation != "" || state.HasUncommitted {
+		t.Errorf("expected one stash entry and no changes, got %+v", state)
+	}
+
+	writeReadme(t, fake.X, fake.Projects[p.Name], "new revision")
+	report := project.NewUpdateReport()
+	if err := project.UpdateUniverse(fake.X, false, false, false, false, false, project.DefaultHookTimeout, nil, report); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.X.Failures(); got != 1 {
+		t.Fatalf("got %d failures, want 1", got)
+	}
+	summary := report.Output(fake.X, nil).Summary
+	if len(summary) != 1 || summary[0].Cause != project.CauseOperationInProgress || summary[0].Project != p.Name {
+		t.Fatalf("unexpected update summary %+v", summary)
+	}
+	if !strings.Contains(summary[0].Recovery, "merge --abort") {
+		t.Errorf("unexpected recovery command %q", summary[0].Recovery)
+	}
+	if !strings.Contains(summary[0].Message, summary[0].Recovery) || strings.Contains(summary[0].Message, "\x1b") {
+		t.Errorf("expected a plain message naming the recovery command, got %q", summary[0].Message)
+	}
+}
+
+// TestUpdateUniverseReport checks that UpdateUniverse records the outcome for
+// each project in the update report.
+func TestUpdateUniverseReport(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	oldRev, err := git.NewGit(localProjects[1].Path).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Advance project 1 remotely and leave uncommitted changes in project 2.
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "new revision")
+	newRev, err := git.NewGit(fake.Projects[localProjects[1].Name]).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(localProjects[2].Path, "README")
+	if err := ioutil.WriteFile(file, []byte("uncommitted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[localProjects[2].Name], "new revision")
+
+	report := project.NewUpdateReport()
+	if err := project.UpdateUniverse(fake.X, false, false, false, false, false, project.DefaultHookTimeout, nil, report); err != nil {
+		t.Fatal(err)
+	}
+	out := report.Output(fake.X, nil)
+	updates := map[string]project.ProjectUpdate{}
+	for _, u := range out.Projects {
+		updates[u.Name] = u
+	}
+	if got, want := len(updates), len(localProjects)+1; got != want {
+		t.Fatalf("got %d projects in report, want %d", got, want)
+	}
+	u := updates[localProjects[1].Name]
+	if u.Operation != "update" || u.OldRevision != oldRev || u.NewRevision != newRev {
+		t.Fatalf("got %+v, want update from %s to %s", u, oldRev, newRev)
+	}
+	if u := updates[localProjects[2].Name]; len(u.Failures) != 1 {
+		t.Fatalf("expected one failure for project %q, got %+v", localProjects[2].Name, u)
+	}
+	if out.Failures != 1 {
+		t.Fatalf("got %d failures, want 1", out.Failures)
+	}
+	if len(out.Summary) != 1 {
+		t.Fatalf("got summary %+v, want one issue", out.Summary)
+	}
+	if issue := out.Summary[0]; issue.Project != localProjects[2].Name || issue.Cause != project.CauseUncommittedChanges || !strings.Contains(issue.Recovery, "-autostash") {
+		t.Fatalf("unexpected issue %+v", issue)
+	}
+	if len(out.Timings) == 0 {
+		t.Fatalf("expected timings in report")
+	}
+}
+
+// TestUpdateUniverseFilter checks that UpdateUniverse only updates the
+// projects selected by the filter.
+func TestUpdateUniverseFilter(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "new revision")
+	writeReadme(t, fake.X, fake.Projects[localProjects[2].Name], "new revision")
+
+	filter, err := project.NewProjectFilter([]string{localProjects[1].Name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := project.UpdateUniverse(fake.X, false, false, false, false, false, project.DefaultHookTimeout, filter, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "new revision")
+	checkReadme(t, fake.X, localProjects[2], "initial readme")
+}
+
+// TestFetchUniverse checks that FetchUniverse fetches new remote commits
+// without changing the local checkouts.
+func TestFetchUniverse(t *testing.T) {
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	writeReadme(t, fake.X, fake.Projects[localProjects[1].Name], "new revision")
+	newRev, err := git.NewGit(fake.Projects[localProjects[1].Name]).CurrentRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := project.FetchUniverse(fake.X, false); err != nil {
+		t.Fatal(err)
+	}
+	checkReadme(t, fake.X, localProjects[1], "initial readme")
+	rev, err := git.NewGit(localProjects[1].Path).CurrentRevisionForRef("origin/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != newRev {
+		t.Fatalf("origin/master is at %s, want %s", rev, newRev)
+	}
+}
+
 // TestCheckoutSnapshotUrl tests checking out snapshot functionality from a url
 func TestCheckoutSnapshotUrl(t *testing.T) {
 	testCheckoutSnapshot(t, true)
@@ -1170,9 +1557,9 @@ func testCheckoutSnapshot(t *testing.T, testURL bool) {
 		}))
 		defer server.Close()
 
-		project.CheckoutSnapshot(fake.X, server.URL, false, project.DefaultHookTimeout)
+		project.CheckoutSnapshot(fake.X, server.URL, false, project.DefaultHookTimeout, nil)
 	} else {
-		project.CheckoutSnapshot(fake.X, snapshotFile, false, project.DefaultHookTimeout)
+		project.CheckoutSnapshot(fake.X, snapshotFile, false, project.DefaultHookTimeout, nil)
 	}
 	sort.Sort(project.ProjectsByPath(localProjects))
 	for i, localProject := range localProjects {
@@ -1223,7 +1610,7 @@ func testLocalBranchesAreUpdated(t *testing.T, shouldLocalBeOnABranch, rebaseAll
 		}
 	}
 
-	if err := project.UpdateUniverse(fake.X, false, false, false, rebaseAll, project.DefaultHookTimeout); err != nil {
+	if err := project.UpdateUniverse(fake.X, false, false, false, rebaseAll, false, project.DefaultHookTimeout, nil, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -1393,7 +1780,7 @@ func TestFileImportCycle(t *testing.T) {
 	}
 
 	// The update should complain about the cycle.
-	err := project.UpdateUniverse(jirix, false, false, false, false, project.DefaultHookTimeout)
+	err := project.UpdateUniverse(jirix, false, false, false, false, false, project.DefaultHookTimeout, nil, nil)
 	if got, want := fmt.Sprint(err), "import cycle detected in local manifest files"; !strings.Contains(got, want) {
 		t.Errorf("got error %v, want substr %v", got, want)
 	}
@@ -1444,7 +1831,7 @@ func TestRemoteImportCycle(t *testing.T) {
 	commitFile(t, fake.X, remote2, fileB, "commit B")
 
 	// The update should complain about the cycle.
-	err := project.UpdateUniverse(fake.X, false, false, false, false, project.DefaultHookTimeout)
+	err := project.UpdateUniverse(fake.X, false, false, false, false, false, project.DefaultHookTimeout, nil, nil)
 	if got, want := fmt.Sprint(err), "import cycle detected in remote manifest imports"; !strings.Contains(got, want) {
 		t.Errorf("got error %v, want substr %v", got, want)
 	}
@@ -1514,7 +1901,7 @@ func TestFileAndRemoteImportCycle(t *testing.T) {
 	commitFile(t, fake.X, remote1, fileD, "commit D")
 
 	// The update should complain about the cycle.
-	err := project.UpdateUniverse(fake.X, false, false, false, false, project.DefaultHookTimeout)
+	err := project.UpdateUniverse(fake.X, false, false, false, false, false, project.DefaultHookTimeout, nil, nil)
 	if got, want := fmt.Sprint(err), "import cycle detected"; !strings.Contains(got, want) {
 		t.Errorf("got error %v, want substr %v", got, want)
 	}
@@ -1636,11 +2023,25 @@ func TestProjectToFromFile(t *testing.T) {
 				Name:         "project2",
 				Path:         filepath.Join(jirix.Root, "path2"),
 				GitHooks:     filepath.Join(jirix.Root, "git-hooks"),
+				Groups:       "core,tools",
 				Remote:       "remote2",
 				RemoteBranch: "branch2",
 				Revision:     "rev2",
 			},
-			`<project name="project2" path="path2" remote="remote2" remotebranch="branch2" revision="rev2" githooks="git-hooks"/>
+			`<project name="project2" path="path2" remote="remote2" remotebranch="branch2" revision="rev2" githooks="git-hooks" groups="core,tools"/>
+`,
+		},
+		{
+			project.Project{
+				Name:         "project3",
+				Path:         filepath.Join(jirix.Root, "path3"),
+				Remote:       "https://example.com/owner/project3",
+				RemoteBranch: "master",
+				Revision:     "HEAD",
+				ReviewType:   "pullrequest",
+				ReviewHost:   "https://api.example.com",
+			},
+			`<project name="project3" path="path3" remote="https://example.com/owner/project3" reviewtype="pullrequest" reviewhost="https://api.example.com"/>
 `,
 		},
 	}
@@ -1665,3 +2066,122 @@ func TestProjectToFromFile(t *testing.T) {
 		}
 	}
 }
+
+func TestSelector(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+
+	projects := []project.Project{
+		{Name: "garnet", Path: filepath.Join(jirix.Root, "garnet"), Remote: "https://host/garnet", Groups: "core"},
+		{Name: "zircon", Path: filepath.Join(jirix.Root, "zircon"), Remote: "https://host/zircon", Groups: "core, kernel"},
+		{Name: "gtest", Path: filepath.Join(jirix.Root, "third_party", "gtest"), Remote: "https://host/gtest"},
+		{Name: "zlib", Path: filepath.Join(jirix.Root, "third_party", "zlib"), Remote: "https://host/zlib"},
+	}
+	states := map[string]*project.ProjectState{
+		"garnet": {
+			CurrentBranch: project.BranchState{ReferenceState: &project.ReferenceState{Name: "fix-1"}},
+			Branches:      []project.BranchState{{ReferenceState: &project.ReferenceState{Name: "fix-1"}}},
+		},
+		"zircon": {
+			CurrentBranch:  project.BranchState{ReferenceState: &project.ReferenceState{}},
+			Branches:       []project.BranchState{{ReferenceState: &project.ReferenceState{Name: "fix-2"}}},
+			HasUncommitted: true,
+		},
+		"gtest": {
+			CurrentBranch: project.BranchState{ReferenceState: &project.ReferenceState{Name: "master"}},
+			HasUntracked:  true,
+		},
+		"zlib": {
+			CurrentBranch: project.BranchState{ReferenceState: &project.ReferenceState{Name: "master"}},
+		},
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	if err := os.Chdir(jirix.Root); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"garnet", []string{"garnet"}},
+		{"g*", []string{"garnet", "gtest"}},
+		{"third_party/*", []string{"gtest", "zlib"}},
+		{"name:z*", []string{"zircon", "zlib"}},
+		{"path:third_party/z*", []string{"zlib"}},
+		{"re:^z.*n", []string{"zircon"}},
+		{"re:(garnet|zlib)", []string{"garnet", "zlib"}},
+		{"under:third_party", []string{"gtest", "zlib"}},
+		{"under:third", nil},
+		{"group:core", []string{"garnet", "zircon"}},
+		{"group:kernel", []string{"zircon"}},
+		{"branch:fix-*", []string{"garnet", "zircon"}},
+		{"on:fix-*", []string{"garnet"}},
+		{"on:*", []string{"garnet", "gtest", "zlib"}},
+		{"dirty", []string{"zircon"}},
+		{"untracked", []string{"gtest"}},
+		{"dirty or untracked", []string{"gtest", "zircon"}},
+		{"dirty || untracked", []string{"gtest", "zircon"}},
+		{"group:core and not dirty", []string{"garnet"}},
+		{"group:core !dirty", []string{"garnet"}},
+		{"under:third_party && !(name:gtest)", []string{"zlib"}},
+		{"(garnet or zircon) and (branch:fix-2 or on:fix-1)", []string{"garnet", "zircon"}},
+		{"garnet or zircon and dirty", []string{"garnet", "zircon"}},
+		{"not (garnet or zircon) dirty", nil},
+	}
+	for _, test := range tests {
+		selector, err := project.ParseSelector(jirix, test.expr)
+		if err != nil {
+			t.Errorf("ParseSelector(%q) failed: %v", test.expr, err)
+			continue
+		}
+		var got []string
+		for _, p := range projects {
+			if selector.Match(p, states[p.Name]) {
+				got = append(got, p.Name)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("selector %q: got %v, want %v", test.expr, got, test.want)
+		}
+	}
+
+	for _, expr := range []string{"", "(garnet", "garnet)", "garnet or", "and garnet", "name:", "name:[", "re:(", "foo:bar", "not"} {
+		if _, err := project.ParseSelector(jirix, expr); err == nil {
+			t.Errorf("ParseSelector(%q) did not fail", expr)
+		}
+	}
+
+	// Select computes the states it needs.
+	localProjects, fake, cleanup := setupUniverse(t)
+	defer cleanup()
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(localProjects[1].Path, "README"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	all, err := project.LocalProjects(fake.X, project.FastScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector, err := project.ParseSelector(fake.X, "dirty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, err := selector.Select(fake.X, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 {
+		t.Fatalf("got %d projects, want 1", len(selected))
+	}
+	if _, ok := selected[localProjects[1].Key()]; !ok {
+		t.Errorf("project %q was not selected: %v", localProjects[1].Name, selected)
+	}
+}
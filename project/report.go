@@ -0,0 +1,348 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/git"
+	"fuchsia.googlesource.com/jiri/timing"
+)
+
+// ProjectUpdate describes what "jiri update" did to a single project.
+type ProjectUpdate struct {
+	Name            string   `json:"name"`
+	Path            string   `json:"path"`
+	Operation       string   `json:"operation"`
+	OldRevision     string   `json:"old_revision,omitempty"`
+	NewRevision     string   `json:"new_revision,omitempty"`
+	RebasedBranches []string `json:"rebased_branches,omitempty"`
+	Failures        []string `json:"failures,omitempty"`
+}
+
+// HookResult describes the outcome of running a single hook.
+type HookResult struct {
+	Name     string  `json:"name"`
+	Project  string  `json:"project"`
+	Duration float64 `json:"duration_seconds"`
+	TimedOut bool    `json:"timed_out,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Causes of an UpdateIssue.
+const (
+	CauseUncommittedChanges  = "uncommitted-changes"
+	CauseOperationInProgress = "operation-in-progress"
+	CauseCheckoutFailed      = "checkout-failed"
+	CauseRebaseFailed        = "rebase-failed"
+	CauseStashConflict       = "stash-conflict"
+	CauseOperationFailed     = "operation-failed"
+	CauseSkipped             = "skipped"
+)
+
+// causeTitles are the headings of the groups in the update summary, in the
+// order they are printed.
+var causeTitles = []struct{ cause, title string }{
+	{CauseOperationFailed, "Failed operations"},
+	{CauseUncommittedChanges, "Not updated due to uncommitted changes"},
+	{CauseOperationInProgress, "Not updated due to a git operation in progress"},
+	{CauseCheckoutFailed, "Not able to checkout latest revision"},
+	{CauseRebaseFailed, "Not able to rebase local branch"},
+	{CauseStashConflict, "Stashed changes conflict with the update"},
+	{CauseSkipped, "Skipped due to local-config"},
+}
+
+// UpdateIssue describes why a project was not fully updated, and the command
+// that recovers from it.
+type UpdateIssue struct {
+	Project  string `json:"project"`
+	Path     string `json:"path"`
+	Cause    string `json:"cause"`
+	Message  string `json:"message"`
+	Recovery string `json:"recovery,omitempty"`
+}
+
+// TimingResult describes a single interval recorded by the jiri timer.
+type TimingResult struct {
+	Name     string  `json:"name"`
+	Depth    int     `json:"depth"`
+	Start    float64 `json:"start_seconds"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+// UpdateReportOutput defines the JSON format of the "jiri update" report.
+type UpdateReportOutput struct {
+	Projects []ProjectUpdate `json:"projects"`
+	Hooks    []HookResult    `json:"hooks,omitempty"`
+	Timings  []TimingResult  `json:"timings,omitempty"`
+	Summary  []UpdateIssue   `json:"summary,omitempty"`
+	Failures uint32          `json:"failures"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// UpdateReport collects the outcome of an update so that it can be written
+// out in a machine readable form.  A nil *UpdateReport is valid and records
+// nothing.
+type UpdateReport struct {
+	mu       sync.Mutex
+	projects map[ProjectKey]*ProjectUpdate
+	hooks    []HookResult
+	issues   []UpdateIssue
+}
+
+// NewUpdateReport returns a new, empty UpdateReport.
+func NewUpdateReport() *UpdateReport {
+	return &UpdateReport{
+		projects: make(map[ProjectKey]*ProjectUpdate),
+	}
+}
+
+// reset drops everything recorded so far.  It is called at the beginning of
+// every update attempt so that the report only describes the last one.
+func (r *UpdateReport) reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.projects = make(map[ProjectKey]*ProjectUpdate)
+	r.hooks = nil
+	r.issues = nil
+}
+
+// addOperation records the operation that is about to be run for a project.
+func (r *UpdateReport) addOperation(op operation) {
+	if r == nil {
+		return
+	}
+	p := op.Project()
+	u := &ProjectUpdate{
+		Name:      p.Name,
+		Path:      p.Path,
+		Operation: op.Kind(),
+	}
+	switch o := op.(type) {
+	case deleteOperation:
+		u.OldRevision = o.project.Revision
+	case moveOperation:
+		u.OldRevision = o.state.Project.Revision
+	case updateOperation:
+		u.OldRevision = o.state.Project.Revision
+	case nullOperation:
+		u.OldRevision = o.state.Project.Revision
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.projects[p.Key()] = u
+}
+
+// project returns the entry for the given project, creating it if necessary.
+// The caller must hold r.mu.
+func (r *UpdateReport) project(p Project) *ProjectUpdate {
+	u, ok := r.projects[p.Key()]
+	if !ok {
+		u = &ProjectUpdate{Name: p.Name, Path: p.Path}
+		r.projects[p.Key()] = u
+	}
+	return u
+}
+
+// addRebasedBranch records that branch was rebased in project p.
+func (r *UpdateReport) addRebasedBranch(p Project, branch string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u := r.project(p)
+	u.RebasedBranches = append(u.RebasedBranches, branch)
+}
+
+// addFailure records a failure message for project p, along with its cause
+// and the command that recovers from it.
+func (r *UpdateReport) addFailure(p Project, cause, msg, recovery string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u := r.project(p)
+	u.Failures = append(u.Failures, msg)
+	r.addIssue(p, cause, msg, recovery)
+}
+
+// addSkipped records that project p was not updated on purpose.
+func (r *UpdateReport) addSkipped(p Project, msg, recovery string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addIssue(p, CauseSkipped, msg, recovery)
+}
+
+// addIssue records an UpdateIssue.  The caller must hold r.mu.
+func (r *UpdateReport) addIssue(p Project, cause, msg, recovery string) {
+	r.issues = append(r.issues, UpdateIssue{
+		Project:  p.Name,
+		Path:     p.Path,
+		Cause:    cause,
+		Message:  strings.TrimSpace(msg),
+		Recovery: recovery,
+	})
+}
+
+// addHookResult records the outcome of running a hook.
+func (r *UpdateReport) addHookResult(result HookResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, result)
+}
+
+// setNewRevisions records the revision each updated project ended up at.
+func (r *UpdateReport) setNewRevisions() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.projects {
+		if u.Operation == "delete" || u.Path == "" {
+			continue
+		}
+		if rev, err := git.NewGit(u.Path).CurrentRevision(); err == nil {
+			u.NewRevision = rev
+		}
+	}
+}
+
+// Output returns the report in its JSON format.  The timings are taken from
+// jirix.Timer(), and updateErr is the error, if any, returned by the update.
+func (r *UpdateReport) Output(jirix *jiri.X, updateErr error) UpdateReportOutput {
+	out := UpdateReportOutput{
+		Projects: []ProjectUpdate{},
+		Failures: jirix.Failures(),
+	}
+	if updateErr != nil {
+		out.Error = updateErr.Error()
+	}
+	if r != nil {
+		r.mu.Lock()
+		for _, u := range r.projects {
+			out.Projects = append(out.Projects, *u)
+		}
+		out.Hooks = append(out.Hooks, r.hooks...)
+		out.Summary = append(out.Summary, r.issues...)
+		r.mu.Unlock()
+	}
+	sort.Sort(projectUpdatesByPath(out.Projects))
+	sort.Sort(hookResults(out.Hooks))
+	sort.Sort(updateIssues(out.Summary))
+	if t := jirix.Timer(); t != nil {
+		now := t.Now()
+		for _, i := range t.Intervals {
+			end := i.End
+			if end == timing.InvalidDuration {
+				end = now
+			}
+			out.Timings = append(out.Timings, TimingResult{
+				Name:     i.Name,
+				Depth:    i.Depth,
+				Start:    seconds(i.Start),
+				Duration: seconds(end - i.Start),
+			})
+		}
+	}
+	return out
+}
+
+// PrintSummary prints the issues recorded during the update as a table grouped
+// by cause, with the command that recovers from each of them.
+func (r *UpdateReport) PrintSummary(jirix *jiri.X) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	issues := append([]UpdateIssue(nil), r.issues...)
+	r.mu.Unlock()
+	if len(issues) == 0 {
+		return
+	}
+	sort.Sort(updateIssues(issues))
+	w := jirix.Stdout()
+	fmt.Fprintf(w, "\nUpdate summary:\n")
+	for _, c := range causeTitles {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		n := 0
+		for _, issue := range issues {
+			if issue.Cause != c.cause {
+				continue
+			}
+			if n == 0 {
+				fmt.Fprintf(w, "\n%s:\n", c.title)
+			}
+			n++
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", issue.Project, issue.Path, jirix.Color.Yellow("%s", issue.Recovery))
+		}
+		tw.Flush()
+	}
+	fmt.Fprintln(w)
+}
+
+// projectUpdateCommand returns the command updating only project p.
+func projectUpdateCommand(p Project, flags string) string {
+	if flags != "" {
+		flags += " "
+	}
+	return fmt.Sprintf("jiri update %s'%s'", flags, regexp.QuoteMeta(p.Name))
+}
+
+// projectUpdatesByPath implements the Sort interface. It sorts ProjectUpdates
+// by the Path field.
+type projectUpdatesByPath []ProjectUpdate
+
+func (u projectUpdatesByPath) Len() int           { return len(u) }
+func (u projectUpdatesByPath) Less(i, j int) bool { return u[i].Path < u[j].Path }
+func (u projectUpdatesByPath) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+// hookResults implements the Sort interface. It sorts HookResults by project
+// and then by name.
+type hookResults []HookResult
+
+func (h hookResults) Len() int { return len(h) }
+func (h hookResults) Less(i, j int) bool {
+	if h[i].Project != h[j].Project {
+		return h[i].Project < h[j].Project
+	}
+	return h[i].Name < h[j].Name
+}
+func (h hookResults) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// updateIssues implements the Sort interface. It sorts UpdateIssues by cause
+// and then by path.
+type updateIssues []UpdateIssue
+
+func (u updateIssues) Len() int { return len(u) }
+func (u updateIssues) Less(i, j int) bool {
+	if u[i].Cause != u[j].Cause {
+		return u[i].Cause < u[j].Cause
+	}
+	return u[i].Path < u[j].Path
+}
+func (u updateIssues) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+
+func seconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Second)
+}
@@ -0,0 +1,368 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package project
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"fuchsia.googlesource.com/jiri"
+)
+
+// SelectorHelp documents the project selector language.
+const SelectorHelp = `
+A project selector is an expression made of the following terms:
+  <glob>           projects whose name or path relative to the jiri root
+                   matches the glob, e.g. "third_party/*"
+  name:<glob>      projects whose name matches the glob
+  path:<glob>      projects whose path relative to the jiri root matches the
+                   glob
+  re:<regexp>      projects whose key matches the regular expression
+  under:<dir>      projects in <dir> or below it, <dir> being relative to the
+                   current directory
+  group:<glob>     projects with a group matching the glob
+  branch:<glob>    projects with a local branch matching the glob
+  on:<glob>        projects whose current branch matches the glob
+  dirty            projects with uncommitted changes
+  untracked        projects with untracked files
+Globs use the syntax of Go's path.Match.  Terms can be combined with "and"
+(or "&&"), "or" (or "||"), "not" (or "!") and parentheses.  Terms which follow
+each other without an operator are combined with "and", e.g.
+  jiri status -select 'under:third_party dirty or branch:fix-*'
+`
+
+// Selector selects projects with an expression described by SelectorHelp.
+// A nil Selector selects all projects.
+type Selector struct {
+	expr       string
+	root       string
+	match      selectorFunc
+	needsState bool
+	checkDirty bool
+}
+
+type selectorFunc func(p Project, state *ProjectState) bool
+
+// ParseSelector parses a project selector expression.
+func ParseSelector(jirix *jiri.X, expr string) (*Selector, error) {
+	tokens, err := tokenizeSelector(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project selector %q: %v", expr, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("invalid project selector %q: empty expression", expr)
+	}
+	s := &Selector{expr: expr, root: jirix.Root}
+	p := &selectorParser{s: s, tokens: tokens}
+	if s.match, err = p.parseOr(); err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid project selector %q: %v", expr, err)
+	}
+	return s, nil
+}
+
+// String returns the expression s was parsed from.
+func (s *Selector) String() string {
+	if s == nil {
+		return ""
+	}
+	return s.expr
+}
+
+// NeedsState returns true if s needs the state of projects to select them.
+func (s *Selector) NeedsState() bool {
+	return s != nil && s.needsState
+}
+
+// CheckDirty returns true if s needs project states computed with
+// checkDirty.
+func (s *Selector) CheckDirty() bool {
+	return s != nil && s.checkDirty
+}
+
+// Match returns true if p is selected.  state is the state of p, and may be
+// nil unless NeedsState returns true.
+func (s *Selector) Match(p Project, state *ProjectState) bool {
+	if s == nil {
+		return true
+	}
+	if state == nil && s.needsState {
+		return false
+	}
+	return s.match(p, state)
+}
+
+// Select returns the selected projects among projects, computing their states
+// if needed.
+func (s *Selector) Select(jirix *jiri.X, projects Projects) (Projects, error) {
+	if s == nil {
+		return projects, nil
+	}
+	var states map[ProjectKey]*ProjectState
+	if s.needsState {
+		var err error
+		if states, err = GetProjectStates(jirix, projects, s.checkDirty); err != nil {
+			return nil, err
+		}
+	}
+	result := make(Projects)
+	for key, p := range projects {
+		if s.Match(p, states[key]) {
+			result[key] = p
+		}
+	}
+	return result, nil
+}
+
+// relPath returns the path of p relative to the jiri root, with forward
+// slashes.
+func (s *Selector) relPath(p Project) string {
+	rel, err := filepath.Rel(s.root, p.Path)
+	if err != nil {
+		return filepath.ToSlash(p.Path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// tokenizeSelector splits expr into parentheses, "!" and terms.  Parentheses
+// inside a term, e.g. in a regular expression, are part of the term as long
+// as they are balanced.
+func tokenizeSelector(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '!':
+			tokens = append(tokens, "!")
+			i++
+		default:
+			start, depth := i, 0
+		term:
+			for ; i < len(runes); i++ {
+				switch runes[i] {
+				case '(':
+					depth++
+				case ')':
+					if depth == 0 {
+						break term
+					}
+					depth--
+				default:
+					if unicode.IsSpace(runes[i]) {
+						break term
+					}
+				}
+			}
+			if depth != 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %q", string(runes[start:i]))
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens, nil
+}
+
+type selectorParser struct {
+	s      *Selector
+	tokens []string
+	pos    int
+}
+
+func (p *selectorParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *selectorParser) parseOr() (selectorFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok == "or" || tok == "||"; tok = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pr Project, state *ProjectState) bool {
+			return l(pr, state) || right(pr, state)
+		}
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseAnd() (selectorFunc, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch tok := p.peek(); tok {
+		case "", ")", "or", "||":
+			return left, nil
+		case "and", "&&":
+			p.pos++
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pr Project, state *ProjectState) bool {
+			return l(pr, state) && right(pr, state)
+		}
+	}
+}
+
+func (p *selectorParser) parseNot() (selectorFunc, error) {
+	switch tok := p.peek(); tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "not", "!":
+		p.pos++
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(pr Project, state *ProjectState) bool {
+			return !e(pr, state)
+		}, nil
+	case "(":
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing \")\"")
+		}
+		p.pos++
+		return e, nil
+	case ")", "and", "&&", "or", "||":
+		return nil, fmt.Errorf("unexpected %q", tok)
+	default:
+		p.pos++
+		return p.s.parseTerm(tok)
+	}
+}
+
+// checkGlob returns an error if glob is not a valid pattern.
+func checkGlob(glob string) error {
+	if _, err := path.Match(glob, ""); err != nil {
+		return fmt.Errorf("invalid glob %q: %v", glob, err)
+	}
+	return nil
+}
+
+// matchAny returns true if glob matches one of values.
+func matchAny(glob string, values []string) bool {
+	for _, v := range values {
+		if ok, _ := path.Match(glob, v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Selector) parseTerm(term string) (selectorFunc, error) {
+	switch term {
+	case "dirty":
+		s.needsState, s.checkDirty = true, true
+		return func(_ Project, state *ProjectState) bool {
+			return state.HasUncommitted
+		}, nil
+	case "untracked":
+		s.needsState, s.checkDirty = true, true
+		return func(_ Project, state *ProjectState) bool {
+			return state.HasUntracked
+		}, nil
+	}
+
+	i := strings.Index(term, ":")
+	if i < 0 {
+		if err := checkGlob(term); err != nil {
+			return nil, err
+		}
+		return func(p Project, _ *ProjectState) bool {
+			return matchAny(term, []string{p.Name, s.relPath(p)})
+		}, nil
+	}
+	kind, arg := term[:i], term[i+1:]
+	if arg == "" {
+		return nil, fmt.Errorf("missing argument in %q", term)
+	}
+	if kind == "re" {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regexp %q: %v", arg, err)
+		}
+		return func(p Project, _ *ProjectState) bool {
+			return re.MatchString(string(p.Key()))
+		}, nil
+	}
+	if kind == "under" {
+		dir, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(p Project, _ *ProjectState) bool {
+			return p.Path == dir || strings.HasPrefix(p.Path, dir+string(filepath.Separator))
+		}, nil
+	}
+
+	if err := checkGlob(arg); err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "name":
+		return func(p Project, _ *ProjectState) bool {
+			return matchAny(arg, []string{p.Name})
+		}, nil
+	case "path":
+		return func(p Project, _ *ProjectState) bool {
+			return matchAny(arg, []string{s.relPath(p)})
+		}, nil
+	case "group":
+		return func(p Project, _ *ProjectState) bool {
+			var groups []string
+			for _, g := range strings.Split(p.Groups, ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					groups = append(groups, g)
+				}
+			}
+			return matchAny(arg, groups)
+		}, nil
+	case "branch":
+		s.needsState = true
+		return func(_ Project, state *ProjectState) bool {
+			for _, b := range state.Branches {
+				if ok, _ := path.Match(arg, b.Name); ok {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case "on":
+		s.needsState = true
+		return func(_ Project, state *ProjectState) bool {
+			return state.CurrentBranch.Name != "" && matchAny(arg, []string{state.CurrentBranch.Name})
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown term %q", term)
+}
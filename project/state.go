@@ -9,6 +9,7 @@ import (
 
 	"fuchsia.googlesource.com/jiri"
 	"fuchsia.googlesource.com/jiri/git"
+	"fuchsia.googlesource.com/jiri/gitutil"
 	"fuchsia.googlesource.com/jiri/tool"
 )
 
@@ -20,6 +21,18 @@ type ReferenceState struct {
 type BranchState struct {
 	*ReferenceState
 	Tracking *ReferenceState
+	// Ahead and Behind are the number of commits on the branch which are
+	// not on its tracking branch, and the other way around.
+	Ahead, Behind int
+}
+
+// SubmoduleState describes how a submodule differs from the revision
+// recorded in HEAD.
+type SubmoduleState struct {
+	Path          string
+	CommitChanged bool
+	Modified      bool
+	Untracked     bool
 }
 
 type ProjectState struct {
@@ -27,35 +40,43 @@ type ProjectState struct {
 	CurrentBranch  BranchState
 	HasUncommitted bool
 	HasUntracked   bool
-	Project        Project
+	// Operation is the git operation in progress, one of the
+	// gitutil.Operation constants, or "" if there is none.
+	Operation string
+	// The following fields are only set if the state was computed with
+	// checkDirty.
+	StashCount int
+	Conflicts  []string
+	Submodules []SubmoduleState
+	Project    Project
 }
 
 func setProjectState(jirix *jiri.X, state *ProjectState, checkDirty bool, ch chan<- error) {
 	var err error
-	g := git.NewGit(state.Project.Path)
-	branches, err := g.GetAllBranchesInfo()
+	scm := gitutil.New(jirix, gitutil.RootDirOpt(state.Project.Path))
+	branches, err := scm.BranchesInfo()
 	if err != nil {
 		ch <- err
 		return
 	}
 	state.CurrentBranch = BranchState{
-		&ReferenceState{
+		ReferenceState: &ReferenceState{
 			Name: "",
 		},
-		nil,
 	}
 	for _, branch := range branches {
 		b := BranchState{
-			&ReferenceState{
+			ReferenceState: &ReferenceState{
 				Name:     branch.Name,
 				Revision: branch.Revision,
 			},
-			nil,
+			Ahead:  branch.Ahead,
+			Behind: branch.Behind,
 		}
-		if branch.Tracking != nil {
+		if branch.Upstream != "" {
 			b.Tracking = &ReferenceState{
-				Name:     branch.Tracking.Name,
-				Revision: branch.Tracking.Revision,
+				Name:     branch.Upstream,
+				Revision: branch.UpstreamRevision,
 			}
 		}
 		state.Branches = append(state.Branches, b)
@@ -63,21 +84,34 @@ func setProjectState(jirix *jiri.X, state *ProjectState, checkDirty bool, ch cha
 			state.CurrentBranch = b
 		}
 	}
-	if state.CurrentBranch.Name == "" {
-		if state.CurrentBranch.Revision, err = g.CurrentRevision(); err != nil {
-			ch <- err
-			return
-		}
+	if state.Operation, err = scm.OperationInProgress(); err != nil {
+		ch <- fmt.Errorf("Cannot get operation in progress for project %q: %v", state.Project.Name, err)
+		return
 	}
 	if checkDirty {
-		state.HasUncommitted, err = g.HasUncommittedChanges()
+		status, err := scm.Status(true)
 		if err != nil {
-			ch <- fmt.Errorf("Cannot get uncommited changes for project %q: %v", state.Project.Name, err)
+			ch <- fmt.Errorf("Cannot get status for project %q: %v", state.Project.Name, err)
 			return
 		}
-		state.HasUntracked, err = g.HasUntrackedFiles()
-		if err != nil {
-			ch <- fmt.Errorf("Cannot get untracked changes for project %q: %v", state.Project.Name, err)
+		state.HasUncommitted = len(status.Changed) != 0 || len(status.Conflicted) != 0
+		state.HasUntracked = len(status.Untracked) != 0
+		state.StashCount = status.StashCount
+		state.Conflicts = status.Conflicted
+		for _, sm := range status.Submodules {
+			state.Submodules = append(state.Submodules, SubmoduleState{
+				Path:          sm.Path,
+				CommitChanged: sm.CommitChanged,
+				Modified:      sm.Modified,
+				Untracked:     sm.Untracked,
+			})
+		}
+		if state.CurrentBranch.Name == "" {
+			state.CurrentBranch.Revision = status.Head
+		}
+	} else if state.CurrentBranch.Name == "" {
+		if state.CurrentBranch.Revision, err = git.NewGit(state.Project.Path).CurrentRevision(); err != nil {
+			ch <- err
 			return
 		}
 	}
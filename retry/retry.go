@@ -8,6 +8,7 @@ package retry
 
 import (
 	"fmt"
+	"math/rand"
 	"time"
 
 	"fuchsia.googlesource.com/jiri/tool"
@@ -25,21 +26,41 @@ type IntervalOpt time.Duration
 
 func (i IntervalOpt) retryOpt() {}
 
+// BackoffOpt doubles the interval after every failed attempt and adds a
+// random jitter of up to half the interval, so that concurrent callers do not
+// retry in lockstep.
+type BackoffOpt bool
+
+func (b BackoffOpt) retryOpt() {}
+
+// RetryIfOpt decides whether an error is worth retrying.  Errors for which it
+// returns false are returned immediately and unchanged.
+type RetryIfOpt func(error) bool
+
+func (r RetryIfOpt) retryOpt() {}
+
 const (
 	defaultAttempts = 3
 	defaultInterval = 10 * time.Second
 )
 
 // Function retries the given function for the given number of
-// attempts at the given interval.
+// attempts at the given interval.  If a RetryIfOpt is given, only
+// the errors it accepts are retried.
 func Function(ctx *tool.Context, fn func() error, opts ...RetryOpt) error {
 	attempts, interval := defaultAttempts, defaultInterval
+	backoff := false
+	retryIf := func(error) bool { return true }
 	for _, opt := range opts {
 		switch typedOpt := opt.(type) {
 		case AttemptsOpt:
 			attempts = int(typedOpt)
 		case IntervalOpt:
 			interval = time.Duration(typedOpt)
+		case BackoffOpt:
+			backoff = bool(typedOpt)
+		case RetryIfOpt:
+			retryIf = typedOpt
 		}
 	}
 
@@ -51,10 +72,18 @@ func Function(ctx *tool.Context, fn func() error, opts ...RetryOpt) error {
 		if err = fn(); err == nil {
 			return nil
 		}
+		if !retryIf(err) {
+			return err
+		}
 		fmt.Fprintf(ctx.Stderr(), "%v\n", err)
 		if i < attempts {
-			fmt.Fprintf(ctx.Stdout(), "Wait for %v before next attempt...\n", interval)
-			time.Sleep(interval)
+			wait := interval
+			if backoff {
+				wait = interval << uint(i-1)
+				wait += time.Duration(rand.Int63n(int64(wait/2) + 1))
+			}
+			fmt.Fprintf(ctx.Stdout(), "Wait for %v before next attempt...\n", wait)
+			time.Sleep(wait)
 		}
 	}
 	return fmt.Errorf("Failed %d times in a row. Last error:\n%v", attempts, err)
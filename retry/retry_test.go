@@ -0,0 +1,77 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package retry
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"fuchsia.googlesource.com/jiri/tool"
+)
+
+func newContext() *tool.Context {
+	return tool.NewContext(tool.ContextOpts{Stdout: ioutil.Discard, Stderr: ioutil.Discard})
+}
+
+func TestFunction(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name     string
+		failures int
+		opts     []RetryOpt
+		attempts int
+		err      bool
+	}{
+		{"success", 0, nil, 1, false},
+		{"success after failures", 2, nil, 3, false},
+		{"too many failures", 5, nil, 3, true},
+		{"more attempts", 5, []RetryOpt{AttemptsOpt(6)}, 6, false},
+		{"backoff", 3, []RetryOpt{AttemptsOpt(4), BackoffOpt(true)}, 4, false},
+		{"retry if true", 1, []RetryOpt{RetryIfOpt(func(error) bool { return true })}, 2, false},
+		{"retry if false", 2, []RetryOpt{RetryIfOpt(func(error) bool { return false })}, 1, true},
+	}
+	for _, test := range tests {
+		attempts := 0
+		fn := func() error {
+			attempts++
+			if attempts <= test.failures {
+				return errFailed
+			}
+			return nil
+		}
+		opts := append([]RetryOpt{IntervalOpt(time.Millisecond)}, test.opts...)
+		err := Function(newContext(), fn, opts...)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.err)
+		}
+		if attempts != test.attempts {
+			t.Errorf("%s: got %d attempts, want %d", test.name, attempts, test.attempts)
+		}
+	}
+}
+
+// TestRetryIfReturnsError checks that errors which are not retried are
+// returned unchanged.
+func TestRetryIfReturnsError(t *testing.T) {
+	errPermanent := errors.New("permanent")
+	errTransient := errors.New("transient")
+	attempts := 0
+	fn := func() error {
+		attempts++
+		if attempts == 1 {
+			return errTransient
+		}
+		return errPermanent
+	}
+	retryIf := RetryIfOpt(func(err error) bool { return err == errTransient })
+	if err := Function(newContext(), fn, IntervalOpt(time.Millisecond), retryIf); err != errPermanent {
+		t.Errorf("got error %v, want %v", err, errPermanent)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+}
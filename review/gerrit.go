@@ -0,0 +1,78 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package review
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/gerrit"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+// gerritBackend sends changes to Gerrit by pushing them to refs/for/.
+type gerritBackend struct {
+	jirix   *jiri.X
+	project project.Project
+	host    *url.URL
+}
+
+// Upload pushes the commits to the refs/for/ reference of the remote
+// branch.  Failed pushes return a gerrit.PushError.
+func (g *gerritBackend) Upload(opts UploadOpts) error {
+	p := g.project
+	remoteURL, err := url.Parse(p.Remote)
+	if err != nil {
+		return fmt.Errorf("invalid project remote for project %s(%s): %s", p.Name, p.Path, p.Remote)
+	}
+	remote := *g.host
+	remote.Path = remoteURL.Path
+	clOpts := gerrit.CLOpts{
+		Ccs:          emails(opts.Ccs),
+		Host:         g.host,
+		Presubmit:    opts.Presubmit,
+		RemoteBranch: opts.RemoteBranch,
+		Remote:       remote.String(),
+		Reviewers:    emails(opts.Reviewers),
+		Verify:       opts.Verify,
+		Topic:        opts.Topic,
+		Branch:       opts.Branch,
+	}
+	if clOpts.Presubmit == gerrit.PresubmitTestType("") {
+		clOpts.Presubmit = gerrit.PresubmitTestTypeAll
+	}
+	return gerrit.Push(g.jirix.NewSeq().Dir(p.Path), clOpts)
+}
+
+func (g *gerritBackend) GetChange(number int) (*Change, error) {
+	gg := g.jirix.Gerrit(g.host)
+	change, err := gg.GetChange(number)
+	if err != nil {
+		return nil, err
+	}
+	return &Change{
+		Number: change.Number,
+		Ref:    change.Reference(),
+		Branch: change.Branch,
+		Status: change.Status,
+		Topic:  change.Topic,
+		URL:    gg.GetChangeURL(change.Number),
+	}, nil
+}
+
+// emails turns names into email addresses: names which are Google LDAPs
+// get the suffix @google.com.
+func emails(names []string) []string {
+	var result []string
+	for _, name := range names {
+		if !strings.Contains(name, "@") {
+			name += "@google.com"
+		}
+		result = append(result, name)
+	}
+	return result
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package review
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/collect"
+	"fuchsia.googlesource.com/jiri/credentials"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+var pullRefRE = regexp.MustCompile(`^refs/pull/(\d+)/head$`)
+
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// PullRequestRef returns the reference pull request number can be fetched
+// from.
+func PullRequestRef(number int) string {
+	return fmt.Sprintf("refs/pull/%d/head", number)
+}
+
+// ParsePullRequestRef returns the number of the pull request ref can be
+// fetched from, or false if ref is not a pull request reference.
+func ParsePullRequestRef(ref string) (int, bool) {
+	matches := pullRefRE.FindStringSubmatch(ref)
+	if matches == nil {
+		return 0, false
+	}
+	number, err := strconv.Atoi(matches[1])
+	return number, err == nil
+}
+
+// pullRequestBranchPrefix prefixes the branches pull requests are made from,
+// so that they never clash with the branches of the project.
+const pullRequestBranchPrefix = "jiri/"
+
+// pullRequestBackend sends changes as pull requests: the commits are pushed
+// to a branch of the project remote, and a pull request from that branch is
+// opened through the REST API of the forge, unless there is one already.
+type pullRequestBackend struct {
+	jirix   *jiri.X
+	project project.Project
+	api     *url.URL
+}
+
+// pullRequest is the part of a pull request of the REST API jiri uses.
+type pullRequest struct {
+	Number  int    `json:"number"`
+	State   string `json:"state"`
+	Merged  bool   `json:"merged"`
+	Title   string `json:"title"`
+	Body    string `json:"body,omitempty"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+// repository returns the name of the repository of project p on the forge,
+// e.g. "owner/name", which is the path of its remote.
+func repository(p project.Project) (string, error) {
+	u, err := url.Parse(p.Remote)
+	if err != nil {
+		return "", fmt.Errorf("invalid project remote for project %s(%s): %s", p.Name, p.Path, p.Remote)
+	}
+	name := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if name == "" {
+		return "", fmt.Errorf("cannot find the repository of project %s(%s) in its remote %q", p.Name, p.Path, p.Remote)
+	}
+	return name, nil
+}
+
+// Upload pushes the commits to the branch "jiri/<topic>", or
+// "jiri/<local branch>" if there is no topic, and opens a pull request from
+// it.  The title and description of the pull request are taken from the
+// oldest commit.  Pushing to the branch of an open pull request updates it;
+// the push fails if someone else pushed to the branch since it was last
+// fetched.
+func (b *pullRequestBackend) Upload(opts UploadOpts) error {
+	p := b.project
+	repo, err := repository(p)
+	if err != nil {
+		return err
+	}
+	name := opts.Topic
+	if name == "" {
+		name = opts.Branch
+	}
+	head := pullRequestBranchPrefix + name
+	if head == opts.RemoteBranch {
+		return fmt.Errorf("cannot send a pull request from branch %q of project %s(%s) to itself, set another topic with -topic", head, p.Name, p.Path)
+	}
+	scm := gitutil.New(b.jirix, gitutil.RootDirOpt(p.Path))
+	commits, err := scm.Log("HEAD", "origin/"+opts.RemoteBranch, "%B")
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("no commits to upload for project %s(%s)", p.Name, p.Path)
+	}
+	// Pushing to origin rather than to its url makes git use and update the
+	// remote-tracking branch for the lease.
+	if err := scm.Push("origin", "HEAD:refs/heads/"+head, gitutil.ForceWithLeaseOpt(true), gitutil.VerifyOpt(opts.Verify)); err != nil {
+		return err
+	}
+
+	var pulls []pullRequest
+	owner := strings.Split(repo, "/")[0]
+	query := url.Values{"state": {"open"}, "head": {owner + ":" + head}, "base": {opts.RemoteBranch}}
+	if err := b.request("GET", "/repos/"+repo+"/pulls", query, nil, &pulls); err != nil {
+		return err
+	}
+	var pull pullRequest
+	if len(pulls) > 0 {
+		pull = pulls[0]
+		fmt.Fprintf(b.jirix.Stdout(), "Updated pull request %s\n", pull.HTMLURL)
+	} else {
+		message := commits[len(commits)-1]
+		data := map[string]string{
+			"title": message[0],
+			"body":  strings.TrimSpace(strings.Join(message[1:], "\n")),
+			"head":  head,
+			"base":  opts.RemoteBranch,
+		}
+		if err := b.request("POST", "/repos/"+repo+"/pulls", nil, data, &pull); err != nil {
+			return err
+		}
+		fmt.Fprintf(b.jirix.Stdout(), "Created pull request %s\n", pull.HTMLURL)
+	}
+	if reviewers := append(append([]string{}, opts.Reviewers...), opts.Ccs...); len(reviewers) > 0 {
+		path := fmt.Sprintf("/repos/%s/pulls/%d/requested_reviewers", repo, pull.Number)
+		if err := b.request("POST", path, nil, map[string][]string{"reviewers": reviewers}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChange returns the pull request with the given number.  Closed pull
+// requests which are not merged are reported as abandoned.
+func (b *pullRequestBackend) GetChange(number int) (*Change, error) {
+	repo, err := repository(b.project)
+	if err != nil {
+		return nil, err
+	}
+	var pull pullRequest
+	if err := b.request("GET", fmt.Sprintf("/repos/%s/pulls/%d", repo, number), nil, nil, &pull); err != nil {
+		return nil, err
+	}
+	status := "NEW"
+	if pull.Merged {
+		status = "MERGED"
+	} else if pull.State == "closed" {
+		status = "ABANDONED"
+	}
+	return &Change{
+		Number: pull.Number,
+		Ref:    PullRequestRef(pull.Number),
+		Branch: pull.Base.Ref,
+		Status: status,
+		URL:    pull.HTMLURL,
+	}, nil
+}
+
+// request sends a request with the given method to the given path of the
+// REST API, with data encoded as JSON as its body unless it is nil, and
+// decodes the JSON response into result unless it is nil.
+func (b *pullRequestBackend) request(method, path string, query url.Values, data, result interface{}) (e error) {
+	u := *b.api
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	var body []byte
+	if data != nil {
+		var err error
+		if body, err = json.Marshal(data); err != nil {
+			return fmt.Errorf("Marshal(%#v) failed: %v", data, err)
+		}
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("NewRequest(%q, %q) failed: %v", method, u.String(), err)
+	}
+	req.Header.Add("Accept", "application/json")
+	if data != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	credentials.Authenticate(req)
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v", method, u.String(), err)
+	}
+	defer collect.Error(func() error { return res.Body.Close() }, &e)
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v", method, u.String(), err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed: %d %s: %s", method, u.String(), res.StatusCode, http.StatusText(res.StatusCode), strings.TrimSpace(string(content)))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(content, result); err != nil {
+		return fmt.Errorf("invalid response to %s %s: %v", method, u.String(), err)
+	}
+	return nil
+}
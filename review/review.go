@@ -0,0 +1,115 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package review sends changes to the code review system of a project and
+// looks them up.  Projects use Gerrit unless their manifest sets the
+// "reviewtype" attribute.
+package review
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"fuchsia.googlesource.com/jiri"
+	"fuchsia.googlesource.com/jiri/gerrit"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+const (
+	// TypeGerrit sends changes to Gerrit.
+	TypeGerrit = "gerrit"
+	// TypePullRequest sends changes as pull requests to a forge with a
+	// GitHub-style REST API.
+	TypePullRequest = "pullrequest"
+)
+
+// ErrNoHost is returned by New for projects without a review host.
+var ErrNoHost = errors.New("no review host")
+
+// Change is a change under review.
+type Change struct {
+	Number int
+	// Ref is the reference the change can be fetched from.
+	Ref string
+	// Branch is the branch the change is meant for.
+	Branch string
+	// Status is "NEW", "MERGED" or "ABANDONED", as in Gerrit.
+	Status string
+	Topic  string
+	URL    string
+}
+
+// UploadOpts describes the commits to upload.
+type UploadOpts struct {
+	// Branch is the local branch whose commits are uploaded.
+	Branch string
+	// RemoteBranch is the branch the commits are meant for.
+	RemoteBranch string
+	Topic        string
+	// Reviewers and Ccs are the users asked to review the change.  Gerrit
+	// turns bare names into @google.com emails; forges take user names.
+	Reviewers []string
+	Ccs       []string
+	Presubmit gerrit.PresubmitTestType
+	// Verify runs the pre-push git hooks.
+	Verify bool
+}
+
+// Backend is the code review system of a project.
+type Backend interface {
+	// Upload pushes the current commit of the project and its ancestors for
+	// review.
+	Upload(opts UploadOpts) error
+	// GetChange returns the change of the project with the given number.
+	GetChange(number int) (*Change, error)
+}
+
+// Type returns the review type of project p.
+func Type(p project.Project) string {
+	if p.ReviewType == "" {
+		return TypeGerrit
+	}
+	return p.ReviewType
+}
+
+// Host returns the review host of project p.
+func Host(p project.Project) string {
+	if p.ReviewHost == "" && Type(p) == TypeGerrit {
+		return p.GerritHost
+	}
+	return p.ReviewHost
+}
+
+// HostAttribute returns the manifest attribute setting the review host of
+// project p, for use in error messages.
+func HostAttribute(p project.Project) string {
+	if Type(p) == TypeGerrit {
+		return "gerrithost"
+	}
+	return "reviewhost"
+}
+
+// New returns the code review system of project p, reached at host, or at
+// the review host of p if host is empty.
+func New(jirix *jiri.X, p project.Project, host string) (Backend, error) {
+	if host == "" {
+		host = Host(p)
+	}
+	if host == "" {
+		return nil, ErrNoHost
+	}
+	hostURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid review host for project %s(%s) %q: %v", p.Name, p.Path, host, err)
+	}
+	switch t := Type(p); t {
+	case TypeGerrit:
+		return &gerritBackend{jirix, p, hostURL}, nil
+	case TypePullRequest:
+		return &pullRequestBackend{jirix, p, hostURL}, nil
+	default:
+		return nil, fmt.Errorf("unknown review type %q for project %s(%s)", t, p.Name, p.Path)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 The Fuchsia Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package review
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"fuchsia.googlesource.com/jiri/git"
+	"fuchsia.googlesource.com/jiri/gitutil"
+	"fuchsia.googlesource.com/jiri/jiritest"
+	"fuchsia.googlesource.com/jiri/project"
+)
+
+// makeProject creates a project with the given review type and host, and
+// commits a change to it on a new local branch.
+func makeProject(t *testing.T, fake *jiritest.FakeJiriRoot, reviewType, host string) project.Project {
+	if err := fake.CreateRemoteProject("r.a"); err != nil {
+		t.Fatal(err)
+	}
+	p := project.Project{
+		Name:         "r.a",
+		Path:         filepath.Join(fake.X.Root, "r.a"),
+		Remote:       fake.Projects["r.a"],
+		RemoteBranch: "master",
+		ReviewType:   reviewType,
+		ReviewHost:   host,
+	}
+	if err := fake.AddProject(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.UpdateUniverse(false); err != nil {
+		t.Fatal(err)
+	}
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("John Doe"), gitutil.UserEmailOpt("john.doe@example.com"))
+	if err := scm.CreateBranchWithUpstream("feature", "origin/master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.Path, "a"), []byte("A"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CommitFile("a", "Add a\n\nThe file a."); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNew(t *testing.T) {
+	jirix, cleanup := jiritest.NewX(t)
+	defer cleanup()
+	tests := []struct {
+		p          project.Project
+		host       string
+		reviewType string
+		err        bool
+	}{
+		{project.Project{GerritHost: "https://review.example.com"}, "https://review.example.com", TypeGerrit, false},
+		{project.Project{GerritHost: "https://review.example.com", ReviewHost: "https://other.example.com"}, "https://other.example.com", TypeGerrit, false},
+		{project.Project{ReviewType: TypePullRequest, GerritHost: "https://review.example.com"}, "", TypePullRequest, true},
+		{project.Project{ReviewType: TypePullRequest, ReviewHost: "https://api.example.com"}, "https://api.example.com", TypePullRequest, false},
+		{project.Project{ReviewType: "mail", ReviewHost: "https://mail.example.com"}, "https://mail.example.com", "mail", true},
+	}
+	for _, test := range tests {
+		if got := Host(test.p); got != test.host {
+			t.Errorf("%+v: got host %q, want %q", test.p, got, test.host)
+		}
+		if got := Type(test.p); got != test.reviewType {
+			t.Errorf("%+v: got type %q, want %q", test.p, got, test.reviewType)
+		}
+		if _, err := New(jirix, test.p, ""); (err != nil) != test.err {
+			t.Errorf("%+v: got error %v, want error %v", test.p, err, test.err)
+		}
+	}
+	if _, err := New(jirix, project.Project{}, ""); err != ErrNoHost {
+		t.Errorf("got error %v, want %v", err, ErrNoHost)
+	}
+	if _, err := New(jirix, project.Project{}, "https://review.example.com"); err != nil {
+		t.Errorf("New with host failed: %v", err)
+	}
+}
+
+func TestPullRequest(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	forge, stop := jiritest.NewFakeForge(t)
+	defer stop()
+	p := makeProject(t, fake, TypePullRequest, forge.URL)
+	backend, err := New(fake.X, p, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := UploadOpts{Branch: "feature", RemoteBranch: "master", Topic: "john-feature", Reviewers: []string{"jane"}, Verify: true}
+	if err := backend.Upload(opts); err != nil {
+		t.Fatal(err)
+	}
+	// Uploading again updates the pull request.
+	if err := backend.Upload(opts); err != nil {
+		t.Fatal(err)
+	}
+	pulls := forge.PullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 pull request, got %+v", pulls)
+	}
+	pr := pulls[0]
+	if pr.Title != "Add a" || pr.Body != "The file a." || pr.Head != "jiri/john-feature" || pr.Base != "master" || pr.State != "open" {
+		t.Errorf("unexpected pull request %+v", pr)
+	}
+	if want := []string{"jane"}; !reflect.DeepEqual(pr.Reviewers, want) {
+		t.Errorf("got reviewers %v, want %v", pr.Reviewers, want)
+	}
+
+	change, err := backend.GetChange(pr.Number)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Ref != "refs/pull/1/head" || change.Branch != "master" || change.Status != "NEW" {
+		t.Errorf("unexpected change %+v", change)
+	}
+	if n, ok := ParsePullRequestRef(change.Ref); !ok || n != 1 {
+		t.Errorf("ParsePullRequestRef(%q) = %v, %v", change.Ref, n, ok)
+	}
+	if err := forge.Merge(pr.Number); err != nil {
+		t.Fatal(err)
+	}
+	if change, err := backend.GetChange(pr.Number); err != nil || change.Status != "MERGED" {
+		t.Errorf("got change %+v, %v, want a merged change", change, err)
+	}
+	if _, err := backend.GetChange(2); err == nil {
+		t.Errorf("expected an error for a missing pull request")
+	}
+}
+
+// TestPullRequestPushSafety checks that uploads never overwrite the base
+// branch or commits someone else pushed.
+func TestPullRequestPushSafety(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	forge, stop := jiritest.NewFakeForge(t)
+	defer stop()
+	p := makeProject(t, fake, TypePullRequest, forge.URL)
+	backend, err := New(fake.X, p, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := git.NewGit(p.Remote)
+	master, err := remote.CurrentRevisionForRef("refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The branch of a pull request is never its base branch.
+	if err := backend.Upload(UploadOpts{Branch: "feature", RemoteBranch: "jiri/x", Topic: "x"}); err == nil {
+		t.Errorf("expected upload with topic x to base jiri/x to fail")
+	}
+	if pulls := forge.PullRequests(); len(pulls) != 0 {
+		t.Errorf("expected no pull request, got %+v", pulls)
+	}
+	// Branches named like the base branch are pushed to a jiri/ branch.
+	if err := backend.Upload(UploadOpts{Branch: "feature", RemoteBranch: "master", Topic: "master"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := remote.CurrentRevisionForRef("refs/heads/master"); err != nil || got != master {
+		t.Errorf("got master at %q, %v, want %q", got, err, master)
+	}
+	if _, err := remote.CurrentRevisionForRef("refs/heads/jiri/master"); err != nil {
+		t.Errorf("expected branch jiri/master: %v", err)
+	}
+
+	opts := UploadOpts{Branch: "feature", RemoteBranch: "master", Topic: "feature"}
+	if err := backend.Upload(opts); err != nil {
+		t.Fatal(err)
+	}
+	// Someone else pushes to the branch of the pull request.
+	scm := gitutil.New(fake.X, gitutil.RootDirOpt(p.Path), gitutil.UserNameOpt("Jane Doe"), gitutil.UserEmailOpt("jane.doe@example.com"))
+	if err := scm.CreateBranchWithUpstream("other", "origin/master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CheckoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.Path, "b"), []byte("B"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CommitFile("b", "Add b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.Push(p.Remote, "HEAD:refs/heads/jiri/feature", gitutil.ForceOpt(true)); err != nil {
+		t.Fatal(err)
+	}
+	other, err := remote.CurrentRevisionForRef("refs/heads/jiri/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := scm.CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Upload(opts); err == nil {
+		t.Errorf("expected upload over someone else's commits to fail")
+	}
+	if got, err := remote.CurrentRevisionForRef("refs/heads/jiri/feature"); err != nil || got != other {
+		t.Errorf("got jiri/feature at %q, %v, want %q", got, err, other)
+	}
+}
+
+func TestGerrit(t *testing.T) {
+	fake, cleanup := jiritest.NewFakeJiriRoot(t)
+	defer cleanup()
+	g, stop := jiritest.NewFakeGerrit(t)
+	defer stop()
+	p := makeProject(t, fake, "", g.URL)
+	backend, err := New(fake.X, p, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Upload(UploadOpts{Branch: "feature", RemoteBranch: "master", Topic: "test", Verify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if pushes := g.Pushes(); len(pushes) != 1 || pushes[0].Ref != "refs/for/master%topic=test" {
+		t.Errorf("unexpected pushes %+v", pushes)
+	}
+	change, err := backend.GetChange(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change.Ref != "refs/changes/01/1/1" || change.Branch != "master" || change.Status != "NEW" || change.Topic != "test" {
+		t.Errorf("unexpected change %+v", change)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"runtime"
 	"syscall"
 
+	"fuchsia.googlesource.com/jiri/credentials"
 	"fuchsia.googlesource.com/jiri/osutil"
 	"fuchsia.googlesource.com/jiri/version"
 )
@@ -108,6 +109,7 @@ func getCurrentCommit(repository string) (string, error) {
 		return "", err
 	}
 	req.Header.Add("Accept", "application/json")
+	credentials.AuthenticateDownload(req)
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
@@ -139,7 +141,12 @@ func getCurrentCommit(repository string) (string, error) {
 
 func hasPrebuilt(bucket, version string) (bool, error) {
 	url := fmt.Sprintf("%s/%s-%s/%s", bucket, runtime.GOOS, runtime.GOARCH, version)
-	res, err := http.Head(url)
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return false, err
+	}
+	credentials.AuthenticateDownload(req)
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -151,7 +158,12 @@ func hasPrebuilt(bucket, version string) (bool, error) {
 
 func downloadBinary(bucket, version string) ([]byte, error) {
 	url := fmt.Sprintf("%s/%s-%s/%s", bucket, runtime.GOOS, runtime.GOARCH, version)
-	res, err := http.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	credentials.AuthenticateDownload(req)
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -32,6 +32,7 @@ const (
 	DefaultCacheSubdir = "cache"
 	ProjectMetaFile    = "metadata.v2"
 	ProjectConfigFile  = "config"
+	ProjectPatchesFile = "patches"
 	JiriManifestFile   = ".jiri_manifest"
 
 	// PreservePathEnv is the name of the environment variable that, when set to a
@@ -333,6 +334,12 @@ func (x *X) UpdateHistoryDir() string {
 	return filepath.Join(x.RootMetaDir(), "update_history")
 }
 
+// UpdateJournalFile returns the path to the journal of the update in
+// progress.
+func (x *X) UpdateJournalFile() string {
+	return filepath.Join(x.RootMetaDir(), "update_journal")
+}
+
 // UpdateHistoryLatestLink returns the path to a symlink that points to the
 // latest update in the update history directory.
 func (x *X) UpdateHistoryLatestLink() string {